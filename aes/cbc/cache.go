@@ -0,0 +1,89 @@
+package cbc
+
+import (
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"sync"
+)
+
+// cipher.Block 的LRU缓存，key为AES密钥
+// 默认关闭，通过 SetBlockCacheSize 开启；AES的Block只读，可在多个goroutine间共享
+type blockCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type blockEntry struct {
+	key   string
+	block cipher.Block
+}
+
+var _cache = &blockCache{ll: list.New(), items: make(map[string]*list.Element)}
+
+// SetBlockCacheSize 设置cipher.Block缓存的最大数量，<=0 表示关闭缓存（并清空已缓存的数据）
+func SetBlockCacheSize(size int) {
+	_cache.mu.Lock()
+	defer _cache.mu.Unlock()
+	_cache.size = size
+	for _cache.ll.Len() > 0 && _cache.ll.Len() > size {
+		_cache.removeOldest()
+	}
+}
+
+// 获取key对应的cipher.Block，开启缓存时优先从缓存中读取
+func getBlock(key []byte) (cipher.Block, error) {
+	_cache.mu.Lock()
+	if _cache.size <= 0 {
+		_cache.mu.Unlock()
+		return aes.NewCipher(key)
+	}
+	if ele, ok := _cache.items[string(key)]; ok {
+		_cache.ll.MoveToFront(ele)
+		block := ele.Value.(*blockEntry).block
+		_cache.mu.Unlock()
+		return block, nil
+	}
+	_cache.mu.Unlock()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	_cache.add(string(key), block)
+	return block, nil
+}
+
+func (c *blockCache) add(key string, block cipher.Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	if ele, ok := c.items[key]; ok {
+		c.ll.MoveToFront(ele)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&blockEntry{key: key, block: block})
+	for c.ll.Len() > c.size {
+		c.removeOldest()
+	}
+}
+
+func (c *blockCache) removeOldest() {
+	ele := c.ll.Back()
+	if ele == nil {
+		return
+	}
+	c.ll.Remove(ele)
+	delete(c.items, ele.Value.(*blockEntry).key)
+}
+
+// 当前缓存的数量（测试使用）
+func (c *blockCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
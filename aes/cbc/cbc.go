@@ -2,7 +2,6 @@ package cbc
 
 import (
 	"bytes"
-	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
@@ -21,41 +20,30 @@ const (
 	ENCRYPT_TYPE_PRIVATE = 2
 )
 
-var _insts = make(map[int]*EncryptCBC)
-
 //EncryptCBC ... AES CBC模式的加密算法
+// 实例创建后不可修改，可以在多个goroutine中并发使用
 type EncryptCBC struct {
 	key       []byte
 	blockSize int
 	ivType    int //
 }
 
-//New ...
+//New ... 每次返回一个新的实例（随机IV），不同key的实例之间互不影响
 func New(key string) *EncryptCBC {
-	if _insts[ENCRYPT_TYPE_COMMON] == nil {
-		inst := new(EncryptCBC)
-		inst.key = []byte(key)
-		inst.blockSize = BLOCK_SIZE
-		inst.ivType = IV_TYPE_RAND
-		_insts[ENCRYPT_TYPE_COMMON] = inst
-	} else {
-		_insts[ENCRYPT_TYPE_COMMON].key = []byte(key)
-	}
-	return _insts[ENCRYPT_TYPE_COMMON]
+	return newEncryptCBC(key, IV_TYPE_RAND)
 }
 
-// NewPriv  , 隐私整改，和Server统一的加密算法
+// NewPriv  , 隐私整改，和Server统一的加密算法（IV取key的前16位）
 func NewPri(key string) *EncryptCBC {
-	if _insts[ENCRYPT_TYPE_PRIVATE] == nil {
-		inst := new(EncryptCBC)
-		inst.key = []byte(key)
-		inst.blockSize = BLOCK_SIZE
-		inst.ivType = IV_TYPE_KEY
-		_insts[ENCRYPT_TYPE_PRIVATE] = inst
-	} else {
-		_insts[ENCRYPT_TYPE_PRIVATE].key = []byte(key)
-	}
-	return _insts[ENCRYPT_TYPE_PRIVATE]
+	return newEncryptCBC(key, IV_TYPE_KEY)
+}
+
+func newEncryptCBC(key string, ivType int) *EncryptCBC {
+	inst := new(EncryptCBC)
+	inst.key = []byte(key) // string 转换会复制一份，调用方后续修改不影响实例
+	inst.blockSize = BLOCK_SIZE
+	inst.ivType = ivType
+	return inst
 }
 
 //Encrypt 加密方法
@@ -114,7 +102,7 @@ func (e *EncryptCBC) DecryptBytes(cipherBytes []byte) (plainBytes []byte, err er
 // https://tools.ietf.org/html/rfc5246#section-6.2.3.2. Here we'll
 // assume that the plaintext is already of the correct length.
 func (e *EncryptCBC) cbcEncypt(plainBytes []byte) (cipherByte []byte, err error) {
-	block, err := aes.NewCipher(e.key)
+	block, err := getBlock(e.key)
 	if err != nil {
 		return
 	}
@@ -147,7 +135,7 @@ func (e *EncryptCBC) cbcEncypt(plainBytes []byte) (cipherByte []byte, err error)
 
 //私有方法，cbc解密
 func (e *EncryptCBC) cbcDecrypt(cipherbytes []byte) (plainbyte []byte, err error) {
-	block, err := aes.NewCipher(e.key)
+	block, err := getBlock(e.key)
 	if err != nil {
 		return
 	}
@@ -180,6 +168,9 @@ func (e *EncryptCBC) cbcDecrypt(cipherbytes []byte) (plainbyte []byte, err error
 	// critical to note that ciphertexts must be authenticated (i.e. by
 	// using crypto/hmac) before being decrypted in order to avoid creating
 	// a padding oracle.
+	if !validPadding(plainbyte, e.blockSize) {
+		return nil, fmt.Errorf("invalid padding, key may be wrong")
+	}
 	plainbyte = e.PKCS5UnPadding(plainbyte)
 	return
 }
@@ -188,7 +179,10 @@ func (e *EncryptCBC) cbcDecrypt(cipherbytes []byte) (plainbyte []byte, err error
 func (e *EncryptCBC) PKCS5Padding(ciphertext []byte, blockSize int) []byte {
 	padding := blockSize - len(ciphertext)%blockSize                 //长度不是blockSize时补齐个数
 	padtext := bytes.Repeat([]byte{byte(padding + OFFSET)}, padding) //至少补一个
-	return append(ciphertext, padtext...)
+	// 复制一份，避免append写入调用方切片的底层数组（并发场景下会互相覆盖）
+	ret := make([]byte, 0, len(ciphertext)+padding)
+	ret = append(ret, ciphertext...)
+	return append(ret, padtext...)
 }
 
 //PKCS5UnPadding ...
@@ -198,3 +192,22 @@ func (e *EncryptCBC) PKCS5UnPadding(origData []byte) []byte {
 	unpadding := int(origData[length-1]) - OFFSET //最后一个字节是补充的，值是补充的个数，可以自定义规则
 	return origData[:(length - unpadding)]
 }
+
+// 校验补齐字节是否合法，避免错误的key导致 PKCS5UnPadding 越界
+func validPadding(origData []byte, blockSize int) bool {
+	length := len(origData)
+	if length == 0 {
+		return false
+	}
+	unpadding := int(origData[length-1]) - OFFSET
+	if unpadding <= 0 || unpadding > blockSize || unpadding > length {
+		return false
+	}
+	// 补齐的字节都相同
+	for _, c := range origData[length-unpadding:] {
+		if c != origData[length-1] {
+			return false
+		}
+	}
+	return true
+}
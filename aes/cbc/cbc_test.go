@@ -1,6 +1,8 @@
 package cbc
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 )
 
@@ -18,3 +20,56 @@ func Test_Decrypt(t *testing.T) {
 		t.Error("解密方法测试失败")
 	}
 }
+
+// 不同key的实例并发使用，互相不影响（go test -race）
+func Test_ConcurrentKeys(t *testing.T) {
+	keys := []string{"1234567890123456", "abcdefghijklmnop", "ABCDEFGHIJKLMNOPQRSTUVWXYZ012345"}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string, i int) {
+				defer wg.Done()
+				plain := fmt.Sprintf("HelloWord!%s-%d", key, i)
+				for _, inst := range []*EncryptCBC{New(key), NewPri(key)} {
+					cipherstr, err := inst.Encrypt(plain)
+					if err != nil {
+						t.Error(err.Error())
+						return
+					}
+					if plainstr, err := inst.Decrypt(cipherstr); err != nil || plainstr != plain {
+						t.Errorf("key[%s] 并发加解密失败: %s, %v", key, plainstr, err)
+					}
+				}
+			}(key, i)
+		}
+	}
+	wg.Wait()
+}
+
+// 开启Block缓存后并发使用，以及LRU淘汰
+func Test_BlockCache(t *testing.T) {
+	SetBlockCacheSize(2)
+	defer SetBlockCacheSize(0)
+	keys := []string{"1234567890123456", "abcdefghijklmnop", "ABCDEFGHIJKLMNOP"}
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			cipherstr, _ := NewPri(key).Encrypt("HelloWord!1503113870")
+			if plainstr, _ := NewPri(key).Decrypt(cipherstr); plainstr != "HelloWord!1503113870" {
+				t.Error("缓存模式加解密失败")
+			}
+		}(keys[i%len(keys)])
+	}
+	wg.Wait()
+	if _cache.len() > 2 {
+		t.Errorf("cache size %d exceed 2", _cache.len())
+	}
+	// 错误的key解密返回错误，不能panic（NewPri 的IV固定，结果确定）
+	cipherstr, _ := NewPri(keys[0]).Encrypt("HelloWord!1503113870")
+	if plainstr, err := NewPri(keys[1]).Decrypt(cipherstr); err == nil {
+		t.Errorf("expect padding error of wrong key, got %q", plainstr)
+	}
+}
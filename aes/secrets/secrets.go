@@ -0,0 +1,91 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/aes/cbc"
+)
+
+// 配置项中主密钥的来源（按优先级）
+// [section]
+// PwdEncryptKeyEnv = DB_MASTER_KEY        # 从环境变量读取
+// PwdEncryptKeyFile = /etc/app/db.key     # 从密钥文件读取（首尾空白会被去掉）
+// PwdEncryptKey = 1234567890123456        # 直接写在配置文件中（兼容老配置，不推荐）
+const (
+	KeyEnvConfName  = "PwdEncryptKeyEnv"
+	KeyFileConfName = "PwdEncryptKeyFile"
+	KeyConfName     = "PwdEncryptKey"
+)
+
+var ErrKeyNotFound = errors.New("secrets: master key not found")
+
+// Resolve 读取配置项 section::key 的值，如果section配置了主密钥，则解密后返回明文
+func Resolve(section, key string) (value string, err error) {
+	value = beego.AppConfig.String(section + "::" + key)
+	var masterKey string
+	if masterKey, err = SectionKey(section); err != nil {
+		if err == ErrKeyNotFound {
+			// 未配置主密钥，配置值就是明文
+			return value, nil
+		}
+		return "", err
+	}
+	if value, err = Decrypt(masterKey, value); err != nil {
+		return "", fmt.Errorf("decrypt %s::%s failed, %s", section, key, err.Error())
+	}
+	return
+}
+
+// SectionKey 获取section配置的主密钥
+func SectionKey(section string) (string, error) {
+	return LoadKey(beego.AppConfig.String(section+"::"+KeyEnvConfName),
+		beego.AppConfig.String(section+"::"+KeyFileConfName),
+		beego.AppConfig.String(section+"::"+KeyConfName))
+}
+
+// LoadKey 按 环境变量 > 密钥文件 > 明文key 的顺序获取主密钥，参数为空表示不使用该来源
+func LoadKey(envName, keyFile, key string) (string, error) {
+	if envName != "" {
+		if v := strings.TrimSpace(os.Getenv(envName)); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("secrets: env %s is empty", envName)
+	}
+	if keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", fmt.Errorf("secrets: read key file failed, %s", err.Error())
+		}
+		if v := strings.TrimSpace(string(b)); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("secrets: key file %s is empty", keyFile)
+	}
+	if key != "" {
+		return key, nil
+	}
+	return "", ErrKeyNotFound
+}
+
+// Encrypt 加密配置值（与 cbc.New(key).Decrypt 兼容）
+func Encrypt(masterKey, plaintext string) (string, error) {
+	return cbc.New(masterKey).Encrypt(plaintext)
+}
+
+// Decrypt 解密配置值
+func Decrypt(masterKey, ciphertext string) (string, error) {
+	return cbc.New(masterKey).Decrypt(ciphertext)
+}
+
+// Rotate 用新的主密钥重新加密配置值
+func Rotate(oldKey, newKey, ciphertext string) (string, error) {
+	plaintext, err := Decrypt(oldKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return Encrypt(newKey, plaintext)
+}
@@ -0,0 +1,37 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Rotate(t *testing.T) {
+	ciphertext, err := Encrypt("1234567890123456", "db-passwd")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if ciphertext, err = Rotate("1234567890123456", "abcdefghijklmnop", ciphertext); err != nil {
+		t.Fatal(err.Error())
+	}
+	if plaintext, _ := Decrypt("abcdefghijklmnop", ciphertext); plaintext != "db-passwd" {
+		t.Error("轮换密钥后解密失败")
+	}
+}
+
+func Test_LoadKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	os.WriteFile(keyFile, []byte("abcdefghijklmnop\n"), 0600)
+	os.Setenv("SECRETS_TEST_KEY", "1234567890123456")
+	defer os.Unsetenv("SECRETS_TEST_KEY")
+
+	if key, _ := LoadKey("SECRETS_TEST_KEY", keyFile, "x"); key != "1234567890123456" {
+		t.Error("环境变量优先级错误")
+	}
+	if key, _ := LoadKey("", keyFile, "x"); key != "abcdefghijklmnop" {
+		t.Error("读取密钥文件失败")
+	}
+	if _, err := LoadKey("", "", ""); err != ErrKeyNotFound {
+		t.Error("未配置密钥应该返回 ErrKeyNotFound")
+	}
+}
@@ -0,0 +1,251 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 分块认证加密（AES-GCM）的流式格式，用于大文件加解密，不需要把文件全部读入内存
+//
+// 文件头(16字节): magic(4) | version(1) | chunkSize(4) | noncePrefix(7)
+// 数据块: flag(1) | 密文长度(4) | 密文(含16字节tag)
+// 每块的nonce为 noncePrefix(7) | 块序号(4) | flag(1)，文件头作为附加认证数据，
+// 块被调换顺序、篡改或者末尾被截断都会在解密时报错
+const (
+	DefaultChunkSize = 64 * 1024 // 默认每块明文大小
+	MaxChunkSize     = 16 * 1024 * 1024
+
+	headerSize      = 16
+	frameHeaderSize = 5
+	noncePrefixSize = 7
+	version         = 1
+
+	flagNormal = 0
+	flagLast   = 1
+)
+
+var magic = []byte("DMSE")
+
+var (
+	ErrInvalidHeader = errors.New("stream: invalid header")
+	ErrTruncated     = errors.New("stream: ciphertext truncated")
+	ErrAuthFailed    = errors.New("stream: message authentication failed")
+	ErrClosed        = errors.New("stream: write to closed writer")
+)
+
+// 加密写入对象
+type encryptingWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+	err     error
+}
+
+// NewEncryptingWriter 返回一个加密写入对象，写入的明文分块加密后写到w中
+// 必须调用Close写入最后一块，否则解密时会报 ErrTruncated；Close不会关闭w
+func NewEncryptingWriter(w io.Writer, key string, chunkSize ...int) (io.WriteCloser, error) {
+	size := DefaultChunkSize
+	if len(chunkSize) > 0 && chunkSize[0] > 0 {
+		size = chunkSize[0]
+	}
+	if size > MaxChunkSize {
+		return nil, fmt.Errorf("stream: chunk size %d exceed %d", size, MaxChunkSize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[4] = version
+	binary.BigEndian.PutUint32(header[5:9], uint32(size))
+	if _, err = io.ReadFull(rand.Reader, header[9:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: header[9:],
+		buf:    make([]byte, 0, size),
+	}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (n int, err error) {
+	if e.closed {
+		return 0, ErrClosed
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	for len(p) > 0 {
+		// 缓冲区满了且还有数据，说明当前块不是最后一块，可以写出
+		if len(e.buf) == cap(e.buf) {
+			if err = e.flush(flagNormal); err != nil {
+				return
+			}
+		}
+		m := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		n += m
+		p = p[m:]
+	}
+	return
+}
+
+// Close 写入最后一块数据
+func (e *encryptingWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if e.err != nil {
+		return e.err
+	}
+	return e.flush(flagLast)
+}
+
+func (e *encryptingWriter) flush(flag byte) (err error) {
+	if e.counter == ^uint32(0) {
+		e.err = errors.New("stream: too many chunks")
+		return e.err
+	}
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(e.buf)+e.aead.Overhead())
+	frame = e.aead.Seal(frame, nonce(e.prefix, e.counter, flag), e.buf, e.header)
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(frame)-frameHeaderSize))
+	if _, err = e.w.Write(frame); err != nil {
+		e.err = err
+		return
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return
+}
+
+// 解密读取对象
+type decryptingReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	header    []byte
+	prefix    []byte
+	chunkSize int
+	plain     []byte
+	frame     []byte
+	counter   uint32
+	done      bool
+	err       error
+}
+
+// NewDecryptingReader 返回一个解密读取对象，从r中读取 NewEncryptingWriter 生成的密文并逐块解密
+// 每一块都会先校验再返回明文，不会返回未认证的数据
+func NewDecryptingReader(r io.Reader, key string) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidHeader
+		}
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], magic) || header[4] != version {
+		return nil, ErrInvalidHeader
+	}
+	size := int(binary.BigEndian.Uint32(header[5:9]))
+	if size <= 0 || size > MaxChunkSize {
+		return nil, ErrInvalidHeader
+	}
+	return &decryptingReader{
+		r:         r,
+		aead:      aead,
+		header:    header,
+		prefix:    header[9:],
+		chunkSize: size,
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (n int, err error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.readChunk()
+	}
+	n = copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return
+}
+
+func (d *decryptingReader) readChunk() (err error) {
+	var fh [frameHeaderSize]byte
+	if _, err = io.ReadFull(d.r, fh[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return
+	}
+	flag := fh[0]
+	length := int(binary.BigEndian.Uint32(fh[1:]))
+	if flag > flagLast || length < d.aead.Overhead() || length > d.chunkSize+d.aead.Overhead() {
+		return ErrAuthFailed
+	}
+	if cap(d.frame) < length {
+		d.frame = make([]byte, length)
+	}
+	d.frame = d.frame[:length]
+	if _, err = io.ReadFull(d.r, d.frame); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return
+	}
+	// 解密结果复用密文的缓冲区
+	if d.plain, err = d.aead.Open(d.frame[:0], nonce(d.prefix, d.counter, flag), d.frame, d.header); err != nil {
+		return ErrAuthFailed
+	}
+	d.counter++
+	if flag == flagLast {
+		d.done = true
+	}
+	return nil
+}
+
+// IsEncrypted 判断数据是否为加密格式（只比较文件头的magic）
+func IsEncrypted(head []byte) bool {
+	return len(head) >= len(magic) && bytes.Equal(head[:len(magic)], magic)
+}
+
+func newAEAD(key string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32, flag byte) []byte {
+	n := make([]byte, noncePrefixSize+5)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[noncePrefixSize:], counter)
+	n[noncePrefixSize+4] = flag
+	return n
+}
@@ -0,0 +1,72 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+const testKey = "1234567890123456"
+
+func encrypt(t *testing.T, plain []byte, chunkSize int) []byte {
+	var buf bytes.Buffer
+	w, err := NewEncryptingWriter(&buf, testKey, chunkSize)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// 分多次写入，覆盖块边界
+	for i := 0; i < len(plain); i += 7 {
+		end := i + 7
+		if end > len(plain) {
+			end = len(plain)
+		}
+		if _, err = w.Write(plain[i:end]); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return buf.Bytes()
+}
+
+func decrypt(cipherBytes []byte) ([]byte, error) {
+	r, err := NewDecryptingReader(bytes.NewReader(cipherBytes), testKey)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func Test_RoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 15, 16, 17, 64, 1000} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		got, err := decrypt(encrypt(t, plain, 16))
+		if err != nil {
+			t.Fatalf("size %d decrypt failed, %s", size, err.Error())
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d 解密结果不一致", size)
+		}
+	}
+}
+
+func Test_Tamper(t *testing.T) {
+	plain := bytes.Repeat([]byte("HelloWord!"), 20)
+	cipherBytes := encrypt(t, plain, 32)
+	tampered := append([]byte{}, cipherBytes...)
+	tampered[headerSize+frameHeaderSize+3] ^= 0x01
+	if _, err := decrypt(tampered); err != ErrAuthFailed {
+		t.Errorf("篡改后应该校验失败, got %v", err)
+	}
+	// 截断到某一块的边界
+	frameLen := frameHeaderSize + 32 + 16
+	if _, err := decrypt(cipherBytes[:headerSize+frameLen]); err != ErrTruncated {
+		t.Errorf("截断后应该报错, got %v", err)
+	}
+	if _, err := NewDecryptingReader(bytes.NewReader(plain), testKey); err != ErrInvalidHeader {
+		t.Errorf("明文应该识别为非法文件头, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+// migrate 执行内置的数据库迁移（op_log、step_handlers、attachs、rbac），读取 conf/app.conf 中的数据库配置
+//
+//	migrate [-db DB] up [-to version]
+//	migrate [-db DB] down [-steps 1]
+//	migrate [-db DB] status
+//
+// 应用有自己的迁移时，在应用的命令行中 import 注册迁移的包后调用 migrate.Run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/daimall/tools/curd/attach"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/migrate"
+	_ "github.com/daimall/tools/curd/flow/v1/flowservice"
+	_ "github.com/daimall/tools/curd/oplog"
+	_ "github.com/daimall/tools/curd/rbac"
+)
+
+func main() {
+	section := flag.String("db", dbgorm.DefaultName, "数据库配置节")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage:
+  migrate [-db DB] up [-to version] [-wait 1m]
+  migrate [-db DB] down [-steps 1] [-wait 1m]
+  migrate [-db DB] status`)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	db, err := dbgorm.Get(*section)
+	if err == nil {
+		err = migrate.Run(context.Background(), db, flag.Args(), os.Stdout)
+		dbgorm.CloseAll()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err.Error())
+		os.Exit(1)
+	}
+}
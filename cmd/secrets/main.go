@@ -0,0 +1,145 @@
+// secrets 配置值加解密工具，生成 dbgorm/redisclient/ldapm 等使用的加密密码
+//
+//	secrets encrypt -key-env DB_MASTER_KEY <plaintext>
+//	secrets decrypt -key-file /etc/app/db.key <ciphertext>
+//	secrets rotate -key-file old.key -new-key-file new.key <ciphertext>
+//	secrets genkey
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/daimall/tools/aes/secrets"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "encrypt", "decrypt":
+		err = crypt(os.Args[1], os.Args[2:])
+	case "rotate":
+		err = rotate(os.Args[2:])
+	case "genkey":
+		var key string
+		if key, err = genKey(32); err == nil {
+			fmt.Println(key)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  secrets encrypt [-key k | -key-env NAME | -key-file PATH] [value]
+  secrets decrypt [-key k | -key-env NAME | -key-file PATH] [value]
+  secrets rotate  [-key ... ] [-new-key k | -new-key-env NAME | -new-key-file PATH] [value]
+  secrets genkey
+value 为空时从标准输入逐行读取`)
+}
+
+type keyFlags struct {
+	key, env, file *string
+}
+
+func addKeyFlags(fs *flag.FlagSet, prefix string) keyFlags {
+	return keyFlags{
+		key:  fs.String(prefix+"key", "", "master key (不推荐，会留在shell历史中)"),
+		env:  fs.String(prefix+"key-env", "", "从环境变量读取master key"),
+		file: fs.String(prefix+"key-file", "", "从文件读取master key"),
+	}
+}
+
+func (k keyFlags) load() (string, error) {
+	return secrets.LoadKey(*k.env, *k.file, *k.key)
+}
+
+func crypt(action string, args []string) (err error) {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	kf := addKeyFlags(fs, "")
+	fs.Parse(args)
+	var key string
+	if key, err = kf.load(); err != nil {
+		return
+	}
+	return eachValue(fs.Args(), func(v string) (string, error) {
+		if action == "encrypt" {
+			return secrets.Encrypt(key, v)
+		}
+		return secrets.Decrypt(key, v)
+	})
+}
+
+func rotate(args []string) (err error) {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	oldKf := addKeyFlags(fs, "")
+	newKf := addKeyFlags(fs, "new-")
+	fs.Parse(args)
+	var oldKey, newKey string
+	if oldKey, err = oldKf.load(); err != nil {
+		return
+	}
+	if newKey, err = newKf.load(); err != nil {
+		return fmt.Errorf("load new key failed, %s", err.Error())
+	}
+	return eachValue(fs.Args(), func(v string) (string, error) {
+		return secrets.Rotate(oldKey, newKey, v)
+	})
+}
+
+// 处理命令行参数中的值，没有参数时从标准输入读取（避免明文出现在shell历史中）
+func eachValue(values []string, f func(string) (string, error)) error {
+	if len(values) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if v := strings.TrimSpace(scanner.Text()); v != "" {
+				values = append(values, v)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	for _, v := range values {
+		out, err := f(v)
+		if err != nil {
+			return err
+		}
+		fmt.Println(out)
+	}
+	return nil
+}
+
+// 生成随机的master key（AES-256 需要32位）
+func genKey(n int) (string, error) {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// 拒绝采样：丢弃 >= 248（62的整数倍）的随机字节，避免取模偏差
+	const limit = 256 - 256%len(letters)
+	key := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(key) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(key) < n {
+				key = append(key, letters[int(c)%len(letters)])
+			}
+		}
+	}
+	return string(key), nil
+}
@@ -1,6 +1,7 @@
 package attach
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/aes/stream"
 	"github.com/daimall/tools/curd/common"
 	"github.com/daimall/tools/curd/dbmysql/dbgorm"
 	"github.com/daimall/tools/tusclient"
@@ -64,7 +66,11 @@ func UploadAttachs(files []*multipart.FileHeader, obj, sub string, objId uint, t
 			return
 		}
 		// 记录入库
-		if err = batchSaveAttach(dbgorm.GetDBInst(), attachs); err != nil {
+		var db *gorm.DB
+		if db, err = dbgorm.Get(dbgorm.DefaultName); err == nil {
+			err = batchSaveAttach(db, attachs)
+		}
+		if err != nil {
 			logs.Error("batchSaveAttach failed,", err.Error())
 			os.RemoveAll(dir)
 		}
@@ -130,7 +136,11 @@ func DeleteAttachs(p interface{}) (err error) {
 	}
 	if len(ids) > 0 {
 		attach := Attach{}
-		if err = dbgorm.GetDBInst().Where("id in (?)", ids).Delete(&attach).Error; err != nil {
+		var db *gorm.DB
+		if db, err = dbgorm.Get(dbgorm.DefaultName); err != nil {
+			return
+		}
+		if err = db.Where("id in (?)", ids).Delete(&attach).Error; err != nil {
 			logs.Error("delete attach%+v failed, %s", ids, err.Error())
 			return
 		}
@@ -180,7 +190,8 @@ func saveFiletoLocal(files []*multipart.FileHeader, path string, obj string, obj
 			return nil, "", err
 		}
 		defer f.Close()
-		if _, err = io.Copy(f, oFile); err != nil {
+		if err = copyToLocal(f, oFile); err != nil {
+			logs.Error("save attach file[%s] fail, %s", file.Filename, err.Error())
 			return nil, "", err
 		}
 		attach.URL = filepath.Join(path, uuidpath, file.Filename)
@@ -189,6 +200,50 @@ func saveFiletoLocal(files []*multipart.FileHeader, path string, obj string, obj
 	return
 }
 
+// 写入本地文件，配置了 FileServer::EncryptKey 时加密存储
+func copyToLocal(dst io.Writer, src io.Reader) (err error) {
+	key := beego.AppConfig.String("FileServer::EncryptKey")
+	if key == "" {
+		_, err = io.Copy(dst, src)
+		return
+	}
+	var w io.WriteCloser
+	if w, err = stream.NewEncryptingWriter(dst, key); err != nil {
+		return
+	}
+	if _, err = io.Copy(w, src); err != nil {
+		return
+	}
+	return w.Close()
+}
+
+// 打开本地存储的附件，加密存储的附件自动解密（兼容未加密的历史附件）
+func OpenLocalAttach(a Attach) (r io.ReadCloser, err error) {
+	var f *os.File
+	if f, err = os.Open(common.GetPath([]string{a.URL})); err != nil {
+		logs.Error("open attach file[%s] fail, %s", a.URL, err.Error())
+		return
+	}
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(4)
+	if !stream.IsEncrypted(head) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, f}, nil
+	}
+	var dr io.Reader
+	if dr, err = stream.NewDecryptingReader(br, beego.AppConfig.String("FileServer::EncryptKey")); err != nil {
+		f.Close()
+		logs.Error("decrypt attach file[%s] fail, %s", a.URL, err.Error())
+		return
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{dr, f}, nil
+}
+
 func batchSaveAttach(db *gorm.DB, attachs []Attach) error {
 	if len(attachs) == 0 {
 		logs.Warn("no attach need to save")
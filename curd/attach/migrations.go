@@ -0,0 +1,8 @@
+package attach
+
+import "github.com/daimall/tools/curd/dbmysql/dbgorm/migrate"
+
+// 数据库迁移，见 migrate 包
+func init() {
+	migrate.Register(migrate.Model(2022010102, "create attachs", &Attach{}))
+}
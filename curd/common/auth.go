@@ -0,0 +1,353 @@
+package common
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/aes/secrets"
+	"github.com/daimall/tools/curd/customerror"
+	"github.com/daimall/tools/ldapm"
+	"github.com/dgrijalva/jwt-go"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// ErrNoCredentials 请求中没有当前认证方式需要的信息，交给下一个认证方式处理
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator 认证接口，认证通过返回用户名
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx *context.Context) (uname string, err error)
+}
+
+// AuthFilter 按顺序执行认证，第一个认证通过的用户名存入 UserNameSessionKey
+// 全部认证方式都没有认证信息或者认证失败时返回401
+// beego.InsertFilter("/v1/*", beego.BeforeRouter, common.AuthFilter(common.NewSessionAuthenticator(), common.NewJWTAuthenticatorFromConf()))
+func AuthFilter(authenticators ...Authenticator) beego.FilterFunc {
+	return authFilter(true, authenticators)
+}
+
+// OptionalAuthFilter 和 AuthFilter 一样解析用户名，但认证失败时不拦截请求
+func OptionalAuthFilter(authenticators ...Authenticator) beego.FilterFunc {
+	return authFilter(false, authenticators)
+}
+
+func authFilter(required bool, authenticators []Authenticator) beego.FilterFunc {
+	return func(ctx *context.Context) {
+		var lastErr error = UnameNotFound
+		for _, auth := range authenticators {
+			uname, err := auth.Authenticate(ctx)
+			if err == nil && uname != "" {
+				if _, ok := auth.(*LDAPAuthenticator); ok {
+					// 只有LDAP认证存入session，jwt、apikey 每次请求重新认证，token过期或者key吊销后立即失效
+					SetSessionUserName(ctx, uname)
+				} else {
+					SetUserName(ctx, uname)
+				}
+				return
+			}
+			if err != nil && err != ErrNoCredentials {
+				logs.Error("authenticator[%s] failed, %s", auth.Name(), err.Error())
+				lastErr = err
+			}
+		}
+		if !required {
+			return
+		}
+		ret := StandRestResult{Code: -1, Message: lastErr.Error()}
+		if cuserr, ok := customerror.As(lastErr); ok {
+			ret = StandRestResult{Code: cuserr.GetCode(), Message: customerror.Localize(cuserr, GetLanguage(ctx))}
+		}
+		ctx.Output.SetStatus(http.StatusUnauthorized)
+		ctx.Output.JSON(ret, false, false)
+	}
+}
+
+// NewAuthFilterFromConf 根据配置 Auth::Chain 生成认证filter，默认 session,jwt
+// 可选值：session, jwt, ldap, apikey
+func NewAuthFilterFromConf() beego.FilterFunc {
+	var authenticators []Authenticator
+	for _, name := range strings.Split(beego.AppConfig.DefaultString("Auth::Chain", "session,jwt"), ",") {
+		switch strings.TrimSpace(name) {
+		case "session":
+			authenticators = append(authenticators, NewSessionAuthenticator())
+		case "jwt":
+			authenticators = append(authenticators, NewJWTAuthenticatorFromConf())
+		case "ldap":
+			authenticators = append(authenticators, NewLDAPAuthenticator())
+		case "apikey":
+			authenticators = append(authenticators, NewAPIKeyAuthenticatorFromConf())
+		case "":
+		default:
+			logs.Error("unknown authenticator: %s", name)
+		}
+	}
+	return AuthFilter(authenticators...)
+}
+
+// SetUserName 存储认证通过的用户名（只在当前请求中有效）
+func SetUserName(ctx *context.Context, uname string) {
+	ctx.Input.SetData(UserNameSessionKey, uname)
+}
+
+// SetSessionUserName 存储认证通过的用户名，开启session时同时存入session，只用于session登录和LDAP认证
+func SetSessionUserName(ctx *context.Context, uname string) {
+	SetUserName(ctx, uname)
+	if ctx.Input.CruSession != nil {
+		if err := ctx.Input.CruSession.Set(UserNameSessionKey, uname); err != nil {
+			logs.Error("set session failed,", err.Error())
+		}
+	}
+}
+
+// GetUserName 获取认证过的用户名
+func GetUserName(ctx *context.Context) (uname string, ok bool) {
+	if uname, ok = ctx.Input.GetData(UserNameSessionKey).(string); ok && uname != "" {
+		return
+	}
+	if ctx.Input.CruSession != nil {
+		if uname, ok = ctx.Input.CruSession.Get(UserNameSessionKey).(string); ok && uname != "" {
+			return
+		}
+	}
+	return "", false
+}
+
+// SessionAuthenticator session 认证（用户名已经存在session中）
+type SessionAuthenticator struct {
+	Key string // session key, 默认 UserNameSessionKey
+}
+
+func NewSessionAuthenticator() *SessionAuthenticator {
+	return &SessionAuthenticator{Key: UserNameSessionKey}
+}
+
+func (a *SessionAuthenticator) Name() string {
+	return "session"
+}
+
+func (a *SessionAuthenticator) Authenticate(ctx *context.Context) (uname string, err error) {
+	if ctx.Input.CruSession == nil {
+		return "", ErrNoCredentials
+	}
+	if uname, ok := ctx.Input.CruSession.Get(a.Key).(string); ok && uname != "" {
+		return uname, nil
+	}
+	return "", ErrNoCredentials
+}
+
+// JWTAuthenticator JWT 认证
+type JWTAuthenticator struct {
+	Key       []byte        // HS* 算法为密钥，RS* 算法为PEM格式公钥（签发token时为私钥）
+	Alg       string        // 签名算法，默认 HS256
+	Header    string        // token所在的请求头，默认 Authorization（支持 Bearer 前缀）
+	UserClaim string        // 用户名所在的claim，默认 sub
+	Issuer    string        // 不为空时校验 iss
+	Expire    time.Duration // 签发token的有效期，默认2小时
+}
+
+// NewJWTAuthenticatorFromConf 从配置文件读取参数
+// [JWT]
+// Key = xxx (可以用 PwdEncryptKey* 加密，见 secrets.Resolve)
+// Alg = HS256
+// UserClaim = sub
+// Issuer =
+// Expire = 2h
+func NewJWTAuthenticatorFromConf() *JWTAuthenticator {
+	key, err := secrets.Resolve("JWT", "Key")
+	if err != nil {
+		logs.Error("resolve JWT::Key failed,", err.Error())
+	}
+	a := &JWTAuthenticator{
+		Key:       []byte(key),
+		Alg:       beego.AppConfig.DefaultString("JWT::Alg", jwt.SigningMethodHS256.Alg()),
+		Header:    beego.AppConfig.DefaultString("JWT::Header", TokenKey),
+		UserClaim: beego.AppConfig.DefaultString("JWT::UserClaim", "sub"),
+		Issuer:    beego.AppConfig.String("JWT::Issuer"),
+	}
+	if a.Expire, err = time.ParseDuration(beego.AppConfig.DefaultString("JWT::Expire", "2h")); err != nil {
+		logs.Error("parse JWT::Expire failed,", err.Error())
+		a.Expire = 2 * time.Hour
+	}
+	return a
+}
+
+func (a *JWTAuthenticator) Name() string {
+	return "jwt"
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx *context.Context) (uname string, err error) {
+	header := a.Header
+	if header == "" {
+		header = TokenKey
+	}
+	tokenStr := strings.TrimSpace(ctx.Input.Header(header))
+	if tokenStr == "" {
+		return "", ErrNoCredentials
+	}
+	if len(tokenStr) > 7 && strings.EqualFold(tokenStr[:7], "Bearer ") {
+		tokenStr = strings.TrimSpace(tokenStr[7:])
+	}
+	return a.ParseToken(tokenStr)
+}
+
+// ParseToken 校验token（签名算法、有效期、issuer）并返回用户名
+func (a *JWTAuthenticator) ParseToken(tokenStr string) (uname string, err error) {
+	var token *jwt.Token
+	if token, err = jwt.Parse(tokenStr, a.keyFunc); err != nil {
+		logs.Error("jwt parse token failed,", err.Error())
+		return "", TokenInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", TokenInvalid
+	}
+	if a.Issuer != "" && !claims.VerifyIssuer(a.Issuer, true) {
+		return "", TokenInvalid
+	}
+	userClaim := a.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	if v, ok := claims[userClaim].(string); ok {
+		uname = strings.Split(v, "@")[0]
+	}
+	if uname == "" {
+		return "", UnameNotFound
+	}
+	return uname, nil
+}
+
+// GenToken 签发token
+func (a *JWTAuthenticator) GenToken(uname string, extra map[string]interface{}) (tokenStr string, err error) {
+	method := jwt.GetSigningMethod(a.alg())
+	if method == nil {
+		return "", fmt.Errorf("unsupported jwt alg: %s", a.alg())
+	}
+	userClaim := a.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims[userClaim] = uname
+	claims["iat"] = now.Unix()
+	if a.Expire > 0 {
+		claims["exp"] = now.Add(a.Expire).Unix()
+	}
+	if a.Issuer != "" {
+		claims["iss"] = a.Issuer
+	}
+	var key interface{} = a.Key
+	if _, ok := method.(*jwt.SigningMethodRSA); ok {
+		if key, err = jwt.ParseRSAPrivateKeyFromPEM(a.Key); err != nil {
+			return
+		}
+	}
+	return jwt.NewWithClaims(method, claims).SignedString(key)
+}
+
+func (a *JWTAuthenticator) alg() string {
+	if a.Alg == "" {
+		return jwt.SigningMethodHS256.Alg()
+	}
+	return a.Alg
+}
+
+func (a *JWTAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	// 只接受配置的签名算法，防止算法替换攻击
+	if token.Method.Alg() != a.alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(a.Key) == 0 {
+			return nil, fmt.Errorf("jwt key is empty")
+		}
+		return a.Key, nil
+	case *jwt.SigningMethodRSA:
+		return jwt.ParseRSAPublicKeyFromPEM(a.Key)
+	}
+	return nil, fmt.Errorf("unsupported signing method: %v", token.Header["alg"])
+}
+
+// LDAPAuthenticator 域账号认证（HTTP Basic Auth），认证通过后存入session，避免每次请求都访问LDAP
+type LDAPAuthenticator struct{}
+
+func NewLDAPAuthenticator() *LDAPAuthenticator {
+	return &LDAPAuthenticator{}
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx *context.Context) (uname string, err error) {
+	user, passwd, ok := ctx.Request.BasicAuth()
+	if !ok || user == "" {
+		return "", ErrNoCredentials
+	}
+	if _, err = ldapm.New().LoadBeegoConf().ValidateUser(user, passwd, func(e *ldap.Entry) (interface{}, error) {
+		return e, nil
+	}); err != nil {
+		logs.Error("ldap validate user[%s] failed, %s", user, err.Error())
+		return "", UserNameOrPasswordInvalid
+	}
+	return user, nil
+}
+
+// APIKeyAuthenticator API key 认证，用于服务间调用
+type APIKeyAuthenticator struct {
+	Header string            // 默认 X-API-Key
+	Keys   map[string]string // api key -> 用户名
+}
+
+// NewAPIKeyAuthenticatorFromConf 从配置 Auth::APIKeys 读取（key1:user1,key2:user2，可以用 PwdEncryptKey* 加密）
+func NewAPIKeyAuthenticatorFromConf() *APIKeyAuthenticator {
+	a := &APIKeyAuthenticator{
+		Header: beego.AppConfig.DefaultString("Auth::APIKeyHeader", "X-API-Key"),
+		Keys:   map[string]string{},
+	}
+	v, err := secrets.Resolve("Auth", "APIKeys")
+	if err != nil {
+		logs.Error("resolve Auth::APIKeys failed,", err.Error())
+		return a
+	}
+	for _, kv := range strings.Split(v, ",") {
+		if pair := strings.SplitN(strings.TrimSpace(kv), ":", 2); len(pair) == 2 && pair[0] != "" {
+			a.Keys[pair[0]] = pair[1]
+		}
+	}
+	return a
+}
+
+func (a *APIKeyAuthenticator) Name() string {
+	return "apikey"
+}
+
+func (a *APIKeyAuthenticator) Authenticate(ctx *context.Context) (uname string, err error) {
+	header := a.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	key := ctx.Input.Header(header)
+	if key == "" {
+		return "", ErrNoCredentials
+	}
+	for k, user := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return user, nil
+		}
+	}
+	return "", TokenInvalid
+}
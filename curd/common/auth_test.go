@@ -0,0 +1,158 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/session"
+	"github.com/dgrijalva/jwt-go"
+)
+
+func newTestContext(req *http.Request) (*context.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	ctx := context.NewContext()
+	ctx.Reset(rec, req)
+	return ctx, rec
+}
+
+func Test_JWTAuthenticator(t *testing.T) {
+	a := &JWTAuthenticator{Key: []byte("secret"), Issuer: "tools", Expire: time.Hour}
+	token, err := a.GenToken("alice@example.com", map[string]interface{}{"role": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uname, err := a.ParseToken(token); err != nil || uname != "alice" {
+		t.Fatalf("expect alice, got %s %v", uname, err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(TokenKey, "Bearer "+token)
+	ctx, _ := newTestContext(req)
+	if uname, err := a.Authenticate(ctx); err != nil || uname != "alice" {
+		t.Errorf("expect bearer token of alice, got %s %v", uname, err)
+	}
+	ctx, _ = newTestContext(httptest.NewRequest("GET", "/", nil))
+	if _, err := a.Authenticate(ctx); err != ErrNoCredentials {
+		t.Errorf("expect no credentials, got %v", err)
+	}
+
+	otherIssuer := &JWTAuthenticator{Key: a.Key, Issuer: "other", Expire: time.Hour}
+	otherAlg := &JWTAuthenticator{Key: a.Key, Alg: "HS384", Issuer: a.Issuer, Expire: time.Hour}
+	wrongKey := &JWTAuthenticator{Key: []byte("wrong"), Issuer: a.Issuer, Expire: time.Hour}
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "alice"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	for name, gen := range map[string]*JWTAuthenticator{"issuer": otherIssuer, "alg": otherAlg, "key": wrongKey} {
+		token, err := gen.GenToken("alice", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = a.ParseToken(token); err != TokenInvalid {
+			t.Errorf("%s: expect invalid token, got %v", name, err)
+		}
+	}
+	if _, err = a.ParseToken(none); err != TokenInvalid {
+		t.Errorf("none alg: expect invalid token, got %v", err)
+	}
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice", "iss": "tools",
+		"exp": time.Now().Add(-time.Minute).Unix()}).SignedString(a.Key)
+	if _, err = a.ParseToken(expired); err != TokenInvalid {
+		t.Errorf("expired: expect invalid token, got %v", err)
+	}
+	noUser, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": "tools"}).SignedString(a.Key)
+	if _, err = a.ParseToken(noUser); err != UnameNotFound {
+		t.Errorf("expect uname not found, got %v", err)
+	}
+}
+
+func Test_APIKeyAuthenticator(t *testing.T) {
+	a := &APIKeyAuthenticator{Keys: map[string]string{"k1": "svc1", "k2": "svc2"}}
+	for key, expect := range map[string]string{"k2": "svc2", "k3": "", "": ""} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		ctx, _ := newTestContext(req)
+		uname, err := a.Authenticate(ctx)
+		switch {
+		case key == "" && err != ErrNoCredentials:
+			t.Errorf("expect no credentials, got %v", err)
+		case key == "k3" && err != TokenInvalid:
+			t.Errorf("expect invalid key, got %v", err)
+		case uname != expect:
+			t.Errorf("%s: expect %s, got %s", key, expect, uname)
+		}
+	}
+}
+
+type fakeAuthenticator struct {
+	uname string
+	err   error
+	calls int
+}
+
+func (a *fakeAuthenticator) Name() string { return "fake" }
+func (a *fakeAuthenticator) Authenticate(ctx *context.Context) (string, error) {
+	a.calls++
+	return a.uname, a.err
+}
+
+func Test_AuthFilterChain(t *testing.T) {
+	skip := &fakeAuthenticator{err: ErrNoCredentials}
+	pass := &fakeAuthenticator{uname: "bob"}
+	after := &fakeAuthenticator{uname: "never"}
+	ctx, rec := newTestContext(httptest.NewRequest("GET", "/", nil))
+	AuthFilter(skip, pass, after)(ctx)
+	if uname, ok := GetUserName(ctx); !ok || uname != "bob" || after.calls != 0 || rec.Code != http.StatusOK {
+		t.Errorf("expect bob from second authenticator, got %s %d %d", uname, after.calls, rec.Code)
+	}
+
+	fail := &fakeAuthenticator{err: errors.New("bad password")}
+	ctx, rec = newTestContext(httptest.NewRequest("GET", "/", nil))
+	AuthFilter(skip, fail)(ctx)
+	if _, ok := GetUserName(ctx); ok || rec.Code != http.StatusUnauthorized {
+		t.Errorf("expect 401, got %d", rec.Code)
+	}
+
+	// 可选认证失败时不拦截
+	ctx, rec = newTestContext(httptest.NewRequest("GET", "/", nil))
+	OptionalAuthFilter(fail)(ctx)
+	if _, ok := GetUserName(ctx); ok || rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("expect request not blocked, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func Test_AuthFilterSession(t *testing.T) {
+	manager, err := session.NewManager("memory", &session.ManagerConfig{CookieName: "sid", Gclifetime: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &JWTAuthenticator{Key: []byte("secret"), Issuer: "tools", Expire: time.Hour}
+	token, err := a.GenToken("alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(TokenKey, "Bearer "+token)
+	ctx, rec := newTestContext(req)
+	if ctx.Input.CruSession, err = manager.SessionStart(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	// jwt 认证通过只在当前请求有效，不存入session
+	AuthFilter(NewSessionAuthenticator(), a)(ctx)
+	if uname, ok := GetUserName(ctx); !ok || uname != "alice" {
+		t.Fatalf("expect alice, got %s", uname)
+	}
+	if v := ctx.Input.CruSession.Get(UserNameSessionKey); v != nil {
+		t.Errorf("expect no session user after jwt login, got %v", v)
+	}
+	// session 登录时存入session，后续请求由 SessionAuthenticator 认证
+	SetSessionUserName(ctx, "bob")
+	next, _ := newTestContext(httptest.NewRequest("GET", "/", nil))
+	next.Input.CruSession = ctx.Input.CruSession
+	AuthFilter(NewSessionAuthenticator())(next)
+	if uname, ok := GetUserName(next); !ok || uname != "bob" {
+		t.Errorf("expect bob from session, got %s", uname)
+	}
+}
@@ -3,8 +3,6 @@ package common
 import (
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"github.com/daimall/tools/aes"
 	"github.com/daimall/tools/aes/cbc"
 	"io"
 	"mime/multipart"
@@ -13,16 +11,8 @@ import (
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"github.com/daimall/tools/curd/customerror"
-
-	"gopkg.in/go-playground/validator.v9"
 )
 
-var validate *validator.Validate
-
-func init() {
-	validate = validator.New()
-}
-
 //BaseController ...
 //所有控制controller的基础struct
 type BaseController struct {
@@ -40,8 +30,17 @@ func (b *BaseController) GetStandRestResult() StandRestResultInf {
 // JSONResponse 返回JSON格式结果
 func (c *BaseController) JSONResponse(err error, data ...interface{}) {
 	if err != nil {
-		if cuserr, ok := err.(customerror.CustomError); ok {
-			c.Data["json"] = c.GetStandRestResult().GetStandRestResult(cuserr.GetCode(), cuserr.GetMessage(), nil)
+		if cuserr, ok := customerror.As(err); ok {
+			var errData interface{}
+			if dataErr, ok := cuserr.(interface{ GetData() interface{} }); ok {
+				errData = dataErr.GetData()
+			} else if details := customerror.DetailsOf(cuserr); len(details) > 0 {
+				errData = details
+			}
+			c.Ctx.Output.SetStatus(customerror.StatusOf(cuserr))
+			// 错误信息按 Accept-Language 翻译
+			msg := customerror.Localize(cuserr, GetLanguage(c.Ctx))
+			c.Data["json"] = c.GetStandRestResult().GetStandRestResult(cuserr.GetCode(), msg, errData)
 		} else {
 			c.Data["json"] = c.GetStandRestResult().GetStandRestResult(-1, err.Error(), nil)
 		}
@@ -57,14 +56,16 @@ func (c *BaseController) JSONResponse(err error, data ...interface{}) {
 }
 
 //ValidateParameters obj must pointer, json Unmarshal object and require parameter validate
+// 校验失败时返回 *ValidationError，字段错误信息按 Accept-Language 翻译
 func (c *BaseController) ValidateParameters(obj interface{}) customerror.CustomError {
+	lang := GetLanguage(c.Ctx)
 	if err := json.Unmarshal(c.Ctx.Input.RequestBody, obj); err != nil {
 		logs.Error("UnmarshalRequestBody Err", err.Error())
-		return ParamsError
+		return unmarshalError(err, lang)
 	}
-	if err := validate.Struct(obj); err != nil {
+	if err := ValidateStruct(obj, lang); err != nil {
 		logs.Error("validate params err:", err.Error())
-		return ParamsValidateError
+		return err
 	}
 	return nil
 
@@ -114,15 +115,11 @@ func (c *BaseController) ServeDecryptJSON() {
 			logs.Error("marshal c.Data failed,", err.Error())
 			return
 		}
-		appid := c.Ctx.Input.Header("BsAppID")
-		model := c.Ctx.Input.Header("Model")
-		timestamp := c.Ctx.Input.Header("Timestamp")
-		if len(appid) < 10 || len(timestamp) < 10 {
-			err = fmt.Errorf("appid[%s] or timestamp[%s] invalid", appid, timestamp)
+		var aesKey string
+		if aesKey, err = GetRequestAesKey(c.Ctx.Input); err != nil {
 			logs.Error(err.Error())
 			return
 		}
-		aesKey := aes.GetPriAesKey(appid, model, timestamp)
 		if decryptedData, err = cbc.NewPri(aesKey).EncryptBytes(origData); err != nil {
 			logs.Error("encrypt response body failed,", err.Error())
 			return
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/aes"
+	"github.com/daimall/tools/aes/cbc"
+	"github.com/daimall/tools/curd/customerror"
+)
+
+const (
+	EncryptAppIDHeader     = "BsAppID"   // 参与生成aes key的appid
+	EncryptModelHeader     = "Model"     // 参与生成aes key的model
+	EncryptTimestampHeader = "Timestamp" // 参与生成aes key的时间戳（秒）
+
+	ERR_CODE_DECRYPT_FAILED = 9002 // 解密请求body失败
+
+	requestDecryptedKey = "_RequestDecrypted" // 标记请求已经解密，避免filter和Prepare重复解密
+)
+
+// GetRequestAesKey 根据请求头生成私有aes key
+func GetRequestAesKey(input *context.BeegoInput) (aesKey string, err error) {
+	appid := input.Header(EncryptAppIDHeader)
+	model := input.Header(EncryptModelHeader)
+	timestamp := input.Header(EncryptTimestampHeader)
+	if len(appid) < 10 || len(timestamp) < 10 {
+		err = fmt.Errorf("appid[%s] or timestamp[%s] invalid", appid, timestamp)
+		return
+	}
+	return aes.GetPriAesKey(appid, model, timestamp), nil
+}
+
+// CheckTimestamp 校验请求时间戳是否在允许的误差范围内（防重放）
+// 误差通过 EncryptTimestampSkew 配置（秒），默认300，配置为0时不校验
+func CheckTimestamp(timestamp string) error {
+	skew := beego.AppConfig.DefaultInt64("EncryptTimestampSkew", 300)
+	if skew <= 0 {
+		return nil
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return TimestampError
+	}
+	if d := time.Now().Unix() - ts; d > skew || d < -skew {
+		logs.Error("timestamp[%s] expired, skew %ds", timestamp, d)
+		return TimestampError
+	}
+	return nil
+}
+
+// 请求body是否需要解密
+func isEncryptedRequest(input *context.BeegoInput) bool {
+	encryptType := input.Header(ENCRYPT_TYPE)
+	return encryptType == ENCRYPT_TYPE_AES_PRIV_REQ || encryptType == ENCRYPT_TYPE_AES_PRIV_REQ_RESP
+}
+
+// DecryptRequest 解密请求body（base64 + cbc.NewPri），解密后替换 RequestBody
+// 非加密请求或者已经解密过的请求直接返回
+func DecryptRequest(ctx *context.Context) (err error) {
+	if !isEncryptedRequest(ctx.Input) || ctx.Input.GetData(requestDecryptedKey) != nil {
+		return nil
+	}
+	if err = CheckTimestamp(ctx.Input.Header(EncryptTimestampHeader)); err != nil {
+		return
+	}
+	var aesKey string
+	if aesKey, err = GetRequestAesKey(ctx.Input); err != nil {
+		logs.Error(err.Error())
+		return AppIDError
+	}
+	body := bytes.TrimSpace(ctx.Input.RequestBody)
+	if len(body) > 0 {
+		cipherBytes := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
+		var n int
+		if n, err = base64.StdEncoding.Decode(cipherBytes, body); err != nil {
+			logs.Error("base64 decode request body failed,", err.Error())
+			return
+		}
+		var plainBytes []byte
+		if plainBytes, err = cbc.NewPri(aesKey).DecryptBytes(cipherBytes[:n]); err != nil {
+			logs.Error("decrypt request body failed,", err.Error())
+			return
+		}
+		ctx.Input.RequestBody = plainBytes
+	}
+	ctx.Input.SetData(requestDecryptedKey, true)
+	return nil
+}
+
+// DecryptRequestFilter 解密请求body的filter
+// beego.InsertFilter("/*", beego.BeforeRouter, common.DecryptRequestFilter)
+func DecryptRequestFilter(ctx *context.Context) {
+	if err := DecryptRequest(ctx); err != nil {
+		writeDecryptErr(ctx, err)
+	}
+}
+
+// DecryptRequestBody 在 Prepare 中解密请求body，失败时返回错误信息并停止执行
+func (c *BaseController) DecryptRequestBody() {
+	if err := DecryptRequest(c.Ctx); err != nil {
+		writeDecryptErr(c.Ctx, err)
+		c.StopRun()
+	}
+}
+
+// 解密失败返回错误（请求没有解密成功，响应也不加密）
+func writeDecryptErr(ctx *context.Context, err error) {
+	var ret StandRestResult
+	if cuserr, ok := customerror.As(err); ok {
+		ret = StandRestResult{Code: cuserr.GetCode(), Message: customerror.Localize(cuserr, GetLanguage(ctx))}
+		ctx.Output.SetStatus(customerror.StatusOf(cuserr))
+	} else {
+		ret = StandRestResult{Code: ERR_CODE_DECRYPT_FAILED, Message: "Decrypt request failed," + err.Error()}
+		ctx.Output.SetStatus(http.StatusBadRequest)
+	}
+	ctx.Output.JSON(ret, false, false)
+}
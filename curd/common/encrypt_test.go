@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/daimall/tools/aes"
+	"github.com/daimall/tools/aes/cbc"
+)
+
+const (
+	testAppID = "app-0123456789"
+	testModel = "test"
+)
+
+// 按 EncryptClient 的约定生成加密请求
+func newEncryptedRequest(appid, timestamp, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set(ENCRYPT_TYPE, ENCRYPT_TYPE_AES_PRIV_REQ_RESP)
+	req.Header.Set(EncryptAppIDHeader, appid)
+	req.Header.Set(EncryptModelHeader, testModel)
+	req.Header.Set(EncryptTimestampHeader, timestamp)
+	return req
+}
+
+func encryptBody(t *testing.T, appid, timestamp, plain string) string {
+	cipherBytes, err := cbc.NewPri(aes.GetPriAesKey(appid, testModel, timestamp)).EncryptBytes([]byte(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(cipherBytes)
+}
+
+func Test_DecryptRequest(t *testing.T) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	plain := `{"name":"alice"}`
+
+	ctx, _ := newTestContext(newEncryptedRequest(testAppID, timestamp, ""))
+	ctx.Input.RequestBody = []byte(encryptBody(t, testAppID, timestamp, plain))
+	if err := DecryptRequest(ctx); err != nil || string(ctx.Input.RequestBody) != plain {
+		t.Fatalf("expect decrypted body, got %s %v", ctx.Input.RequestBody, err)
+	}
+	// 已经解密过的请求不再解密
+	if err := DecryptRequest(ctx); err != nil || string(ctx.Input.RequestBody) != plain {
+		t.Errorf("expect decrypt once, got %s %v", ctx.Input.RequestBody, err)
+	}
+
+	ctx, _ = newTestContext(newEncryptedRequest(testAppID, timestamp, ""))
+	ctx.Input.RequestBody = []byte("not base64!")
+	if err := DecryptRequest(ctx); err == nil {
+		t.Error("expect base64 error")
+	}
+
+	// 用其他 appid 的 key 加密
+	ctx, _ = newTestContext(newEncryptedRequest(testAppID, timestamp, ""))
+	ctx.Input.RequestBody = []byte(encryptBody(t, "other-0123456789", timestamp, plain))
+	if err := DecryptRequest(ctx); err == nil && string(ctx.Input.RequestBody) == plain {
+		t.Error("expect wrong key error")
+	}
+
+	ctx, _ = newTestContext(newEncryptedRequest("short", timestamp, ""))
+	ctx.Input.RequestBody = []byte(encryptBody(t, testAppID, timestamp, plain))
+	if err := DecryptRequest(ctx); err != AppIDError {
+		t.Errorf("expect appid error, got %v", err)
+	}
+
+	// 非加密请求不处理
+	ctx, _ = newTestContext(httptest.NewRequest("POST", "/", nil))
+	ctx.Input.RequestBody = []byte(plain)
+	if err := DecryptRequest(ctx); err != nil || string(ctx.Input.RequestBody) != plain {
+		t.Errorf("expect plain body, got %s %v", ctx.Input.RequestBody, err)
+	}
+
+	// filter 解密失败时返回错误
+	ctx, rec := newTestContext(newEncryptedRequest(testAppID, timestamp, ""))
+	ctx.Input.RequestBody = []byte("not base64!")
+	DecryptRequestFilter(ctx)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), strconv.Itoa(ERR_CODE_DECRYPT_FAILED)) {
+		t.Errorf("expect decrypt failed response, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func Test_CheckTimestamp(t *testing.T) {
+	beego.AppConfig.Set("EncryptTimestampSkew", "60")
+	defer beego.AppConfig.Set("EncryptTimestampSkew", "")
+	now := time.Now().Unix()
+	for ts, ok := range map[int64]bool{now: true, now - 50: true, now + 50: true, now - 120: false, now + 120: false} {
+		if err := CheckTimestamp(strconv.FormatInt(ts, 10)); (err == nil) != ok {
+			t.Errorf("timestamp %d (now %d): expect valid %v, got %v", ts, now, ok, err)
+		}
+	}
+	if err := CheckTimestamp("abc"); err != TimestampError {
+		t.Errorf("expect timestamp error, got %v", err)
+	}
+	// 过期的时间戳解密失败
+	timestamp := strconv.FormatInt(now-120, 10)
+	ctx, _ := newTestContext(newEncryptedRequest(testAppID, timestamp, ""))
+	ctx.Input.RequestBody = []byte(encryptBody(t, testAppID, timestamp, "{}"))
+	if err := DecryptRequest(ctx); err != TimestampError {
+		t.Errorf("expect expired timestamp, got %v", err)
+	}
+
+	beego.AppConfig.Set("EncryptTimestampSkew", "0")
+	if err := CheckTimestamp("abc"); err != nil {
+		t.Errorf("expect no check when skew is 0, got %v", err)
+	}
+}
+
+func Test_EncryptClient(t *testing.T) {
+	// 服务端：解密请求，原样返回 name，响应按 ServeDecryptJSON 加密
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.NewContext()
+		ctx.Reset(w, r)
+		ctx.Input.RequestBody, _ = io.ReadAll(r.Body)
+		c := &BaseController{}
+		c.Ctx = ctx
+		c.Data = map[interface{}]interface{}{}
+		if err := DecryptRequest(ctx); err != nil {
+			c.JSONResponse(err)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		err := json.Unmarshal(ctx.Input.RequestBody, &req)
+		c.JSONResponse(err, map[string]string{"hello": req.Name})
+	}))
+	defer srv.Close()
+
+	client := NewEncryptClient(srv.URL, testAppID, testModel)
+	var ret struct {
+		Code int               `json:"code"`
+		Data map[string]string `json:"data"`
+	}
+	if err := client.Do("POST", "/hello", map[string]string{"name": "alice"}, &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret.Code != 0 || ret.Data["hello"] != "alice" {
+		t.Errorf("unexpected response %+v", ret)
+	}
+	if err := NewEncryptClient(srv.URL, "short", testModel).Do("POST", "/hello", nil, nil); err == nil {
+		t.Error("expect appid error")
+	}
+}
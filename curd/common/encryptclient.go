@@ -0,0 +1,99 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/daimall/tools/aes"
+	"github.com/daimall/tools/aes/cbc"
+)
+
+// EncryptClient 服务间调用的客户端，按 ServeDecryptJSON/DecryptRequest 的约定加密请求、解密响应
+type EncryptClient struct {
+	BaseURL     string       // 服务地址，例如 http://127.0.0.1:8080/v1
+	AppID       string       // 至少10位
+	Model       string       //
+	EncryptType string       // 默认 AES_PRIV_REQ_RESP
+	HTTPClient  *http.Client // 默认 http.DefaultClient
+}
+
+// NewEncryptClient 新建一个加密客户端
+func NewEncryptClient(baseURL, appid, model string) *EncryptClient {
+	return &EncryptClient{BaseURL: baseURL, AppID: appid, Model: model,
+		EncryptType: ENCRYPT_TYPE_AES_PRIV_REQ_RESP}
+}
+
+// Do 发送请求，body 会被json序列化后加密，响应解密后json反序列化到result（StandRestResult 结构）
+func (e *EncryptClient) Do(method, path string, body interface{}, result interface{}) (err error) {
+	if len(e.AppID) < 10 {
+		return fmt.Errorf("appid[%s] invalid", e.AppID)
+	}
+	encryptType := e.EncryptType
+	if encryptType == "" {
+		encryptType = ENCRYPT_TYPE_AES_PRIV_REQ_RESP
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	inst := cbc.NewPri(aes.GetPriAesKey(e.AppID, e.Model, timestamp))
+
+	var reqBody io.Reader
+	if body != nil {
+		var plain []byte
+		if plain, err = json.Marshal(body); err != nil {
+			return
+		}
+		if encryptType != ENCRYPT_TYPE_AES_PRIV_RESP {
+			var cipherBytes []byte
+			if cipherBytes, err = inst.EncryptBytes(plain); err != nil {
+				return
+			}
+			plain = []byte(base64.StdEncoding.EncodeToString(cipherBytes))
+		}
+		reqBody = bytes.NewReader(plain)
+	}
+	var req *http.Request
+	if req, err = http.NewRequest(method, e.BaseURL+path, reqBody); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(ENCRYPT_TYPE, encryptType)
+	req.Header.Set(EncryptAppIDHeader, e.AppID)
+	req.Header.Set(EncryptModelHeader, e.Model)
+	req.Header.Set(EncryptTimestampHeader, timestamp)
+
+	client := e.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var resp *http.Response
+	if resp, err = client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var respBody []byte
+	if respBody, err = io.ReadAll(resp.Body); err != nil {
+		return
+	}
+	if encryptType != ENCRYPT_TYPE_AES_PRIV_REQ {
+		respBody = bytes.TrimSpace(respBody)
+		// 加密失败等场景服务端返回的是明文json
+		if len(respBody) > 0 && respBody[0] != '{' {
+			var cipherBytes []byte
+			if cipherBytes, err = base64.StdEncoding.DecodeString(string(respBody)); err != nil {
+				return fmt.Errorf("decode response failed, %s", err.Error())
+			}
+			if respBody, err = inst.DecryptBytes(cipherBytes); err != nil {
+				return fmt.Errorf("decrypt response failed, %s", err.Error())
+			}
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
@@ -1,83 +1,91 @@
 package common
 
-import "github.com/daimall/tools/curd/customerror"
+import (
+	"net/http"
 
+	"github.com/daimall/tools/curd/customerror"
+)
+
+// 错误码通过 customerror.Define 注册，重复的错误码会在启动时panic
 var (
 	//NotFound not found
-	NotFound = customerror.New(404, "NOT_FOUND")
+	NotFound = customerror.Define(404, http.StatusNotFound, "NOT_FOUND")
 	//ErrServer 服务器错误
-	ErrServer = customerror.New(500, "服务器错误")
+	ErrServer = customerror.Define(500, http.StatusInternalServerError, "服务器错误")
 	//SignError sign error
-	SignError = customerror.New(1103, "Sign签名错误")
+	SignError = customerror.Define(1103, http.StatusUnauthorized, "Sign签名错误")
 
 	//ParamsError json error
-	ParamsError = customerror.New(1101, "参数格式错误")
+	ParamsError = customerror.Define(1101, http.StatusBadRequest, "参数格式错误")
 	// Validate error
-	ParamsValidateError = customerror.New(1102, "参数校验错误")
-	//InternalServerError 500
-	InternalServerError = customerror.New(500, "INTERNAL_SERVER_ERROR")
+	ParamsValidateError = customerror.Define(1102, http.StatusBadRequest, "参数校验错误")
+	//InternalServerError 500（同ErrServer）
+	InternalServerError = ErrServer
 
 	//ParamsFormatError 参数格式错误
-	ParamsFormatError = customerror.New(1102, "PARAMS_FORMAT_ERROR")
+	// 注意：错误码由1102改为1107，原来和 ParamsValidateError 重复，客户端按1102判断的需要同时处理1107
+	ParamsFormatError = customerror.Define(1107, http.StatusBadRequest, "PARAMS_FORMAT_ERROR")
 
 	//LoginTokenInvalid  登录token失效
-	LoginTokenInvalid = customerror.New(1104, "LOGIN_TOKEN_INVALID")
+	LoginTokenInvalid = customerror.Define(1104, http.StatusUnauthorized, "LOGIN_TOKEN_INVALID")
 
 	//AppIDError AppIDError
-	AppIDError = customerror.New(1105, "AppID Error")
+	AppIDError = customerror.Define(1105, http.StatusBadRequest, "AppID Error")
 	//TimestampError TimestampError
-	TimestampError = customerror.New(1106, "Timestamp Error")
+	TimestampError = customerror.Define(1106, http.StatusBadRequest, "Timestamp Error")
 
 	//AccountError 账号或密码错误
-	AccountError = customerror.New(2102, "ACCOUNT_ERROR")
+	AccountError = customerror.Define(2102, http.StatusUnauthorized, "ACCOUNT_ERROR")
 
 	// TOKEN 不存在
-	TokenNotFound = customerror.New(3000, "Token信息不存在")
+	TokenNotFound = customerror.Define(3000, http.StatusUnauthorized, "Token信息不存在")
 
 	//AccessTokenExpire 帐号app的token过期
-	AccessTokenExpire = customerror.New(3001, "AccessToken Error or Expire")
+	AccessTokenExpire = customerror.Define(3001, http.StatusUnauthorized, "AccessToken Error or Expire")
 	//RefreshTokenExpire 帐号app的刷新令牌过期
-	RefreshTokenExpire = customerror.New(3002, "RefreshToken Error or Expire")
-	TokenInvalid       = customerror.New(3003, "非法的token")
+	RefreshTokenExpire = customerror.Define(3002, http.StatusUnauthorized, "RefreshToken Error or Expire")
+	TokenInvalid       = customerror.Define(3003, http.StatusUnauthorized, "非法的token")
 	// 用户认证信息不存在
-	UnameNotFound = customerror.New(3005, "用户认证信息不存在")
+	UnameNotFound = customerror.Define(3005, http.StatusUnauthorized, "用户认证信息不存在")
 	//OauthCodeExpire accesstoken过期
-	OauthCodeExpire = customerror.New(3006, "Oauth Code Expire")
+	OauthCodeExpire = customerror.Define(3006, http.StatusUnauthorized, "Oauth Code Expire")
 	//
-	AuthStateInvalid = customerror.New(3007, "Auth state 不正确")
+	AuthStateInvalid = customerror.Define(3007, http.StatusBadRequest, "Auth state 不正确")
 	//
-	AuthCodeInvalid = customerror.New(3008, "Auth code 不正确")
+	AuthCodeInvalid = customerror.Define(3008, http.StatusBadRequest, "Auth code 不正确")
 	//
-	UserNameOrPasswordInvalid = customerror.New(5009, "用户名或者密码错误")
+	UserNameOrPasswordInvalid = customerror.Define(5009, http.StatusUnauthorized, "用户名或者密码错误")
 	// LdapErr 域账号登陆异常
-	LdapErr = customerror.New(5005, "域账号登陆异常")
+	LdapErr = customerror.Define(5005, http.StatusUnauthorized, "域账号登陆异常")
 	// DisabledUser 禁用的用户
-	DisabledUser       = customerror.New(5006, "禁用的用户")
-	AnotherClientLogin = customerror.New(50008, "其他客户端登录了")
+	DisabledUser       = customerror.Define(5006, http.StatusForbidden, "禁用的用户")
+	AnotherClientLogin = customerror.Define(50008, http.StatusUnauthorized, "其他客户端登录了")
 	//GetCodeFrequently 请求验证码次数过多
-	GetCodeFrequently = customerror.New(3009, "请求验证码次数过多")
+	GetCodeFrequently = customerror.Define(3009, http.StatusTooManyRequests, "请求验证码次数过多")
 	//VerificationCodeeError 验证码错误
-	VerificationCodeeError = customerror.New(3010, "验证码错误")
+	VerificationCodeeError = customerror.Define(3010, http.StatusBadRequest, "验证码错误")
 
 	//ImageError 图片格式不支持
-	ImageError = customerror.New(4001, "该图片格式不支持")
+	ImageError = customerror.Define(4001, http.StatusBadRequest, "该图片格式不支持")
 	//NicknameError 昵称错误
-	NicknameError = customerror.New(4002, "昵称包含禁用词")
+	NicknameError = customerror.Define(4002, http.StatusBadRequest, "昵称包含禁用词")
 	// UserAlreadyExist 创建的用户已经存在
-	UserAlreadyExist = customerror.New(5001, "用户已存在")
+	UserAlreadyExist = customerror.Define(5001, http.StatusConflict, "用户已存在")
 	// UserDoesNotExist 用户不存在
-	UserDoesNotExist = customerror.New(5002, "用户不存在")
+	UserDoesNotExist = customerror.Define(5002, http.StatusNotFound, "用户不存在")
 	// WrongPassword 密码错误
-	WrongPassword = customerror.New(5003, "密码错误")
+	WrongPassword = customerror.Define(5003, http.StatusUnauthorized, "密码错误")
 )
 
 var (
-	ServerErr            = customerror.New(500, "服务器错误")
-	QueryCondErr         = customerror.New(1001, "查询条件不正确")
-	StepTypeNotFound     = customerror.New(1002, "Flow Step 不存在")
-	ServiceIdNotInt      = customerror.New(1003, "Service Id格式不正确")
-	ParamsErr            = customerror.New(1004, "参数错误")
-	UploadErr            = customerror.New(1005, "上传文件失败")
-	UpdateActionNotFound = customerror.New(1006, "Update Acton 不存在")
-	ActionNotFound       = customerror.New(1007, "Action 不存在")
+	ServerErr            = ErrServer // 同ErrServer
+	QueryCondErr         = customerror.Define(1001, http.StatusBadRequest, "查询条件不正确")
+	StepTypeNotFound     = customerror.Define(1002, http.StatusNotFound, "Flow Step 不存在")
+	ServiceIdNotInt      = customerror.Define(1003, http.StatusBadRequest, "Service Id格式不正确")
+	ParamsErr            = customerror.Define(1004, http.StatusBadRequest, "参数错误")
+	UploadErr            = customerror.Define(1005, http.StatusBadRequest, "上传文件失败")
+	UpdateActionNotFound = customerror.Define(1006, http.StatusNotFound, "Update Acton 不存在")
+	ActionNotFound       = customerror.Define(1007, http.StatusNotFound, "Action 不存在")
+	PermissionDenied     = customerror.Define(1008, http.StatusForbidden, "没有操作权限")
+	QueryFieldErr        = customerror.Define(1009, http.StatusBadRequest, "查询字段不合法")
 )
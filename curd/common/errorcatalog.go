@@ -0,0 +1,30 @@
+package common
+
+import (
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/customerror"
+)
+
+// ErrorCatalogController 返回已注册的错误码列表，供前端做翻译
+type ErrorCatalogController struct {
+	BaseController
+}
+
+// RegisterErrorCatalogRouter 注册错误码目录路由
+func RegisterErrorCatalogRouter(path string) {
+	beego.Router(path, &ErrorCatalogController{}, "get:Get")
+}
+
+// Get ...
+// @Title 错误码目录
+// @Description 已注册的错误码、http状态码和错误信息（按lang参数或者Accept-Language翻译）
+// @Param	lang	query	string	false	"zh-CN en-US"
+// @Success 200 {object} []customerror.CatalogEntry
+// @router / [get]
+func (c *ErrorCatalogController) Get() {
+	lang := c.GetString("lang")
+	if lang == "" {
+		lang = GetLanguage(c.Ctx)
+	}
+	c.JSONResponse(nil, customerror.Catalog(lang))
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/daimall/tools/curd/customerror"
+)
+
+// 本包错误码的多语言信息，文件名为语言（zh-CN.json、en-US.json），内容为 错误码 -> 模板
+//
+//go:embed locales/*.json
+var localeFS embed.FS
+
+func init() {
+	entries, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		f, err := localeFS.Open(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		if err = customerror.LoadMessages(strings.TrimSuffix(entry.Name(), ".json"), f); err != nil {
+			panic(fmt.Sprintf("load %s failed, %s", entry.Name(), err.Error()))
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,20 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/daimall/tools/curd/customerror"
+)
+
+func Test_ErrorMessages(t *testing.T) {
+	if msg := customerror.Localize(StepTypeNotFound.WithParams(map[string]interface{}{"step": "review"}), "en-US"); msg != "Flow step [review] not found" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	if msg := customerror.Localize(QueryCondErr, "zh"); msg != "查询条件不正确" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+	// 其他包使用了相同的错误码
+	if msg := customerror.Localize(customerror.New(1001, "库存不足"), "en-US"); msg != "库存不足" {
+		t.Errorf("unexpected message: %s", msg)
+	}
+}
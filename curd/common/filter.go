@@ -1,24 +1,32 @@
 package common
 
 import (
+	"sync"
+
+	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/context"
 )
 
-// 解析token，存储username
+var (
+	tokenFilterOnce sync.Once
+	tokenFilter     beego.FilterFunc
+	bsAuthOnce      sync.Once
+	bsAuthFilter    beego.FilterFunc
+)
+
+// 解析token，存储username（token无效时不拦截请求）
 func TokenDealFilter(ctx *context.Context) {
-	// var err error
-	// var username string
-	// if username, err = funcs.GetAccountIdFromToken(ctx.Input.Header(TokenKey), []byte("test_group")); err != nil {
-	// 	logs.Error("GetAccountIdFromToken failed, err is ", err.Error())
-	// 	return
-	// }
-	// ctx.Input.CruSession.Set(UserNameSessionKey, username)
+	tokenFilterOnce.Do(func() {
+		tokenFilter = OptionalAuthFilter(NewJWTAuthenticatorFromConf())
+	})
+	tokenFilter(ctx)
 }
 
-// 通过黑鲨账号认证
+// 通过配置的认证方式（Auth::Chain）认证，认证失败返回401
+// Deprecated: 使用 NewAuthFilterFromConf 或 AuthFilter
 func BSAuth(ctx *context.Context) {
-	// 获取TOKEN，比较和session中的token是否一样
-	// 如果不一样或者session中token为空，向黑鲨账号认证，认证通过就通过，不通过就跳转到认证失败页面
-	// 通过token获取用户信息
-	// https://account.blackshark.com/user/profile?access_token=55dbc109072942afb6c53ad1ec46f37d&client_id=2000011111
+	bsAuthOnce.Do(func() {
+		bsAuthFilter = NewAuthFilterFromConf()
+	})
+	bsAuthFilter(ctx)
 }
@@ -0,0 +1,288 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"gorm.io/gorm"
+)
+
+// AMIS 模式下不作为查询条件的请求参数
+var listReservedKeys = map[string]struct{}{
+	"orderDir": {},
+	"orderBy":  {},
+	"page":     {},
+	"perPage":  {},
+	"fields":   {},
+	"sortby":   {},
+	"order":    {},
+	"limit":    {},
+	"offset":   {},
+	"query":    {},
+	"tz":       {},
+	"cursor":   {},
+	"count":    {},
+	"_":        {},
+}
+
+// ListRequest 列表查询（GetAll/Export）请求参数
+type ListRequest struct {
+	Query    []*QueryConditon
+	Fields   []string
+	SortBy   []string
+	Order    []string
+	Offset   int
+	Limit    int
+	Location *time.Location // 日期查询的时区，由参数tz指定
+
+	CursorMode bool   // 请求带cursor参数（第一页为空值）时使用游标分页
+	Cursor     string // 游标，取自上一次返回的 next/prev
+	CountMode  string // 总数统计方式 exact/approx/none，由参数count指定
+	MaxLimit   int    // 单页条数上限，为0时使用配置 ListMaxLimit
+
+	Scopes []func(*gorm.DB) *gorm.DB // 附加的查询条件（如行级数据权限），FindPage 时使用
+	Ctx    *context.Context          // 请求上下文，查询时绑定请求的context（见 dbgorm.ReadReplica）
+}
+
+// RowScopeKey 请求上下文中当前用户的行级数据权限
+const RowScopeKey = "RowScope"
+
+// SetRowScope 设置请求的行级数据权限，之后 ParseListRequest 解析的请求都带上该条件
+func SetRowScope(ctx *context.Context, scope func(*gorm.DB) *gorm.DB) {
+	ctx.Input.SetData(RowScopeKey, scope)
+}
+
+// RowScopeOf 请求的行级数据权限，没有设置时返回nil
+func RowScopeOf(ctx *context.Context) func(*gorm.DB) *gorm.DB {
+	if ctx == nil || ctx.Input == nil {
+		return nil
+	}
+	scope, _ := ctx.Input.GetData(RowScopeKey).(func(*gorm.DB) *gorm.DB)
+	return scope
+}
+
+// ReservedKeysKey 请求上下文中接口自己处理、AMIS 模式下不作为查询条件的参数
+const ReservedKeysKey = "ReservedListKeys"
+
+// SetReservedKeys 设置请求中接口自己处理的参数（例如 oplog 的 q/since/until），AMIS 模式下不作为查询条件
+func SetReservedKeys(ctx *context.Context, keys ...string) {
+	ctx.Input.SetData(ReservedKeysKey, keys)
+}
+
+// 是否为不作为查询条件的参数
+func reservedKey(ctx *context.Context, key string) bool {
+	if _, ok := listReservedKeys[key]; ok {
+		return true
+	}
+	keys, _ := ctx.Input.GetData(ReservedKeysKey).([]string)
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseListRequest 解析列表查询参数
+// query: k|type:v|v|v,k|type:v|v|v（AMIS模式下为 k|type=v,v,v）其中Type可以没有,默认值是 MultiText
+// fields: col1,col2  sortby: col1,col2  order: desc,asc  limit/offset 或者 perPage/page
+// tz: 日期查询的时区，例如 Asia/Shanghai、+08:00
+// cursor: 游标分页，第一页传空值，之后传上一次返回的 next/prev；count: exact/approx/none
+// allowed 为允许查询/返回/排序的字段，为空时只校验字段名格式
+func ParseListRequest(ctx *context.Context, allowed ...string) (req *ListRequest, err error) {
+	input := ctx.Input
+	req = &ListRequest{Limit: 10, Location: time.Local, Ctx: ctx}
+	if v := input.Query("tz"); v != "" {
+		if req.Location, err = parseLocation(v); err != nil {
+			logs.Error("parse tz[%s] failed, %s", v, err.Error())
+			return nil, QueryCondErr
+		}
+	}
+	if v, ok := ctx.Request.URL.Query()["cursor"]; ok {
+		req.CursorMode, req.Cursor = true, v[0]
+	}
+	switch req.CountMode = input.Query("count"); req.CountMode {
+	case "", CountExact, CountApprox, CountNone:
+	default:
+		logs.Error("invalid count mode[%s]", req.CountMode)
+		return nil, QueryCondErr
+	}
+	// fields: col1,col2,entity.col3
+	if v := input.Query("fields"); v != "" {
+		req.Fields = strings.Split(v, ",")
+	}
+	// limit: 10 (default is 10)
+	if v, perr := strconv.Atoi(input.Query("limit")); perr == nil {
+		req.Limit = v
+	}
+	// offset: 0 (default is 0)
+	if v, perr := strconv.Atoi(input.Query("offset")); perr == nil {
+		req.Offset = v
+	}
+	// 适配amis
+	if v, perr := strconv.Atoi(input.Query("perPage")); perr == nil {
+		req.Limit = v
+		if v, perr := strconv.Atoi(input.Query("page")); perr == nil && v > 0 {
+			req.Offset = (v - 1) * req.Limit
+		}
+	}
+	if req.Limit < 0 || req.Offset < 0 {
+		logs.Error("invalid limit[%d] or offset[%d]", req.Limit, req.Offset)
+		return nil, QueryCondErr
+	}
+	// sortby: col1,col2
+	if v := input.Query("sortby"); v != "" {
+		v = strings.Replace(v, ".", "__", -1)
+		req.SortBy = strings.Split(v, ",")
+	}
+	// 适配amis
+	if v := input.Query("orderBy"); v != "" {
+		req.SortBy = []string{v}
+	}
+	// order: desc,asc
+	if v := input.Query("order"); v != "" {
+		req.Order = strings.Split(v, ",")
+	}
+	// 适配amis
+	if v := input.Query("orderDir"); v != "" {
+		req.Order = []string{v}
+	}
+
+	if beego.AppConfig.DefaultString("webKind", "BS") == "AMIS" {
+		for kInit, v1 := range ctx.Request.URL.Query() {
+			if reservedKey(ctx, kInit) {
+				continue
+			}
+			var cond *QueryConditon
+			if cond, err = parseQueryCond(kInit, v1[0], ","); err != nil {
+				return nil, err
+			}
+			if cond != nil {
+				req.Query = append(req.Query, cond)
+			}
+		}
+	} else if v := input.Query("query"); v != "" {
+		for _, c := range strings.Split(v, ",") { // 分割多个查询key
+			kv := strings.SplitN(c, ":", 2)
+			if len(kv) != 2 {
+				logs.Error("query condtion format error:%s, need key:value", kv)
+				return nil, QueryCondErr
+			}
+			var cond *QueryConditon
+			if cond, err = parseQueryCond(kv[0], kv[1], "|"); err != nil {
+				return nil, err
+			}
+			if cond != nil {
+				req.Query = append(req.Query, cond)
+			}
+		}
+	}
+	for _, cond := range req.Query {
+		cond.Location = req.Location
+	}
+	if err = req.Validate(allowed...); err != nil {
+		return nil, err
+	}
+	if scope := RowScopeOf(ctx); scope != nil {
+		req.Scopes = append(req.Scopes, scope)
+	}
+	return req, nil
+}
+
+// ParseExportRequest 解析导出的查询参数，单页条数上限为配置 ExportMaxLimit（默认10000）
+func ParseExportRequest(ctx *context.Context, allowed ...string) (req *ListRequest, err error) {
+	if req, err = ParseListRequest(ctx, allowed...); err != nil {
+		return
+	}
+	req.MaxLimit = exportMaxLimit()
+	if _, ok := ctx.Request.URL.Query()["limit"]; !ok {
+		req.Limit = req.MaxLimit
+	}
+	return
+}
+
+// 解析一个查询条件，key格式为 k|type，值为空时忽略（is-null/not-null除外）
+func parseQueryCond(kInit, vInit, sep string) (cond *QueryConditon, err error) {
+	cond = new(QueryConditon)
+	key_type := strings.Split(kInit, "|") // 解析key中的type信息
+	if len(key_type) == 2 {
+		cond.QueryKey = key_type[0]
+		cond.QueryType = key_type[1]
+	} else if len(key_type) == 1 {
+		cond.QueryKey = key_type[0]
+		cond.QueryType = MultiText
+	} else {
+		logs.Error("Error: invalid query key|type format," + kInit)
+		return nil, QueryCondErr
+	}
+	if cond.QueryType == IsNull || cond.QueryType == NotNull {
+		return cond, nil
+	}
+	if len(strings.TrimSpace(vInit)) == 0 {
+		return nil, nil
+	}
+	cond.QueryValues = strings.Split(vInit, sep) // 解析出values信息
+	return cond, nil
+}
+
+// 时区支持IANA名称以及 +08:00 形式的偏移
+func parseLocation(tz string) (loc *time.Location, err error) {
+	// url中未转义的+会被解析成空格
+	tz = strings.Replace(tz, " ", "+", 1)
+	if t, perr := time.Parse("-07:00", tz); perr == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(tz, offset), nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// Validate 校验查询字段、排序字段和返回字段，allowed为空时只校验字段名格式
+func (req *ListRequest) Validate(allowed ...string) error {
+	allowMap := make(map[string]struct{}, len(allowed))
+	for _, f := range allowed {
+		allowMap[f] = struct{}{}
+	}
+	check := func(kind, name string) error {
+		if !ValidIdentifier(name) {
+			logs.Error("invalid %s[%s]", kind, name)
+			return QueryFieldErr
+		}
+		if len(allowMap) > 0 {
+			if _, ok := allowMap[name]; !ok {
+				logs.Error("%s[%s] is not allowed", kind, name)
+				return QueryFieldErr
+			}
+		}
+		return nil
+	}
+	for _, cond := range req.Query {
+		if err := check("query key", cond.QueryKey); err != nil {
+			return err
+		}
+	}
+	for _, f := range req.Fields {
+		if err := check("field", f); err != nil {
+			return err
+		}
+	}
+	for _, f := range req.SortBy {
+		if err := check("sortby", f); err != nil {
+			return err
+		}
+	}
+	for _, o := range req.Order {
+		if !validOrder(o) {
+			logs.Error("invalid order[%s]", o)
+			return QueryCondErr
+		}
+	}
+	if len(req.Order) > 1 && len(req.Order) != len(req.SortBy) {
+		logs.Error("'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		return QueryCondErr
+	}
+	return nil
+}
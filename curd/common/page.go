@@ -0,0 +1,517 @@
+package common
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// 总数统计方式
+const (
+	CountExact  = "exact"  // COUNT(*)
+	CountApprox = "approx" // 使用数据库的统计信息估算（仅无查询条件时），不支持时不返回
+	CountNone   = "none"   // 不统计总数
+)
+
+// Page 分页查询结果，游标模式下返回 next/prev，不返回 total（除非指定了count）
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  *int64      `json:"total,omitempty"`
+	Approx bool        `json:"approx,omitempty"` // total 是估算值
+	Next   string      `json:"next,omitempty"`   // 下一页游标
+	Prev   string      `json:"prev,omitempty"`   // 上一页游标
+}
+
+// 游标中的一个排序值，保留类型信息以便还原为查询参数
+type cursorValue struct {
+	T string `json:"t"`
+	V string `json:"v"`
+}
+
+// Cursor 游标，记录分页边界记录的排序键值
+type Cursor struct {
+	Values []interface{} // 排序键值，顺序与排序字段一致
+	Prev   bool          // true 表示取边界之前的数据
+	Digest string        // 排序和查询条件的摘要，游标只能用于生成它的查询
+}
+
+type cursorJSON struct {
+	V []cursorValue `json:"v"`
+	P bool          `json:"p,omitempty"`
+	D string        `json:"d,omitempty"`
+}
+
+// EncodeCursor 游标编码成不透明字符串
+func EncodeCursor(c Cursor) (s string, err error) {
+	cj := cursorJSON{P: c.Prev, D: c.Digest}
+	for _, v := range c.Values {
+		var cv cursorValue
+		if cv, err = toCursorValue(v); err != nil {
+			return
+		}
+		cj.V = append(cj.V, cv)
+	}
+	var b []byte
+	if b, err = json.Marshal(cj); err != nil {
+		return
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// 排序值转换为带类型的字符串，指针取其指向的值，sql.NullXXX 等取其 Value
+func toCursorValue(v interface{}) (cv cursorValue, err error) {
+	if isNullValue(v) {
+		return cursorValue{T: "null"}, nil
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return cursorValue{T: "null"}, nil
+		}
+		rv = rv.Elem()
+	}
+	if t, ok := rv.Interface().(time.Time); ok {
+		return cursorValue{T: "time", V: t.Format(time.RFC3339Nano)}, nil
+	}
+	if valuer, ok := rv.Interface().(driver.Valuer); ok {
+		var dv driver.Value
+		if dv, err = valuer.Value(); err != nil {
+			return
+		}
+		return toCursorValue(dv)
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		cv = cursorValue{T: "string", V: rv.String()}
+	case reflect.Bool:
+		cv = cursorValue{T: "bool", V: strconv.FormatBool(rv.Bool())}
+	case reflect.Float32, reflect.Float64:
+		cv = cursorValue{T: "float", V: strconv.FormatFloat(rv.Float(), 'g', -1, 64)}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		cv = cursorValue{T: "int", V: strconv.FormatInt(rv.Int(), 10)}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		cv = cursorValue{T: "uint", V: strconv.FormatUint(rv.Uint(), 10)}
+	default:
+		err = fmt.Errorf("unsupported cursor value type %T", v)
+	}
+	return
+}
+
+// DecodeCursor 解析游标
+func DecodeCursor(s string) (c Cursor, err error) {
+	var b []byte
+	if b, err = base64.RawURLEncoding.DecodeString(s); err != nil {
+		return
+	}
+	var cj cursorJSON
+	if err = json.Unmarshal(b, &cj); err != nil {
+		return
+	}
+	c.Prev, c.Digest = cj.P, cj.D
+	for _, cv := range cj.V {
+		var v interface{}
+		switch cv.T {
+		case "null":
+		case "time":
+			v, err = time.Parse(time.RFC3339Nano, cv.V)
+		case "string":
+			v = cv.V
+		case "bool":
+			v, err = strconv.ParseBool(cv.V)
+		case "float":
+			v, err = strconv.ParseFloat(cv.V, 64)
+		case "int":
+			v, err = strconv.ParseInt(cv.V, 10, 64)
+		case "uint":
+			v, err = strconv.ParseUint(cv.V, 10, 64)
+		default:
+			err = fmt.Errorf("unknown cursor value type %s", cv.T)
+		}
+		if err != nil {
+			return
+		}
+		c.Values = append(c.Values, v)
+	}
+	return
+}
+
+// 单页条数上限，ListMaxLimit 默认100，导出使用 ExportMaxLimit 默认10000
+func listMaxLimit() int {
+	return beego.AppConfig.DefaultInt("ListMaxLimit", 100)
+}
+
+func exportMaxLimit() int {
+	return beego.AppConfig.DefaultInt("ExportMaxLimit", 10000)
+}
+
+// 实际使用的条数
+func (req *ListRequest) limit() int {
+	max := req.MaxLimit
+	if max <= 0 {
+		max = listMaxLimit()
+	}
+	if req.Limit <= 0 || req.Limit > max {
+		return max
+	}
+	return req.Limit
+}
+
+// FindPage 按请求参数分页查询，ret 为切片指针
+// 请求带 cursor 参数时使用游标（keyset）分页，否则使用 offset/limit
+func FindPage(db *gorm.DB, model interface{}, ret interface{}, req *ListRequest) (page *Page, err error) {
+	if len(req.Scopes) > 0 {
+		db = db.Scopes(req.Scopes...)
+	}
+	if req.CursorMode {
+		return findCursorPage(db, model, ret, req)
+	}
+	var g *gorm.DB
+	if g, err = BuildQuery(db.Model(model), req.Query, req.Fields, req.SortBy, req.Order); err != nil {
+		return
+	}
+	page = &Page{Items: ret}
+	if err = countPage(db, g, model, req, page); err != nil {
+		return
+	}
+	if err = g.Offset(req.Offset).Limit(req.limit()).Find(ret).Error; err != nil {
+		logs.Error("find page failed,", err.Error())
+		return nil, err
+	}
+	return page, nil
+}
+
+// 统计总数
+func countPage(db, g *gorm.DB, model interface{}, req *ListRequest, page *Page) (err error) {
+	var total int64
+	switch req.CountMode {
+	case CountNone:
+		return nil
+	case CountApprox:
+		if len(req.Query) == 0 && len(req.Scopes) == 0 {
+			if total, ok := approxCount(db, model); ok {
+				page.Total, page.Approx = &total, true
+				return nil
+			}
+		}
+		if req.CursorMode {
+			// 游标分页估算不可用时不返回总数
+			return nil
+		}
+		// offset 分页需要总数，估算不可用时使用精确统计
+	case "":
+		if req.CursorMode {
+			return nil
+		}
+	}
+	if err = g.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		logs.Error("Get CRUD obj Count error %s", err.Error())
+		return
+	}
+	page.Total = &total
+	return nil
+}
+
+// 从数据库统计信息中获取表的估算行数，支持mysql和postgres
+func approxCount(db *gorm.DB, model interface{}) (count int64, ok bool) {
+	var sql string
+	switch dbgorm.Dialect(db) {
+	case dbgorm.DialectMySQL:
+		sql = "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
+	case dbgorm.DialectPostgres:
+		sql = "SELECT reltuples::bigint FROM pg_class WHERE relname = ?"
+	default:
+		return 0, false
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		logs.Error("parse model failed,", err.Error())
+		return 0, false
+	}
+	var rows *int64
+	if err := db.Raw(sql, stmt.Schema.Table).Scan(&rows).Error; err != nil || rows == nil || *rows < 0 {
+		return 0, false
+	}
+	return *rows, true
+}
+
+// 游标分页的排序键
+type sortKey struct {
+	column   string
+	field    *schema.Field
+	desc     bool
+	nullable bool // 可以为NULL，NULL 按最小值排序
+}
+
+// 可以为NULL的字段：没有 not null 约束的指针和 sql.NullXXX 等类型
+func nullableField(field *schema.Field) bool {
+	if field.NotNull || field.PrimaryKey {
+		return false
+	}
+	if field.FieldType.Kind() == reflect.Ptr {
+		return true
+	}
+	_, ok := reflect.New(field.FieldType).Interface().(driver.Valuer)
+	return ok
+}
+
+// 排序值是否为NULL
+func isNullValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return true
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		dv, err := valuer.Value()
+		return err == nil && dv == nil
+	}
+	return false
+}
+
+// 排序字段对应的列和模型字段，entity.col（ParseListRequest 中为 entity__col）按 col 查找字段
+func sortField(sch *schema.Schema, name string) (column string, field *schema.Field) {
+	if field = sch.LookUpField(name); field != nil {
+		return name, field
+	}
+	column = strings.Replace(name, "__", ".", -1)
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		field = sch.LookUpField(column[i+1:])
+	}
+	return
+}
+
+// 根据排序参数确定排序键，最后追加主键保证顺序唯一
+func cursorSortKeys(sch *schema.Schema, req *ListRequest) (keys []sortKey, err error) {
+	var hasPK bool
+	pk := sch.PrioritizedPrimaryField
+	for i, name := range req.SortBy {
+		column, field := sortField(sch, name)
+		if field == nil {
+			return nil, fmt.Errorf("sortby field[%s] not found in %s", name, sch.Name)
+		}
+		var o string
+		if len(req.Order) == len(req.SortBy) {
+			o = req.Order[i]
+		} else if len(req.Order) == 1 {
+			o = req.Order[0]
+		}
+		keys = append(keys, sortKey{column: column, field: field, desc: strings.EqualFold(o, "desc"), nullable: nullableField(field)})
+		if field == pk {
+			hasPK = true
+		}
+	}
+	if !hasPK {
+		if pk == nil {
+			return nil, fmt.Errorf("cursor pagination need primary key, model:%s", sch.Name)
+		}
+		var desc bool
+		if len(keys) > 0 {
+			desc = keys[len(keys)-1].desc
+		}
+		keys = append(keys, sortKey{column: pk.DBName, field: pk, desc: desc})
+	}
+	return
+}
+
+// 排序键和查询条件的摘要，翻页时排序或者查询条件变化的游标无效
+func cursorDigest(keys []sortKey, query []*QueryConditon) string {
+	h := fnv.New64a()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s %v;", k.column, k.desc)
+	}
+	for _, q := range query {
+		fmt.Fprintf(h, "%s|%s:%q;", q.QueryKey, q.QueryType, q.QueryValues)
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// keyset 条件：(k1 > v1) OR (k1 = v1 AND k2 > v2) ...
+// NULL 按最小值比较：大于NULL为 IS NOT NULL，没有小于NULL的值，可为NULL的列小于v时包含NULL
+func keysetCond(keys []sortKey, values []interface{}, prev bool) (sql string, args []interface{}) {
+	var ors []string
+	for i := range keys {
+		var ands []string
+		var andArgs []interface{}
+		for j := 0; j < i; j++ {
+			if isNullValue(values[j]) {
+				ands = append(ands, keys[j].column+" IS NULL")
+				continue
+			}
+			ands = append(ands, fmt.Sprintf("%s = ?", keys[j].column))
+			andArgs = append(andArgs, values[j])
+		}
+		greater := keys[i].desc == prev
+		switch {
+		case isNullValue(values[i]) && !greater:
+			continue
+		case isNullValue(values[i]):
+			ands = append(ands, keys[i].column+" IS NOT NULL")
+		case !greater && keys[i].nullable:
+			ands = append(ands, fmt.Sprintf("(%s < ? OR %s IS NULL)", keys[i].column, keys[i].column))
+			andArgs = append(andArgs, values[i])
+		default:
+			op := ">"
+			if !greater {
+				op = "<"
+			}
+			ands = append(ands, fmt.Sprintf("%s %s ?", keys[i].column, op))
+			andArgs = append(andArgs, values[i])
+		}
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		args = append(args, andArgs...)
+	}
+	if len(ors) == 0 {
+		return "1 = 0", nil
+	}
+	return strings.Join(ors, " OR "), args
+}
+
+// 游标分页
+func findCursorPage(db *gorm.DB, model interface{}, ret interface{}, req *ListRequest) (page *Page, err error) {
+	stmt := &gorm.Statement{DB: db}
+	if err = stmt.Parse(model); err != nil {
+		logs.Error("parse model failed,", err.Error())
+		return
+	}
+	var keys []sortKey
+	if keys, err = cursorSortKeys(stmt.Schema, req); err != nil {
+		logs.Error(err.Error())
+		return nil, QueryCondErr
+	}
+	digest := cursorDigest(keys, req.Query)
+	var cursor Cursor
+	if req.Cursor != "" {
+		if cursor, err = DecodeCursor(req.Cursor); err != nil || len(cursor.Values) != len(keys) || cursor.Digest != digest {
+			logs.Error("invalid cursor[%s], sortby or query changed", req.Cursor)
+			return nil, QueryCondErr
+		}
+	}
+	// 返回字段中需要包含排序键
+	fields := req.Fields
+	if len(fields) > 0 {
+		selected := map[string]struct{}{}
+		for _, f := range fields {
+			selected[f] = struct{}{}
+		}
+		for _, k := range keys {
+			if _, ok := selected[k.column]; !ok {
+				fields = append(fields, k.column)
+			}
+		}
+	}
+	var g *gorm.DB
+	if g, err = BuildQuery(db.Model(model), req.Query, fields, nil, nil); err != nil {
+		return
+	}
+	page = &Page{Items: ret}
+	if err = countPage(db, g, model, req, page); err != nil {
+		return
+	}
+	// 查询下一页是否存在时使用不带游标条件和排序的查询
+	base := g.Session(&gorm.Session{})
+	g = g.Session(&gorm.Session{})
+	if len(cursor.Values) > 0 {
+		sql, args := keysetCond(keys, cursor.Values, cursor.Prev)
+		g = g.Where(sql, args...)
+	}
+	for _, k := range keys {
+		desc := k.desc != cursor.Prev // 向前翻页时反向排序，取到结果后再反转
+		if k.nullable {
+			// 各数据库NULL的默认顺序不同，统一按最小值排序
+			if desc {
+				g = g.Order(k.column + " IS NULL asc")
+			} else {
+				g = g.Order(k.column + " IS NULL desc")
+			}
+		}
+		if desc {
+			g = g.Order(k.column + " desc")
+		} else {
+			g = g.Order(k.column + " asc")
+		}
+	}
+	limit := req.limit()
+	// 多取一条判断是否还有数据
+	if err = g.Limit(limit + 1).Find(ret).Error; err != nil {
+		logs.Error("find cursor page failed,", err.Error())
+		return nil, err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(ret))
+	more := rv.Len() > limit
+	if more {
+		rv.Set(rv.Slice(0, limit))
+	}
+	if cursor.Prev {
+		swap := reflect.Swapper(rv.Interface())
+		for i, j := 0, rv.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+	if rv.Len() == 0 {
+		return page, nil
+	}
+	// 向后翻页时：还有数据才有next；上一页存在的条件是当前请求带了游标
+	hasNext, hasPrev := more, len(cursor.Values) > 0
+	if cursor.Prev {
+		// 向前翻页时多取的一条在前面，后面是否还有数据需要查询
+		hasPrev = more
+		if hasNext, err = existsAfter(base, keys, rv.Index(rv.Len()-1), ret); err != nil {
+			return nil, err
+		}
+	}
+	if hasNext {
+		if page.Next, err = rowCursor(keys, rv.Index(rv.Len()-1), false, digest); err != nil {
+			return
+		}
+	}
+	if hasPrev {
+		if page.Prev, err = rowCursor(keys, rv.Index(0), true, digest); err != nil {
+			return
+		}
+	}
+	return page, nil
+}
+
+// 排在 row 之后是否还有数据
+func existsAfter(g *gorm.DB, keys []sortKey, row reflect.Value, ret interface{}) (ok bool, err error) {
+	row = reflect.Indirect(row)
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		values[i], _ = k.field.ValueOf(row)
+	}
+	sql, args := keysetCond(keys, values, false)
+	probe := reflect.New(reflect.TypeOf(ret).Elem())
+	var result *gorm.DB
+	if result = g.Where(sql, args...).Limit(1).Find(probe.Interface()); result.Error != nil {
+		logs.Error("query next page failed,", result.Error.Error())
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// 根据一行数据生成游标
+func rowCursor(keys []sortKey, row reflect.Value, prev bool, digest string) (s string, err error) {
+	row = reflect.Indirect(row)
+	c := Cursor{Prev: prev, Digest: digest}
+	for _, k := range keys {
+		v, _ := k.field.ValueOf(row)
+		c.Values = append(c.Values, v)
+	}
+	if s, err = EncodeCursor(c); err != nil {
+		logs.Error("encode cursor failed,", err.Error())
+	}
+	return
+}
@@ -0,0 +1,199 @@
+package common
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+	"gorm.io/gorm"
+)
+
+func Test_EncodeCursor(t *testing.T) {
+	now := time.Now()
+	n := 5
+	s, err := EncodeCursor(Cursor{Values: []interface{}{now, "a,b", -3, uint8(7), 1.5, true, &n}, Prev: true, Digest: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := DecodeCursor(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Prev || c.Digest != "x" || len(c.Values) != 7 || !c.Values[0].(time.Time).Equal(now) ||
+		!reflect.DeepEqual(c.Values[1:], []interface{}{"a,b", int64(-3), uint64(7), 1.5, true, int64(5)}) {
+		t.Errorf("unexpected cursor %+v", c)
+	}
+	var nilPtr *int
+	if s, err = EncodeCursor(Cursor{Values: []interface{}{nilPtr, nil, sql.NullInt64{Int64: 3, Valid: true}, sql.NullTime{}}}); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = DecodeCursor(s); err != nil || !reflect.DeepEqual(c.Values, []interface{}{nil, nil, int64(3), nil}) {
+		t.Errorf("unexpected null cursor %+v %v", c, err)
+	}
+	if _, err = EncodeCursor(Cursor{Values: []interface{}{[]int{1}}}); err == nil {
+		t.Error("expect unsupported type error")
+	}
+	// 非base64、未知类型
+	for _, bad := range []string{"!!", "eyJ2IjpbeyJ0IjoieCIsInYiOiIxIn1dfQ"} {
+		if _, err = DecodeCursor(bad); err == nil {
+			t.Errorf("%s: expect decode error", bad)
+		}
+	}
+}
+
+func Test_keysetCond(t *testing.T) {
+	keys := []sortKey{{column: "a"}, {column: "b", desc: true}, {column: "id"}}
+	for _, c := range []struct {
+		keys []sortKey
+		prev bool
+		sql  string
+	}{
+		{keys[:1], false, "(a > ?)"},
+		{keys[:1], true, "(a < ?)"},
+		{[]sortKey{{column: "a", desc: true}, {column: "id", desc: true}}, false, "(a < ?) OR (a = ? AND id < ?)"},
+		{keys, false, "(a > ?) OR (a = ? AND b < ?) OR (a = ? AND b = ? AND id > ?)"},
+		{keys, true, "(a < ?) OR (a = ? AND b > ?) OR (a = ? AND b = ? AND id < ?)"},
+		{[]sortKey{{column: "a", nullable: true}, {column: "id"}}, true, "((a < ? OR a IS NULL)) OR (a = ? AND id < ?)"},
+	} {
+		values := []interface{}{1, 2, 3}[:len(c.keys)]
+		sql, args := keysetCond(c.keys, values, c.prev)
+		if sql != c.sql || len(args) != len(c.keys)*(len(c.keys)+1)/2 {
+			t.Errorf("want %s, got %s %v", c.sql, sql, args)
+		}
+	}
+	// NULL 按最小值比较
+	nullKeys := []sortKey{{column: "a", nullable: true}, {column: "id"}}
+	for _, c := range []struct {
+		prev bool
+		sql  string
+		args int
+	}{
+		{false, "(a IS NOT NULL) OR (a IS NULL AND id > ?)", 1},
+		{true, "(a IS NULL AND id < ?)", 1},
+	} {
+		sql, args := keysetCond(nullKeys, []interface{}{nil, 1}, c.prev)
+		if sql != c.sql || len(args) != c.args {
+			t.Errorf("want %s, got %s %v", c.sql, sql, args)
+		}
+	}
+	if sql, args := keysetCond(nullKeys[:1], []interface{}{nil}, true); sql != "1 = 0" || len(args) != 0 {
+		t.Errorf("expect no rows before null, got %s %v", sql, args)
+	}
+}
+
+func Test_FindCursorPage(t *testing.T) {
+	db := testdb.New(t, &queryItem{})
+	for i := 1; i <= 7; i++ {
+		db.Create(&queryItem{Title: fmt.Sprintf("t%d", i), Num: i % 3})
+	}
+	find := func(params url.Values) (*Page, []queryItem, error) {
+		ctx, _ := newTestContext(httptest.NewRequest("GET", "/?"+params.Encode(), nil))
+		req, err := ParseListRequest(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		var l []queryItem
+		page, err := FindPage(db, &queryItem{}, &l, req)
+		return page, l, err
+	}
+	ids := func(l []queryItem) (s string) {
+		for _, it := range l {
+			s += fmt.Sprint(it.ID, ",")
+		}
+		return
+	}
+	note := "n"
+	db.Model(&queryItem{}).Where("id IN ?", []int{2, 5}).Update("note", note)
+	db.Model(&queryItem{}).Where("id = ?", 3).Update("note", "m")
+	for _, c := range []struct {
+		sortby, order string
+		expect        func(*gorm.DB) *gorm.DB
+	}{
+		{"", "", func(g *gorm.DB) *gorm.DB { return g.Order("id") }},
+		{"num", "desc", func(g *gorm.DB) *gorm.DB { return g.Order("num desc, id desc") }},
+		{"num,title", "asc,desc", func(g *gorm.DB) *gorm.DB { return g.Order("num asc, title desc, id desc") }},
+		{"query_items.num", "asc", func(g *gorm.DB) *gorm.DB { return g.Order("num asc, id asc") }},
+		// 可为NULL的排序字段，NULL 最小
+		{"note", "asc", func(g *gorm.DB) *gorm.DB { return g.Order("note IS NULL desc, note asc, id asc") }},
+		{"note", "desc", func(g *gorm.DB) *gorm.DB { return g.Order("note IS NULL asc, note desc, id desc") }},
+	} {
+		var all []queryItem
+		c.expect(db).Find(&all)
+		params := url.Values{"cursor": {""}, "limit": {"3"}, "sortby": {c.sortby}, "order": {c.order}}
+		// 向后翻页
+		var pages []*Page
+		var got string
+		for {
+			page, l, err := find(params)
+			if err != nil {
+				t.Fatalf("%s: %s", c.sortby, err.Error())
+			}
+			if (len(pages) == 0) != (page.Prev == "") {
+				t.Errorf("%s: page %d prev %q", c.sortby, len(pages), page.Prev)
+			}
+			pages, got = append(pages, page), got+ids(l)
+			if page.Next == "" {
+				break
+			}
+			params.Set("cursor", page.Next)
+		}
+		if got != ids(all) || len(pages) != 3 {
+			t.Errorf("%s: want %s, got %s in %d pages", c.sortby, ids(all), got, len(pages))
+		}
+		// 从最后一页向前翻页
+		got = ""
+		params.Set("cursor", pages[len(pages)-1].Prev)
+		for {
+			page, l, err := find(params)
+			if err != nil {
+				t.Fatalf("%s: %s", c.sortby, err.Error())
+			}
+			if page.Next == "" {
+				t.Errorf("%s: page before the last page should have next", c.sortby)
+			}
+			got = ids(l) + got
+			if page.Prev == "" {
+				break
+			}
+			params.Set("cursor", page.Prev)
+		}
+		if want := ids(all[:6]); got != want {
+			t.Errorf("%s: backward want %s, got %s", c.sortby, want, got)
+		}
+	}
+
+	// 最后一页之后的数据删除后，向前翻页到的页没有下一页
+	page, _, _ := find(url.Values{"cursor": {""}, "limit": {"5"}})
+	db.Where("id > ?", 5).Delete(&queryItem{})
+	next, _, _ := find(url.Values{"cursor": {page.Next}, "limit": {"5"}})
+	if next.Prev != "" || next.Next != "" {
+		t.Errorf("expect empty page, got %+v", next)
+	}
+	page, l, err := find(url.Values{"cursor": {""}, "limit": {"2"}, "sortby": {"id"}, "order": {"desc"}})
+	if err != nil || ids(l) != "5,4," {
+		t.Fatalf("unexpected page %s %v", ids(l), err)
+	}
+	if prev, l, _ := find(url.Values{"cursor": {page.Next}, "limit": {"2"}, "sortby": {"id"}, "order": {"desc"}}); ids(l) != "3,2," {
+		t.Errorf("unexpected page %s", ids(l))
+	} else if back, l, _ := find(url.Values{"cursor": {prev.Prev}, "limit": {"2"}, "sortby": {"id"}, "order": {"desc"}}); ids(l) != "5,4," || back.Next == "" || back.Prev != "" {
+		t.Errorf("unexpected first page %s %+v", ids(l), back)
+	}
+
+	// 排序或查询条件变化后游标无效
+	for _, params := range []url.Values{
+		{"cursor": {page.Next}, "sortby": {"id"}, "order": {"asc"}},
+		{"cursor": {page.Next}, "sortby": {"num"}, "order": {"desc"}},
+		{"cursor": {page.Next}, "sortby": {"id"}, "order": {"desc"}, "query": {"title:t"}},
+		{"cursor": {"eyJ2IjpbeyJ0IjoiaW50IiwidiI6IjEifV19"}},
+		{"cursor": {"garbage"}},
+	} {
+		if _, _, err := find(params); err != QueryCondErr {
+			t.Errorf("%v: expect invalid cursor, got %v", params, err)
+		}
+	}
+}
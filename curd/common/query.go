@@ -1,20 +1,224 @@
 package common
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"gorm.io/gorm"
+)
+
 // 查询条件常量
 const (
 	MultiSelect      = "multi-select"       // 多选
 	MultiText        = "multi-text"         // 模糊多选
 	NumRange         = "num-range"          // 数字范围或者数字查询
 	NotIn            = "not-in"             // 不在范围内
-	Range            = "range"              // 日期区间的查询
+	Range            = "range"              // 日期区间的查询（同date-range）
 	CommaMultiSelect = "comma-multi-select" //数据库中存放是逗号分隔的值
+	Eq               = "eq"                 // 等于
+	Ne               = "ne"                 // 不等于
+	Gt               = "gt"                 // 大于
+	Gte              = "gte"                // 大于等于
+	Lt               = "lt"                 // 小于
+	Lte              = "lte"                // 小于等于
+	IsNull           = "is-null"            // 为空，不需要值
+	NotNull          = "not-null"           // 不为空，不需要值
+	Prefix           = "prefix"             // 前缀匹配
+	DateRange        = "date-range"         // 日期区间，值为开始|结束，可以为空表示不限
 )
 
+// 比较类查询对应的操作符
+var compareOps = map[string]string{
+	Eq:  "=",
+	Ne:  "<>",
+	Gt:  ">",
+	Gte: ">=",
+	Lt:  "<",
+	Lte: "<=",
+}
+
+// 字段名（含表名前缀）格式，防止SQL注入
+var identifierReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// 日期区间支持的时间格式，纯数字按unix时间戳（秒）处理
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
 // QueryConditon 表格查询条件对象
 type QueryConditon struct {
 	QueryKey    string
 	QueryType   string // multi-select  multi-text  num-range  comma-multi-select
 	QueryValues []string
+	Location    *time.Location // 日期区间查询使用的时区，为空时使用服务器时区
+}
+
+// ValidIdentifier 判断字段名是否合法
+func ValidIdentifier(name string) bool {
+	return identifierReg.MatchString(name)
+}
+
+// 解析日期区间的一个端点，dateOnly 表示只有日期部分
+func parseDateValue(v string, loc *time.Location) (t time.Time, dateOnly bool, err error) {
+	if sec, perr := strconv.ParseInt(v, 10, 64); perr == nil && len(v) > len("20060102") {
+		return time.Unix(sec, 0).In(loc), false, nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err = time.ParseInLocation(layout, v, loc); err == nil {
+			return t, layout == "2006-01-02", nil
+		}
+	}
+	return t, false, fmt.Errorf("invalid date value[%s]", v)
+}
+
+// 日期区间查询条件，结束值只有日期时包含当天
+func dateRangeCond(query *QueryConditon) (sql []string, vals []interface{}, err error) {
+	if len(query.QueryValues) == 0 || len(query.QueryValues) > 2 {
+		return nil, nil, fmt.Errorf("query params err %+v", query)
+	}
+	loc := query.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	if v := strings.TrimSpace(query.QueryValues[0]); v != "" {
+		var start time.Time
+		if start, _, err = parseDateValue(v, loc); err != nil {
+			return
+		}
+		sql = append(sql, fmt.Sprintf("%s >= ?", query.QueryKey))
+		vals = append(vals, start)
+	}
+	if len(query.QueryValues) == 2 {
+		if v := strings.TrimSpace(query.QueryValues[1]); v != "" {
+			var end time.Time
+			var dateOnly bool
+			if end, dateOnly, err = parseDateValue(v, loc); err != nil {
+				return
+			}
+			if dateOnly {
+				sql = append(sql, fmt.Sprintf("%s < ?", query.QueryKey))
+				vals = append(vals, end.AddDate(0, 0, 1))
+			} else {
+				sql = append(sql, fmt.Sprintf("%s <= ?", query.QueryKey))
+				vals = append(vals, end)
+			}
+		}
+	}
+	return
+}
+
+// BuildQuery 根据查询条件、返回字段和排序构造查询
+func BuildQuery(g *gorm.DB, querys []*QueryConditon, fields []string, sortby []string, order []string) (o *gorm.DB, err error) {
+	for _, query := range querys {
+		if !ValidIdentifier(query.QueryKey) {
+			return nil, fmt.Errorf("invalid query key[%s]", query.QueryKey)
+		}
+		switch query.QueryType {
+		case MultiSelect: // 多选
+			g = g.Where(fmt.Sprintf("%s in (?)", query.QueryKey), query.QueryValues)
+		case NumRange: // 数字范围
+			if len(query.QueryValues) == 2 {
+				g = g.Where(fmt.Sprintf("%s >= ? AND %s <= ?", query.QueryKey, query.QueryKey), query.QueryValues[0], query.QueryValues[1])
+			} else {
+				return nil, fmt.Errorf("query params err %+v", query)
+			}
+		case NotIn: //
+			g = g.Not(query.QueryKey, query.QueryValues)
+		case CommaMultiSelect:
+			var whereCond []string
+			var values []interface{}
+			if len(query.QueryValues) > 0 {
+				for _, v := range query.QueryValues {
+					cond, arg := dbgorm.FindInSet(g, query.QueryKey, v)
+					whereCond = append(whereCond, cond)
+					values = append(values, arg)
+				}
+				g = g.Where(strings.Join(whereCond, " OR "), values...)
+			}
+		case Eq, Ne, Gt, Gte, Lt, Lte:
+			if len(query.QueryValues) != 1 {
+				return nil, fmt.Errorf("query params err %+v", query)
+			}
+			g = g.Where(fmt.Sprintf("%s %s ?", query.QueryKey, compareOps[query.QueryType]), query.QueryValues[0])
+		case IsNull:
+			g = g.Where(fmt.Sprintf("%s IS NULL", query.QueryKey))
+		case NotNull:
+			g = g.Where(fmt.Sprintf("%s IS NOT NULL", query.QueryKey))
+		case Prefix:
+			sql := make([]string, len(query.QueryValues))
+			val := make([]interface{}, len(query.QueryValues))
+			for i := range query.QueryValues {
+				sql[i] = dbgorm.Like(g, query.QueryKey)
+				val[i] = dbgorm.LikeEscape(query.QueryValues[i]) + "%"
+			}
+			g = g.Where(strings.Join(sql, " or "), val...)
+		case DateRange, Range:
+			var sql []string
+			var val []interface{}
+			if sql, val, err = dateRangeCond(query); err != nil {
+				return nil, err
+			}
+			if len(sql) > 0 {
+				g = g.Where(strings.Join(sql, " AND "), val...)
+			}
+		default:
+			// case MultiText: // 模糊多值匹配
+			sql := make([]string, len(query.QueryValues))
+			val := make([]interface{}, len(query.QueryValues))
+			for i := range query.QueryValues {
+				sql[i] = dbgorm.Like(g, query.QueryKey)
+				val[i] = "%" + dbgorm.LikeEscape(query.QueryValues[i]) + "%"
+			}
+			g = g.Where(strings.Join(sql, " or "), val...)
+		}
+	}
+
+	if len(fields) > 0 {
+		for _, f := range fields {
+			if !ValidIdentifier(f) {
+				return nil, fmt.Errorf("invalid field[%s]", f)
+			}
+		}
+		g = g.Select(fields)
+	}
+	if len(sortby) != 0 {
+		for _, f := range sortby {
+			if !ValidIdentifier(f) {
+				return nil, fmt.Errorf("invalid sortby field[%s]", f)
+			}
+		}
+		for _, o := range order {
+			if !validOrder(o) {
+				return nil, fmt.Errorf("invalid order[%s]", o)
+			}
+		}
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i := range sortby {
+				g = g.Order(fmt.Sprintf("%s %s", sortby[i], order[i]))
+			}
+
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			for i := range sortby {
+				g = g.Order(fmt.Sprintf("%s %s", sortby[i], order[0]))
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return nil, errors.New("error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	}
+	return g, nil
+}
+
+// 排序方向只能是asc或者desc
+func validOrder(o string) bool {
+	switch strings.ToLower(o) {
+	case "asc", "desc":
+		return true
+	}
+	return false
 }
 
 func QueryKeyReplace(query []*QueryConditon, repMap map[string]string) (ret []*QueryConditon) {
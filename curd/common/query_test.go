@@ -0,0 +1,203 @@
+package common
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+)
+
+func Test_ValidIdentifier(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"title":                    true,
+		"_id":                      true,
+		"user.name":                true,
+		"entity__col":              true,
+		"1col":                     false,
+		"a.b.c":                    false,
+		"":                         false,
+		"title desc":               false,
+		"title;drop table users":   false,
+		"title/**/or/**/1=1":       false,
+		"(select password from u)": false,
+		"title--":                  false,
+		"`title`":                  false,
+		"title,(select 1)":         false,
+		"title\x00":                false,
+	} {
+		if ValidIdentifier(name) != ok {
+			t.Errorf("%q: want %v", name, ok)
+		}
+	}
+}
+
+type queryItem struct {
+	ID    uint
+	Title string
+	Tags  string
+	Num   int
+	Note  *string
+}
+
+func Test_BuildQuery(t *testing.T) {
+	db := testdb.New(t, &queryItem{})
+	note := "x"
+	for _, it := range []queryItem{
+		{Title: "alpha", Tags: "a,b", Num: 1, Note: &note},
+		{Title: "beta", Tags: "b,c", Num: 2},
+		{Title: "gamma", Tags: "c", Num: 3},
+		{Title: "100%", Tags: "a_c", Num: 4},
+		{Title: "1000", Tags: "abc", Num: 5},
+	} {
+		it := it
+		db.Create(&it)
+	}
+	cond := func(key, typ string, vals ...string) []*QueryConditon {
+		return []*QueryConditon{{QueryKey: key, QueryType: typ, QueryValues: vals}}
+	}
+	cases := []struct {
+		name  string
+		query []*QueryConditon
+		count int64
+	}{
+		{"multi-select", cond("title", MultiSelect, "alpha", "gamma"), 2},
+		{"multi-text", cond("title", MultiText, "ph", "amm"), 2},
+		{"multi-text literal %", cond("title", MultiText, "0%"), 1},
+		{"multi-text literal _", cond("tags", MultiText, "_"), 1},
+		{"prefix", cond("title", Prefix, "al", "be"), 2},
+		{"prefix literal %", cond("title", Prefix, "100%"), 1},
+		{"num-range", cond("num", NumRange, "2", "4"), 3},
+		{"not-in", cond("title", NotIn, "alpha", "beta"), 3},
+		{"comma-multi-select", cond("tags", CommaMultiSelect, "b"), 2},
+		{"comma-multi-select literal _", cond("tags", CommaMultiSelect, "a_c"), 1},
+		{"comma-multi-select no wildcard", cond("tags", CommaMultiSelect, "a%"), 0},
+		{"eq", cond("num", Eq, "2"), 1},
+		{"ne", cond("num", Ne, "2"), 4},
+		{"gt", cond("num", Gt, "2"), 3},
+		{"gte", cond("num", Gte, "2"), 4},
+		{"lt", cond("num", Lt, "2"), 1},
+		{"lte", cond("num", Lte, "2"), 2},
+		{"is-null", cond("note", IsNull), 4},
+		{"not-null", cond("note", NotNull), 1},
+	}
+	for _, c := range cases {
+		g, err := BuildQuery(db.Model(&queryItem{}), c.query, nil, []string{"id"}, []string{"asc"})
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err.Error())
+			continue
+		}
+		var count int64
+		if err = g.Count(&count).Error; err != nil || count != c.count {
+			t.Errorf("%s: want %d, got %d %v", c.name, c.count, count, err)
+		}
+	}
+
+	invalid := []struct {
+		name          string
+		query         []*QueryConditon
+		fields        []string
+		sortby, order []string
+	}{
+		{"injection in key", cond("title) or (1=1", Eq, "x"), nil, nil, nil},
+		{"injection in key comment", cond("title--", MultiText, "x"), nil, nil, nil},
+		{"num-range needs two values", cond("num", NumRange, "1"), nil, nil, nil},
+		{"eq needs one value", cond("num", Eq, "1", "2"), nil, nil, nil},
+		{"invalid date", cond("title", DateRange, "yesterday"), nil, nil, nil},
+		{"injection in field", nil, []string{"title", "(select 1)"}, nil, nil},
+		{"injection in sortby", nil, nil, []string{"id;drop table query_items"}, []string{"asc"}},
+		{"injection in order", nil, nil, []string{"id"}, []string{"asc;drop table query_items"}},
+		{"sortby order mismatch", nil, nil, []string{"id", "num", "title"}, []string{"asc", "desc"}},
+	}
+	for _, c := range invalid {
+		if _, err := BuildQuery(db.Model(&queryItem{}), c.query, c.fields, c.sortby, c.order); err == nil {
+			t.Errorf("%s: expect error", c.name)
+		}
+	}
+	// 一个排序方向用于所有排序字段
+	var l []queryItem
+	g, err := BuildQuery(db.Model(&queryItem{}), nil, []string{"id", "num"}, []string{"num", "id"}, []string{"desc"})
+	if err != nil || g.Find(&l).Error != nil || len(l) != 5 || l[0].Num != 5 || l[0].Title != "" {
+		t.Errorf("expect selected fields sorted by num desc, got %+v %v", l, err)
+	}
+}
+
+func Test_ParseListRequest(t *testing.T) {
+	parse := func(params url.Values, allowed ...string) (*ListRequest, error) {
+		ctx, _ := newTestContext(httptest.NewRequest("GET", "/?"+params.Encode(), nil))
+		return ParseListRequest(ctx, allowed...)
+	}
+	req, err := parse(url.Values{
+		"query":  {"title:a|b,num|gte:2,note|is-null:"},
+		"fields": {"id,title"},
+		"sortby": {"num,id"},
+		"order":  {"desc,asc"},
+		"limit":  {"20"},
+		"offset": {"40"},
+		"tz":     {"+08:00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Query) != 3 || req.Query[0].QueryType != MultiText || len(req.Query[0].QueryValues) != 2 ||
+		req.Query[1].QueryType != Gte || req.Query[2].QueryType != IsNull {
+		t.Errorf("unexpected query %+v %+v %+v", req.Query[0], req.Query[1], req.Query[2])
+	}
+	if _, offset := time.Now().In(req.Query[0].Location).Zone(); offset != 8*3600 {
+		t.Errorf("expect query location +08:00, got %d", offset)
+	}
+	if req.Limit != 20 || req.Offset != 40 || len(req.Fields) != 2 || len(req.SortBy) != 2 || req.Order[0] != "desc" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if req, err = parse(url.Values{"cursor": {""}, "count": {"approx"}}); err != nil || !req.CursorMode || req.CountMode != CountApprox {
+		t.Errorf("expect cursor mode with approx count, got %+v %v", req, err)
+	}
+
+	allowed := []string{"id", "title", "num"}
+	for _, c := range []struct {
+		params url.Values
+		err    error
+	}{
+		{url.Values{"query": {"title:x"}}, nil},
+		{url.Values{"query": {"secret:x"}}, QueryFieldErr},
+		{url.Values{"fields": {"id,secret"}}, QueryFieldErr},
+		{url.Values{"sortby": {"secret"}}, QueryFieldErr},
+		{url.Values{"query": {"title or 1=1:x"}}, QueryFieldErr},
+		{url.Values{"query": {"title;delete from t|eq:x"}}, QueryFieldErr},
+		{url.Values{"fields": {"id,(select password from users)"}}, QueryFieldErr},
+		{url.Values{"sortby": {"id desc,title"}}, QueryFieldErr},
+		{url.Values{"query": {"title|eq|x:1"}}, QueryCondErr},
+		{url.Values{"query": {"title"}}, QueryCondErr},
+		{url.Values{"sortby": {"id"}, "order": {"asc;drop"}}, QueryCondErr},
+		{url.Values{"sortby": {"id,title,num"}, "order": {"asc,desc"}}, QueryCondErr},
+		{url.Values{"limit": {"-1"}}, QueryCondErr},
+		{url.Values{"count": {"all"}}, QueryCondErr},
+		{url.Values{"tz": {"Mars/Base"}}, QueryCondErr},
+	} {
+		if _, err := parse(c.params, allowed...); err != c.err {
+			t.Errorf("%v: want %v, got %v", c.params, c.err, err)
+		}
+	}
+
+	// AMIS 模式下非保留参数都是查询条件
+	beego.AppConfig.Set("webKind", "AMIS")
+	defer beego.AppConfig.Set("webKind", "BS")
+	if req, err = parse(url.Values{"title|prefix": {"a,b"}, "perPage": {"10"}, "page": {"3"}, "orderBy": {"num"}, "orderDir": {"desc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Query) != 1 || req.Query[0].QueryType != Prefix || len(req.Query[0].QueryValues) != 2 ||
+		req.Offset != 20 || req.SortBy[0] != "num" || req.Order[0] != "desc" {
+		t.Errorf("unexpected amis request %+v", req)
+	}
+	// 其他接口自己处理的参数只在该接口中保留
+	if req, err = parse(url.Values{"q": {"a"}}); err != nil || len(req.Query) != 1 || req.Query[0].QueryKey != "q" {
+		t.Errorf("expect q as query condition, got %+v %v", req, err)
+	}
+	ctx, _ := newTestContext(httptest.NewRequest("GET", "/?q=a&title=b", nil))
+	SetReservedKeys(ctx, "q")
+	if req, err = ParseListRequest(ctx); err != nil || len(req.Query) != 1 || req.Query[0].QueryKey != "title" {
+		t.Errorf("expect q reserved, got %+v %v", req, err)
+	}
+}
@@ -0,0 +1,198 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/curd/customerror"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+	en_translations "gopkg.in/go-playground/validator.v9/translations/en"
+	zh_translations "gopkg.in/go-playground/validator.v9/translations/zh"
+)
+
+const (
+	LangZhCN = "zh-CN"
+	LangEnUS = "en-US"
+)
+
+var (
+	validate *validator.Validate
+	uni      *ut.UniversalTranslator
+)
+
+// 支持的语言对应的翻译器名称
+var langLocales = map[string]string{
+	LangZhCN: "zh",
+	LangEnUS: "en",
+}
+
+func init() {
+	validate = validator.New()
+	// 字段名使用json tag，和前端提交的参数保持一致
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	zhLocale := zh.New()
+	uni = ut.New(zhLocale, zhLocale, en.New())
+	if trans, ok := uni.GetTranslator("zh"); ok {
+		if err := zh_translations.RegisterDefaultTranslations(validate, trans); err != nil {
+			logs.Error("register zh translations failed,", err.Error())
+		}
+	}
+	if trans, ok := uni.GetTranslator("en"); ok {
+		if err := en_translations.RegisterDefaultTranslations(validate, trans); err != nil {
+			logs.Error("register en translations failed,", err.Error())
+		}
+	}
+}
+
+// FieldError 字段校验错误
+type FieldError struct {
+	Field   string `json:"field"`           // 字段路径，例如 items[0].name
+	Rule    string `json:"rule"`            // 校验规则（validate tag），json格式错误为 type/json
+	Param   string `json:"param,omitempty"` // 规则参数，例如 max=10 中的 10
+	Message string `json:"message"`         // 翻译后的错误信息
+}
+
+// ValidationError 参数校验错误，字段错误列表作为 data 返回
+type ValidationError struct {
+	customerror.CustomError
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Message
+	}
+	return fmt.Sprintf("%s:%s", e.CustomError.Error(), strings.Join(msgs, ";"))
+}
+
+// Unwrap 内部的错误，用于获取http状态码（customerror.StatusOf）和 errors.Is
+func (e *ValidationError) Unwrap() error {
+	return e.CustomError
+}
+
+// GetData 返回给前端的数据
+func (e *ValidationError) GetData() interface{} {
+	return e.Fields
+}
+
+// GetLanguage 根据 Accept-Language 请求头选择语言，未匹配时使用配置 DefaultLanguage（默认 zh-CN）
+func GetLanguage(ctx *context.Context) string {
+	if ctx != nil {
+		for _, part := range strings.Split(ctx.Input.Header("Accept-Language"), ",") {
+			tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+			switch {
+			case strings.HasPrefix(tag, "zh"):
+				return LangZhCN
+			case strings.HasPrefix(tag, "en"):
+				return LangEnUS
+			}
+		}
+	}
+	return beego.AppConfig.DefaultString("DefaultLanguage", LangZhCN)
+}
+
+// 获取语言对应的翻译器
+func getTranslator(lang string) ut.Translator {
+	if trans, ok := uni.GetTranslator(langLocales[lang]); ok {
+		return trans
+	}
+	return uni.GetFallback()
+}
+
+// ValidateStruct 校验对象，错误信息翻译为lang对应的语言
+func ValidateStruct(obj interface{}, lang string) customerror.CustomError {
+	err := validate.Struct(obj)
+	if err == nil {
+		return nil
+	}
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		logs.Error("validate params err:", err.Error())
+		return ParamsValidateError
+	}
+	trans := getTranslator(lang)
+	ret := &ValidationError{CustomError: ParamsValidateError}
+	for _, fe := range verrs {
+		ret.Fields = append(ret.Fields, FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fe.Translate(trans),
+		})
+	}
+	return ret
+}
+
+// 去掉命名空间中的根结构体名称
+func fieldPath(ns string) string {
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+// json 反序列化错误转换为字段错误
+func unmarshalError(err error, lang string) customerror.CustomError {
+	ret := &ValidationError{CustomError: ParamsError}
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		msg := fmt.Sprintf("%s必须是%s类型", e.Field, e.Type.String())
+		if lang == LangEnUS {
+			msg = fmt.Sprintf("%s must be of type %s", e.Field, e.Type.String())
+		}
+		ret.Fields = append(ret.Fields, FieldError{Field: e.Field, Rule: "type", Param: e.Type.String(), Message: msg})
+	default:
+		msg := "请求参数不是合法的json格式"
+		if lang == LangEnUS {
+			msg = "request body is not valid json"
+		}
+		ret.Fields = append(ret.Fields, FieldError{Rule: "json", Message: msg})
+	}
+	return ret
+}
+
+// RegisterValidation 注册自定义校验规则以及各语言的错误信息
+// messages 的key为语言（zh-CN、en-US），{0}为字段名，{1}为规则参数
+// common.RegisterValidation("mobile", isMobile, map[string]string{common.LangZhCN: "{0}不是合法的手机号"})
+func RegisterValidation(tag string, fn validator.Func, messages map[string]string) (err error) {
+	if err = validate.RegisterValidation(tag, fn); err != nil {
+		return
+	}
+	for lang, msg := range messages {
+		trans, ok := uni.GetTranslator(langLocales[lang])
+		if !ok {
+			return fmt.Errorf("language[%s] not supported", lang)
+		}
+		msg := msg
+		if err = validate.RegisterTranslation(tag, trans, func(ut ut.Translator) error {
+			return ut.Add(tag, msg, true)
+		}, func(ut ut.Translator, fe validator.FieldError) string {
+			t, terr := ut.T(fe.Tag(), fe.Field(), fe.Param())
+			if terr != nil {
+				return fe.(error).Error()
+			}
+			return t
+		}); err != nil {
+			return
+		}
+	}
+	return
+}
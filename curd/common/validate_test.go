@@ -0,0 +1,129 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+type orderItem struct {
+	Name string `json:"name" validate:"required"`
+	Qty  int    `json:"qty" validate:"min=1"`
+}
+
+type order struct {
+	Title string      `json:"title" validate:"required,max=5"`
+	Items []orderItem `json:"items" validate:"dive"`
+}
+
+// 自定义规则需要先注册再校验
+type contact struct {
+	Mobile string `json:"mobile" validate:"testmobile"`
+}
+
+func Test_GetLanguage(t *testing.T) {
+	for header, lang := range map[string]string{
+		"en-US,en;q=0.9":     LangEnUS,
+		"zh-TW;q=0.8":        LangZhCN,
+		"fr-FR,en-GB;q=0.7":  LangEnUS, // 第一个支持的语言
+		"fr-FR,de;q=0.5":     LangZhCN, // 都不支持时使用默认语言
+		"":                   LangZhCN,
+		" EN , zh-CN;q=0.9 ": LangEnUS,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", header)
+		ctx, _ := newTestContext(req)
+		if got := GetLanguage(ctx); got != lang {
+			t.Errorf("%q: expect %s, got %s", header, lang, got)
+		}
+	}
+	beego.AppConfig.Set("DefaultLanguage", LangEnUS)
+	defer beego.AppConfig.Set("DefaultLanguage", "")
+	ctx, _ := newTestContext(httptest.NewRequest("GET", "/", nil))
+	if got := GetLanguage(ctx); got != LangEnUS {
+		t.Errorf("expect configured default language, got %s", got)
+	}
+	if got := GetLanguage(nil); got != LangEnUS {
+		t.Errorf("expect default language without request, got %s", got)
+	}
+}
+
+func Test_ValidateStruct(t *testing.T) {
+	o := &order{Title: "too long", Items: []orderItem{{Name: "a", Qty: 1}, {Qty: 0}}}
+	err := ValidateStruct(o, LangEnUS)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expect validation error, got %v", err)
+	}
+	fields := map[string]FieldError{}
+	for _, f := range verr.Fields {
+		fields[f.Field] = f
+	}
+	// 嵌套字段的路径使用json名称
+	if len(fields) != 3 || fields["title"].Rule != "max" || fields["title"].Param != "5" ||
+		fields["items[1].name"].Rule != "required" || fields["items[1].qty"].Rule != "min" {
+		t.Fatalf("unexpected field errors %+v", verr.Fields)
+	}
+	if msg := fields["items[1].name"].Message; !strings.Contains(msg, "name") || !strings.Contains(msg, "required") {
+		t.Errorf("expect english message, got %s", msg)
+	}
+	if verr.GetCode() != ParamsValidateError.GetCode() || verr.GetData() == nil {
+		t.Errorf("unexpected error %v", verr)
+	}
+
+	zhErr := ValidateStruct(o, LangZhCN).(*ValidationError)
+	if msg := zhErr.Fields[0].Message; !strings.Contains(msg, "title") || strings.Contains(msg, "must") {
+		t.Errorf("expect chinese message, got %s", msg)
+	}
+	if err = ValidateStruct(&order{Title: "ok"}, LangZhCN); err != nil {
+		t.Errorf("expect valid order, got %v", err)
+	}
+}
+
+func Test_UnmarshalError(t *testing.T) {
+	var o order
+	err := json.Unmarshal([]byte(`{"title":"a","items":[{"qty":"x"}]}`), &o)
+	verr := unmarshalError(err, LangEnUS).(*ValidationError)
+	if len(verr.Fields) != 1 || verr.Fields[0].Rule != "type" || verr.Fields[0].Param != "int" ||
+		!strings.HasSuffix(verr.Fields[0].Field, "qty") || !strings.Contains(verr.Fields[0].Message, "must be of type int") {
+		t.Errorf("unexpected type error %+v", verr.Fields)
+	}
+	if verr.GetCode() != ParamsError.GetCode() {
+		t.Errorf("expect params error, got %d", verr.GetCode())
+	}
+	err = json.Unmarshal([]byte(`{"title":`), &o)
+	verr = unmarshalError(err, LangZhCN).(*ValidationError)
+	if len(verr.Fields) != 1 || verr.Fields[0].Rule != "json" || verr.Fields[0].Message != "请求参数不是合法的json格式" {
+		t.Errorf("unexpected syntax error %+v", verr.Fields)
+	}
+}
+
+func Test_RegisterValidation(t *testing.T) {
+	isMobile := func(fl validator.FieldLevel) bool {
+		v := fl.Field().String()
+		return len(v) == 11 && strings.HasPrefix(v, "1")
+	}
+	if err := RegisterValidation("testmobile", isMobile, map[string]string{
+		LangZhCN: "{0}不是合法的手机号",
+		LangEnUS: "{0} is not a valid mobile number",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	o := &contact{Mobile: "123"}
+	for lang, msg := range map[string]string{LangZhCN: "mobile不是合法的手机号", LangEnUS: "mobile is not a valid mobile number"} {
+		verr, ok := ValidateStruct(o, lang).(*ValidationError)
+		if !ok || len(verr.Fields) != 1 || verr.Fields[0].Rule != "testmobile" || verr.Fields[0].Message != msg {
+			t.Errorf("%s: unexpected error %+v", lang, verr)
+		}
+	}
+	if err := ValidateStruct(&contact{Mobile: "13800000000"}, LangZhCN); err != nil {
+		t.Errorf("expect valid mobile, got %v", err)
+	}
+	if err := RegisterValidation("testmobile2", isMobile, map[string]string{"fr-FR": "{0}"}); err == nil {
+		t.Error("expect unsupported language error")
+	}
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xlsx 文件的固定部分
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+)
+
+// XLSXContentType xlsx 文件的 Content-Type
+const XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// WriteXLSX 写一个只有一个工作表的xlsx文件，所有单元格按文本写入
+func WriteXLSX(w io.Writer, sheet string, rows [][]string) (err error) {
+	zw := zip.NewWriter(w)
+	part := func(name, content string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, content)
+		return err
+	}
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+	if err = part("[Content_Types].xml", xlsxContentTypes); err != nil {
+		return
+	}
+	if err = part("_rels/.rels", xlsxRels); err != nil {
+		return
+	}
+	if err = part("xl/_rels/workbook.xml.rels", xlsxWorkbookRels); err != nil {
+		return
+	}
+	if err = part("xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(sheet))); err != nil {
+		return
+	}
+	var f io.Writer
+	if f, err = zw.Create("xl/worksheets/sheet1.xml"); err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		for j, cell := range row {
+			fmt.Fprintf(&b, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, xlsxColumn(j), i+1, xmlEscape(cell))
+		}
+		b.WriteString(`</row>`)
+		// 分段写入，避免大文件全部在内存中
+		if b.Len() > 64*1024 {
+			if _, err = io.WriteString(f, b.String()); err != nil {
+				return
+			}
+			b.Reset()
+		}
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	if _, err = io.WriteString(f, b.String()); err != nil {
+		return
+	}
+	return zw.Close()
+}
+
+// 列号转换为 A B ... Z AA AB ...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	// xml 不允许的控制字符会被替换
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -1,6 +1,10 @@
 package customerror
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
 
 type CustomError interface {
 	Error() string
@@ -8,20 +12,51 @@ type CustomError interface {
 	GetMessage() string
 }
 
+// ExtendedError New/Define 创建的错误，在 CustomError 的基础上支持http状态码、附加信息、模板参数和包装原始错误
+// 自己实现 CustomError 的错误不需要实现这些方法，使用 StatusOf/DetailsOf 获取
+type ExtendedError interface {
+	CustomError
+	// http 状态码，New 创建的错误默认 200
+	GetStatus() int
+	// 附加信息，作为返回的 data
+	GetDetails() map[string]interface{}
+	// 包装原始错误，返回新的错误对象，原对象不变
+	Wrap(cause error) ExtendedError
+	// 附加信息，返回新的错误对象
+	WithDetails(details map[string]interface{}) ExtendedError
+	// 指定http状态码，返回新的错误对象
+	WithStatus(status int) ExtendedError
+	// 错误信息模板中的参数，返回新的错误对象
+	WithParams(params map[string]interface{}) ExtendedError
+	// 获取模板参数
+	GetParams() map[string]interface{}
+	// 原始错误，支持 errors.Is/As
+	Unwrap() error
+	// 错误码相同即认为是同一个错误
+	Is(target error) bool
+}
+
 // 自定义错误对象
 type customErr struct {
-	Code    int    // 错误码
-	Message string // 错误消息
+	Code    int                    // 错误码
+	Message string                 // 错误消息
+	Status  int                    // http 状态码
+	Details map[string]interface{} // 附加信息
+	Params  map[string]interface{} // 错误信息模板参数
+	cause   error                  // 原始错误
 }
 
 // 实例化一个错误对象
-func New(code int, message string) CustomError {
-	return &customErr{code, message}
+func New(code int, message string) ExtendedError {
+	return &customErr{Code: code, Message: message, Status: http.StatusOK}
 }
 
 // 获取错误消息，也是实现error接口
 func (e *customErr) Error() string {
-	return fmt.Sprintf("%d::%s", e.Code, e.Message)
+	if e.cause != nil {
+		return fmt.Sprintf("%d::%s: %s", e.Code, e.GetMessage(), e.cause.Error())
+	}
+	return fmt.Sprintf("%d::%s", e.Code, e.GetMessage())
 }
 
 // 获取错误消息
@@ -29,7 +64,122 @@ func (e *customErr) GetCode() int {
 	return e.Code
 }
 
-// 获取错误码
+// 获取错误码（默认语言，已替换参数）
 func (e *customErr) GetMessage() string {
-	return e.Message
+	return Interpolate(e.Message, e.Params)
+}
+
+// 获取http状态码
+func (e *customErr) GetStatus() int {
+	return e.Status
+}
+
+// 获取附加信息
+func (e *customErr) GetDetails() map[string]interface{} {
+	return e.Details
+}
+
+func (e *customErr) clone() *customErr {
+	c := *e
+	return &c
+}
+
+// 包装原始错误
+func (e *customErr) Wrap(cause error) ExtendedError {
+	c := e.clone()
+	c.cause = cause
+	return c
+}
+
+// 附加信息，和已有的信息合并
+func (e *customErr) WithDetails(details map[string]interface{}) ExtendedError {
+	c := e.clone()
+	c.Details = make(map[string]interface{}, len(e.Details)+len(details))
+	for k, v := range e.Details {
+		c.Details[k] = v
+	}
+	for k, v := range details {
+		c.Details[k] = v
+	}
+	return c
+}
+
+// 指定http状态码
+func (e *customErr) WithStatus(status int) ExtendedError {
+	c := e.clone()
+	c.Status = status
+	return c
+}
+
+// 错误信息模板参数，和已有的参数合并
+func (e *customErr) WithParams(params map[string]interface{}) ExtendedError {
+	c := e.clone()
+	c.Params = make(map[string]interface{}, len(e.Params)+len(params))
+	for k, v := range e.Params {
+		c.Params[k] = v
+	}
+	for k, v := range params {
+		c.Params[k] = v
+	}
+	return c
+}
+
+// 获取模板参数
+func (e *customErr) GetParams() map[string]interface{} {
+	return e.Params
+}
+
+// 原始错误
+func (e *customErr) Unwrap() error {
+	return e.cause
+}
+
+// 错误码相同即认为是同一个错误，errors.Is(err, common.ParamsError)
+func (e *customErr) Is(target error) bool {
+	if t, ok := target.(CustomError); ok {
+		return t.GetCode() == e.Code
+	}
+	return false
+}
+
+// As 从错误链中找出 CustomError
+func As(err error) (CustomError, bool) {
+	var ce CustomError
+	if errors.As(err, &ce) {
+		return ce, true
+	}
+	return nil, false
+}
+
+// 从错误链中找出 ExtendedError
+func asExtended(err error) (ExtendedError, bool) {
+	var ee ExtendedError
+	if err != nil && errors.As(err, &ee) {
+		return ee, true
+	}
+	return nil, false
+}
+
+// StatusOf 错误的http状态码，没有实现 ExtendedError 时为 200
+func StatusOf(err error) int {
+	if ee, ok := asExtended(err); ok && ee.GetStatus() != 0 {
+		return ee.GetStatus()
+	}
+	return http.StatusOK
+}
+
+// DetailsOf 错误的附加信息
+func DetailsOf(err error) map[string]interface{} {
+	if ee, ok := asExtended(err); ok {
+		return ee.GetDetails()
+	}
+	return nil
+}
+
+// 错误信息模板参数
+func paramsOf(err error) map[string]interface{} {
+	if ee, ok := asExtended(err); ok {
+		return ee.GetParams()
+	}
+	return nil
 }
@@ -0,0 +1,99 @@
+package customerror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func Test_Wrap(t *testing.T) {
+	base := New(9901, "test error").WithStatus(http.StatusBadRequest)
+	cause := errors.New("cause")
+	err := fmt.Errorf("outer: %w", base.Wrap(cause))
+	if !errors.Is(err, base) || !errors.Is(err, cause) {
+		t.Fatal("errors.Is failed")
+	}
+	ce, ok := As(err)
+	if !ok || ce.GetCode() != 9901 || StatusOf(err) != http.StatusBadRequest {
+		t.Fatalf("As failed, %v", ce)
+	}
+	if base.Unwrap() != nil {
+		t.Fatal("Wrap changed the original error")
+	}
+}
+
+func Test_WithDetails(t *testing.T) {
+	base := New(9902, "test error")
+	e := base.WithDetails(map[string]interface{}{"field": "name"})
+	if e.GetDetails()["field"] != "name" || len(base.GetDetails()) != 0 {
+		t.Fatal("WithDetails failed")
+	}
+}
+
+// 只实现 CustomError 的错误
+type plainErr struct{ code int }
+
+func (e plainErr) Error() string      { return e.GetMessage() }
+func (e plainErr) GetCode() int       { return e.code }
+func (e plainErr) GetMessage() string { return "plain" }
+
+func Test_PlainCustomError(t *testing.T) {
+	var e CustomError = plainErr{9905}
+	err := fmt.Errorf("outer: %w", e)
+	if ce, ok := As(err); !ok || ce.GetCode() != 9905 {
+		t.Fatalf("As failed, %v", ce)
+	}
+	if StatusOf(err) != http.StatusOK || DetailsOf(err) != nil || Localize(e, "en-US") != "plain" {
+		t.Fatal("plain error should use defaults")
+	}
+	Register(e)
+	if l := Catalog(); l[len(l)-1].Code != 9905 || l[len(l)-1].Status != http.StatusOK {
+		t.Fatalf("unexpected catalog %+v", l[len(l)-1])
+	}
+}
+
+func Test_DefineDuplicate(t *testing.T) {
+	Define(9903, http.StatusOK, "first")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate code should panic")
+		}
+	}()
+	Define(9903, http.StatusOK, "second")
+}
+
+func Test_Localize(t *testing.T) {
+	AddMessages("en-US", map[int]string{9904: "step [{step}] not found"})
+	AddMessages("en-GB", map[int]string{9904: "step [{step}] was not found"})
+	AddMessages("en-AU", map[int]string{9904: "step [{step}] is missing"})
+	e := Define(9904, http.StatusNotFound, "步骤[{step}]不存在")
+	if msg := Localize(e.WithParams(map[string]interface{}{"step": "review"}), "en-US"); msg != "step [review] not found" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	// 主语言匹配按语言名称排序，结果固定
+	for i := 0; i < 10; i++ {
+		if msg := Localize(e, "en"); msg != "step is missing" {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	}
+	if msg := Localize(e.Wrap(errors.New("cause")), "en-GB"); msg != "step was not found" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	// 没有模板时使用错误自身的信息
+	if msg := Localize(e.WithParams(map[string]interface{}{"step": "review"}), "ja-JP"); msg != "步骤[review]不存在" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
+
+func Test_LocalizeUnregistered(t *testing.T) {
+	Define(9906, http.StatusBadRequest, "已注册的错误")
+	AddMessages("en-US", map[int]string{9906: "registered error"})
+	// 错误码相同但不是注册的错误，不使用模板
+	if msg := Localize(New(9906, "其他错误"), "en-US"); msg != "其他错误" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	if msg := Localize(New(9907, "未注册"), "en-US"); msg != "未注册" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+}
@@ -0,0 +1,122 @@
+package customerror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 错误信息模板由定义错误码的包加载（例如 curd/common 的 locales），模板只用于 Define/Register 注册的错误
+var (
+	messages   = make(map[string]map[int]string) // 语言 -> 错误码 -> 模板
+	messagesMu sync.RWMutex
+	// 模板中的参数 {name}，[{name}] 形式的参数没有值时连同括号一起去掉
+	paramReg = regexp.MustCompile(` ?\[\{[A-Za-z0-9_]+\}\]|\{[A-Za-z0-9_]+\}`)
+)
+
+// LoadMessages 加载一个语言的错误信息模板（json，错误码 -> 模板），覆盖已有的模板
+func LoadMessages(lang string, r io.Reader) (err error) {
+	var m map[string]string
+	if err = json.NewDecoder(r).Decode(&m); err != nil {
+		return
+	}
+	tpls := make(map[int]string, len(m))
+	for k, v := range m {
+		var code int
+		if code, err = strconv.Atoi(k); err != nil {
+			return fmt.Errorf("invalid error code[%s]", k)
+		}
+		tpls[code] = v
+	}
+	AddMessages(lang, tpls)
+	return nil
+}
+
+// AddMessages 添加一个语言的错误信息模板，覆盖已有的模板
+func AddMessages(lang string, tpls map[int]string) {
+	messagesMu.Lock()
+	defer messagesMu.Unlock()
+	if messages[lang] == nil {
+		messages[lang] = make(map[int]string)
+	}
+	for code, tpl := range tpls {
+		messages[lang][code] = tpl
+	}
+}
+
+// 查找模板，依次匹配：语言（zh-CN）、主语言（zh）、主语言相同的其他语言（按名称排序）
+func lookupMessage(lang string, code int) (tpl string, ok bool) {
+	messagesMu.RLock()
+	defer messagesMu.RUnlock()
+	if tpl, ok = messages[lang][code]; ok {
+		return
+	}
+	base := strings.SplitN(lang, "-", 2)[0]
+	if tpl, ok = messages[base][code]; ok {
+		return
+	}
+	var langs []string
+	for l := range messages {
+		if strings.SplitN(l, "-", 2)[0] == base {
+			langs = append(langs, l)
+		}
+	}
+	sort.Strings(langs)
+	for _, l := range langs {
+		if tpl, ok = messages[l][code]; ok {
+			return
+		}
+	}
+	return
+}
+
+// 错误是否是注册的错误（或者由它 Wrap/With 派生），其他错误码相同的错误不使用模板
+func isRegistered(e CustomError) bool {
+	registered, ok := Lookup(e.GetCode())
+	if !ok {
+		return false
+	}
+	return rawMessage(registered) == rawMessage(e)
+}
+
+// 未替换参数的错误信息
+func rawMessage(e CustomError) string {
+	var ce *customErr
+	if errors.As(e, &ce) && ce.Code == e.GetCode() {
+		return ce.Message
+	}
+	return e.GetMessage()
+}
+
+// Localize 错误信息翻译为lang对应的语言，没有模板或者不是注册的错误时使用错误自身的信息
+// 模板中的 {name} 替换为 WithParams 指定的参数，没有指定的参数替换为空
+func Localize(e CustomError, lang string) string {
+	tpl, ok := "", false
+	if isRegistered(e) {
+		tpl, ok = lookupMessage(lang, e.GetCode())
+	}
+	if !ok {
+		tpl = e.GetMessage()
+	}
+	return Interpolate(tpl, paramsOf(e))
+}
+
+// Interpolate 替换模板中的 {name} 参数
+func Interpolate(tpl string, params map[string]interface{}) string {
+	if !strings.Contains(tpl, "{") {
+		return tpl
+	}
+	return paramReg.ReplaceAllStringFunc(tpl, func(s string) string {
+		name := strings.Trim(s, " []{}")
+		if v, ok := params[name]; ok {
+			return strings.Replace(s, "{"+name+"}", fmt.Sprint(v), 1)
+		}
+		return ""
+	})
+}
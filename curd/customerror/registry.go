@@ -0,0 +1,61 @@
+package customerror
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 错误码注册表
+var (
+	registry   = make(map[int]CustomError)
+	registryMu sync.RWMutex
+)
+
+// Define 定义并注册一个错误，错误码重复时panic
+func Define(code, status int, message string) ExtendedError {
+	e := New(code, message).WithStatus(status)
+	Register(e)
+	return e
+}
+
+// Register 注册错误，错误码重复时panic
+func Register(e CustomError) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if exist, ok := registry[e.GetCode()]; ok {
+		panic(fmt.Sprintf("customerror: code %d registered twice, [%s] and [%s]",
+			e.GetCode(), exist.GetMessage(), e.GetMessage()))
+	}
+	registry[e.GetCode()] = e
+}
+
+// Lookup 根据错误码查找已注册的错误
+func Lookup(code int) (e CustomError, ok bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	e, ok = registry[code]
+	return
+}
+
+// CatalogEntry 错误目录中的一项
+type CatalogEntry struct {
+	Code    int    `json:"code"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Catalog 已注册的错误列表，按错误码排序，lang不为空时翻译错误信息
+func Catalog(lang ...string) (l []CatalogEntry) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, e := range registry {
+		msg := e.GetMessage()
+		if len(lang) > 0 && lang[0] != "" {
+			msg = Localize(e, lang[0])
+		}
+		l = append(l, CatalogEntry{Code: e.GetCode(), Status: StatusOf(e), Message: msg})
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Code < l[j].Code })
+	return
+}
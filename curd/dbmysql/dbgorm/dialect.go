@@ -0,0 +1,53 @@
+package dbgorm
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 数据库方言（gorm Dialector 名称）
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// Dialect 链接使用的数据库方言
+func Dialect(db *gorm.DB) string {
+	if db == nil || db.Dialector == nil {
+		return ""
+	}
+	return db.Dialector.Name()
+}
+
+// FindInSet 逗号分隔的字段中包含value，替代mysql的 FIND_IN_SET(value, column)
+// sql, arg := dbgorm.FindInSet(db, "tags", "a"); db.Where(sql, arg)
+func FindInSet(db *gorm.DB, column, value string) (sql string, arg interface{}) {
+	switch Dialect(db) {
+	case DialectMySQL:
+		return fmt.Sprintf("FIND_IN_SET(?,%s)", column), value
+	case DialectPostgres:
+		return fmt.Sprintf("? = ANY(string_to_array(%s, ','))", column), value
+	default:
+		return fmt.Sprintf("(',' || %s || ',') LIKE ? ESCAPE '!'", column), "%," + LikeEscape(value) + ",%"
+	}
+}
+
+// Like 不区分大小写的模糊匹配，postgres 使用 ILIKE，参数中的通配符需要用 LikeEscape 转义
+// db.Where(dbgorm.Like(db, "title"), "%"+dbgorm.LikeEscape(v)+"%")
+func Like(db *gorm.DB, column string) string {
+	if Dialect(db) == DialectPostgres {
+		return fmt.Sprintf("%s ILIKE ? ESCAPE '!'", column)
+	}
+	return fmt.Sprintf("%s LIKE ? ESCAPE '!'", column)
+}
+
+// 转义字符使用!，避免反斜杠在不同数据库（mysql NO_BACKSLASH_ESCAPES）中的差异
+var likeReplacer = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// LikeEscape 转义 LIKE 参数中的 % 和 _，按字面值匹配
+func LikeEscape(v string) string {
+	return likeReplacer.Replace(v)
+}
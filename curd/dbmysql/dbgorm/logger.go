@@ -0,0 +1,81 @@
+package dbgorm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"gorm.io/gorm/logger"
+)
+
+// 默认慢查询阈值
+const DefaultSlowThreshold = time.Second
+
+// SQL日志级别
+var logLevels = map[string]logger.LogLevel{
+	"silent": logger.Silent,
+	"error":  logger.Error,
+	"warn":   logger.Warn,
+	"info":   logger.Info,
+}
+
+// 按级别创建写入 beego 日志的 gorm logger，级别为空时为 info
+// 慢查询由 metrics 回调以 SlowQuery 事件记录，这里不再重复打印
+func newLogger(level string) (logger.Interface, error) {
+	lv := logger.Info
+	if level != "" {
+		var ok bool
+		if lv, ok = logLevels[strings.ToLower(level)]; !ok {
+			return nil, fmt.Errorf("invalid db log level %s", level)
+		}
+	}
+	return logger.New(
+		logs.GetLogger(), // io writer（日志输出的目标，前缀和日志包含的内容——译者注）
+		logger.Config{
+			LogLevel:                  lv,    // 日志级别
+			IgnoreRecordNotFoundError: true,  // 忽略ErrRecordNotFound（记录未找到）错误
+			Colorful:                  false, // 禁用彩色打印
+		},
+	), nil
+}
+
+// SlowQuery 慢查询事件
+type SlowQuery struct {
+	DB        string        `json:"db"`
+	Table     string        `json:"table"`
+	Operation string        `json:"operation"` // create query update delete row raw
+	SQL       string        `json:"sql"`
+	Rows      int64         `json:"rows"` // 影响（返回）的行数
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	Caller    string        `json:"caller"` // 调用gorm的代码位置
+	Error     string        `json:"error,omitempty"`
+	Time      time.Time     `json:"time"`
+}
+
+var (
+	slowMu    sync.RWMutex
+	slowHooks []func(SlowQuery)
+)
+
+// OnSlowQuery 注册慢查询回调（例如上报告警），回调在执行SQL的goroutine中同步调用
+func OnSlowQuery(fn func(SlowQuery)) {
+	slowMu.Lock()
+	defer slowMu.Unlock()
+	slowHooks = append(slowHooks, fn)
+}
+
+// 记录慢查询：beego 日志输出一行JSON，并通知回调
+func reportSlowQuery(q SlowQuery) {
+	if data, err := json.Marshal(q); err == nil {
+		logs.Warn("slow query %s", data)
+	}
+	slowMu.RLock()
+	hooks := slowHooks
+	slowMu.RUnlock()
+	for _, fn := range hooks {
+		fn(q)
+	}
+}
@@ -0,0 +1,388 @@
+package dbgorm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/aes/secrets"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	_ "modernc.org/sqlite"
+)
+
+// ErrUnknownDriver 不支持的数据库驱动
+var ErrUnknownDriver = errors.New("unknown db driver")
+
+// Options 数据库链接参数
+type Options struct {
+	Name            string        // 链接名称，metrics 和慢查询日志使用，Get 时为配置节名称
+	Driver          string        // mysql postgres sqlite3
+	DSN             string        // 数据源，mysql的密码已替换
+	MaxOpenConns    int           // 最大连接数，0 不限制
+	MaxIdleConns    int           // 最大空闲连接数，0 使用默认值
+	ConnMaxLifetime time.Duration // 连接最长使用时间，0 不限制
+	ConnMaxIdleTime time.Duration // 连接最长空闲时间，0 不限制
+	Config          *gorm.Config  // gorm 配置，为空时使用默认配置
+	Replicas        []string      // 只读副本的数据源，和主库使用同一个驱动
+	LogLevel        string        // SQL日志级别 silent error warn info，默认 info（Config.Logger 为空时生效）
+	SlowThreshold   time.Duration // 慢查询阈值，默认1秒，小于0不记录慢查询
+}
+
+// OptionsFromConf 从配置节读取链接参数
+// [DB]
+// Driver = mysql
+// SourceName = user:%s@tcp(host:3306)/db?charset=utf8mb4&parseTime=True&loc=Local
+// （postgres：host=localhost user=user password=%s dbname=db port=5432 sslmode=disable TimeZone=Asia/Shanghai）
+// Passwd = 密码（可加密，见 secrets.Resolve）
+// MaxOpenConns = 100
+// MaxIdleConns = 10
+// ConnMaxLifetime = 1h
+// ConnMaxIdleTime = 10m
+// Replicas = 副本数据源，多个用分号分隔，密码同 Passwd
+// LogLevel = warn
+// SlowThreshold = 500ms
+func OptionsFromConf(section string) (opts Options, err error) {
+	opts.Driver = beego.AppConfig.String(section + "::Driver")
+	opts.DSN = beego.AppConfig.String(section + "::SourceName")
+	var passwd string
+	switch opts.Driver {
+	case "mysql", "postgres":
+		// 密码是加密形态时自动解密
+		if passwd, err = secrets.Resolve(section, "Passwd"); err != nil {
+			logs.Error("Decrypt db passwd failed,", err.Error())
+			return
+		}
+		opts.DSN = fmt.Sprintf(opts.DSN, passwd)
+	case "sqlite3":
+	case "":
+		return opts, fmt.Errorf("db section[%s] not configured", section)
+	default:
+		return opts, fmt.Errorf("%w: %s", ErrUnknownDriver, opts.Driver)
+	}
+	for _, dsn := range beego.AppConfig.Strings(section + "::Replicas") {
+		if dsn = strings.TrimSpace(dsn); dsn == "" {
+			continue
+		}
+		if passwd != "" {
+			dsn = fmt.Sprintf(dsn, passwd)
+		}
+		opts.Replicas = append(opts.Replicas, dsn)
+	}
+	opts.MaxOpenConns = beego.AppConfig.DefaultInt(section+"::MaxOpenConns", 0)
+	opts.MaxIdleConns = beego.AppConfig.DefaultInt(section+"::MaxIdleConns", 0)
+	if opts.ConnMaxLifetime, err = confDuration(section + "::ConnMaxLifetime"); err != nil {
+		return
+	}
+	if opts.ConnMaxIdleTime, err = confDuration(section + "::ConnMaxIdleTime"); err != nil {
+		return
+	}
+	opts.LogLevel = beego.AppConfig.String(section + "::LogLevel")
+	if opts.SlowThreshold, err = confDuration(section + "::SlowThreshold"); err != nil {
+		return
+	}
+	return opts, nil
+}
+
+// 时长配置，支持 1h30m 或者秒数
+func confDuration(key string) (d time.Duration, err error) {
+	v := beego.AppConfig.String(key)
+	if v == "" {
+		return 0, nil
+	}
+	if sec, perr := strconv.Atoi(v); perr == nil {
+		return time.Duration(sec) * time.Second, nil
+	}
+	if d, err = time.ParseDuration(v); err != nil {
+		err = fmt.Errorf("invalid duration %s=%s", key, v)
+	}
+	return
+}
+
+// 驱动对应的 gorm Dialector
+func dialectorOf(driver, dsn string) (dialector gorm.Dialector, err error) {
+	switch driver {
+	case "mysql":
+		dialector = mysql.Open(dsn)
+	case "postgres":
+		dialector = postgres.Open(dsn)
+	case "sqlite3":
+		dialector = sqlite.Dialector{DSN: dsn, DriverName: "sqlite"}
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnknownDriver, driver)
+	}
+	return
+}
+
+// Open 按参数打开一个新的连接池，配置了副本时启用读写分离（见 ReadReplica）
+func Open(opts Options) (db *gorm.DB, err error) {
+	var dialector gorm.Dialector
+	if dialector, err = dialectorOf(opts.Driver, opts.DSN); err != nil {
+		return
+	}
+	config := opts.Config
+	if config == nil {
+		config = &gorm.Config{}
+	}
+	if config.Logger == nil {
+		if config.Logger, err = newLogger(opts.LogLevel); err != nil {
+			return
+		}
+	}
+	if db, err = gorm.Open(dialector, config); err != nil {
+		logs.Error("open db failed,", err.Error())
+		return
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
+	if err = useMetrics(db, opts); err != nil {
+		logs.Error("register db metrics failed,", err.Error())
+		sqlDB.Close()
+		return nil, err
+	}
+	if len(opts.Replicas) > 0 {
+		if err = useReplicas(db, opts); err != nil {
+			logs.Error("register db replicas failed,", err.Error())
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// 已命名的数据库链接
+var (
+	mu      sync.RWMutex
+	conns   = make(map[string]*gorm.DB)
+	options = make(map[string]Options)
+	opening = make(map[string]*openCall)   // 正在打开的链接，同名的 Get 等待同一次打开
+	failed  = make(map[string]openFailure) // 打开失败的链接，OpenRetryInterval 内直接返回错误
+)
+
+// OpenRetryInterval 打开失败后多久内不重试，直接返回上次的错误
+var OpenRetryInterval = 5 * time.Second
+
+// 打开链接（测试时替换）
+var openDB = Open
+
+type openCall struct {
+	done chan struct{}
+	db   *gorm.DB
+	err  error
+}
+
+type openFailure struct {
+	err error
+	at  time.Time
+}
+
+// Register 注册命名链接的参数（不使用配置文件时），首次 Get 时打开
+func Register(name string, opts Options) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := conns[name]; ok {
+		return fmt.Errorf("db[%s] already opened", name)
+	}
+	options[name] = opts
+	delete(failed, name)
+	return nil
+}
+
+// Get 获取命名链接，没有注册参数时从同名配置节读取，多个goroutine共用一个连接池
+// 打开链接（网络连接）时不持有锁，同名链接同时只打开一次
+func Get(name string) (db *gorm.DB, err error) {
+	mu.RLock()
+	db, ok := conns[name]
+	mu.RUnlock()
+	if ok {
+		return db, nil
+	}
+	mu.Lock()
+	if db, ok = conns[name]; ok {
+		mu.Unlock()
+		return db, nil
+	}
+	if f, ok := failed[name]; ok && time.Since(f.at) < OpenRetryInterval {
+		mu.Unlock()
+		return nil, f.err
+	}
+	call, ok := opening[name]
+	if ok {
+		mu.Unlock()
+		<-call.done
+		return call.db, call.err
+	}
+	call = &openCall{done: make(chan struct{})}
+	opening[name] = call
+	opts, registered := options[name]
+	mu.Unlock()
+
+	call.db, call.err = openNamed(name, opts, registered)
+	mu.Lock()
+	delete(opening, name)
+	if call.err != nil {
+		failed[name] = openFailure{err: call.err, at: time.Now()}
+	} else if exist, ok := conns[name]; ok {
+		// 打开期间 SetDB 指定了链接，使用已有的链接
+		closeDB(call.db)
+		call.db = exist
+	} else {
+		delete(failed, name)
+		conns[name] = call.db
+	}
+	mu.Unlock()
+	close(call.done)
+	return call.db, call.err
+}
+
+// 按注册的参数或者配置节打开链接
+func openNamed(name string, opts Options, registered bool) (db *gorm.DB, err error) {
+	if !registered {
+		if opts, err = OptionsFromConf(name); err != nil {
+			logs.Error("load db[%s] options failed, %s", name, err.Error())
+			return
+		}
+	}
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	return openDB(opts)
+}
+
+// 关闭连接池
+func closeDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// GetContext 获取命名链接，并绑定context（超时、取消）
+func GetContext(ctx context.Context, name string) (db *gorm.DB, err error) {
+	if db, err = Get(name); err != nil {
+		return
+	}
+	return db.WithContext(ctx), nil
+}
+
+// SetDB 指定命名链接（例如测试时使用内存数据库），替换已有的链接但不关闭
+func SetDB(name string, db *gorm.DB) {
+	mu.Lock()
+	defer mu.Unlock()
+	conns[name] = db
+	delete(failed, name)
+}
+
+// Names 已打开的链接名称
+func Names() (names []string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for name := range conns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Ping 检查已打开的链接，返回每个链接的错误（nil 表示正常）
+func Ping(ctx context.Context) map[string]error {
+	mu.RLock()
+	dbs := make(map[string]*gorm.DB, len(conns))
+	for name, db := range conns {
+		dbs[name] = db
+	}
+	mu.RUnlock()
+	ret := make(map[string]error, len(dbs))
+	for name, db := range dbs {
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.PingContext(ctx)
+		}
+		if err != nil {
+			logs.Error("ping db[%s] failed, %s", name, err.Error())
+		}
+		ret[name] = err
+	}
+	return ret
+}
+
+// ReadinessTimeout 就绪检查 ping 的超时时间，为0时只使用请求的context
+var ReadinessTimeout = 3 * time.Second
+
+// ReadinessHandler 数据库就绪检查，全部链接正常返回200，否则返回503
+// beego.Handler("/ready", dbgorm.ReadinessHandler())
+func ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if ReadinessTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, ReadinessTimeout)
+			defer cancel()
+		}
+		status := http.StatusOK
+		ret := map[string]string{}
+		for name, err := range Ping(ctx) {
+			if err != nil {
+				status = http.StatusServiceUnavailable
+				ret[name] = err.Error()
+			} else {
+				ret[name] = "ok"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ret)
+	}
+}
+
+// Close 关闭并移除命名链接
+func Close(name string) error {
+	mu.Lock()
+	db, ok := conns[name]
+	delete(conns, name)
+	delete(failed, name)
+	mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return closeDB(db)
+}
+
+// CloseAll 关闭全部链接，返回遇到的第一个错误
+func CloseAll() (err error) {
+	for _, name := range Names() {
+		if cerr := Close(name); cerr != nil {
+			logs.Error("close db[%s] failed, %s", name, cerr.Error())
+			if err == nil {
+				err = cerr
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,156 @@
+package dbgorm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testOptions(t *testing.T, name string) Options {
+	return Options{Driver: "sqlite3", DSN: filepath.Join(t.TempDir(), name+".db"),
+		Config: &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}}
+}
+
+func Test_RegisterGetClose(t *testing.T) {
+	if err := Register("mgr_a", testOptions(t, "a")); err != nil {
+		t.Fatal(err)
+	}
+	defer Close("mgr_a")
+	db, err := Get("mgr_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := Get("mgr_a"); again != db {
+		t.Error("Get should return the same pool")
+	}
+	if err = Register("mgr_a", testOptions(t, "a2")); err == nil {
+		t.Error("register an opened db should fail")
+	}
+	found := false
+	for _, name := range Names() {
+		found = found || name == "mgr_a"
+	}
+	if !found {
+		t.Errorf("mgr_a not in %v", Names())
+	}
+	if err = Close("mgr_a"); err != nil {
+		t.Fatal(err)
+	}
+	if sqlDB, _ := db.DB(); sqlDB.Ping() == nil {
+		t.Error("closed pool should not ping")
+	}
+	if err = Close("mgr_a"); err != nil {
+		t.Error("close twice should be ignored")
+	}
+	// 关闭后重新打开
+	if again, err := Get("mgr_a"); err != nil || again == db {
+		t.Errorf("expect a new pool, got %v", err)
+	}
+}
+
+func Test_GetOpenOnce(t *testing.T) {
+	defer func(open func(Options) (*gorm.DB, error)) { openDB = open }(openDB)
+	var calls int32
+	openDB = func(opts Options) (*gorm.DB, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return Open(opts)
+	}
+	Register("mgr_once", testOptions(t, "once"))
+	defer Close("mgr_once")
+	// 打开期间其他链接不受影响
+	SetDB("mgr_other", &gorm.DB{})
+	defer func() {
+		mu.Lock()
+		delete(conns, "mgr_other")
+		mu.Unlock()
+	}()
+	var wg sync.WaitGroup
+	dbs := make([]*gorm.DB, 10)
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dbs[i], _ = Get("mgr_once")
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	if _, err := Get("mgr_other"); err != nil || time.Since(start) > 20*time.Millisecond {
+		t.Errorf("get other db blocked by opening, %v", err)
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expect open once, got %d", calls)
+	}
+	for _, db := range dbs {
+		if db == nil || db != dbs[0] {
+			t.Fatal("expect the same pool")
+		}
+	}
+}
+
+func Test_GetFailure(t *testing.T) {
+	defer func(open func(Options) (*gorm.DB, error)) { openDB = open }(openDB)
+	defer func(d time.Duration) { OpenRetryInterval = d }(OpenRetryInterval)
+	var calls int32
+	openErr := errors.New("connect refused")
+	openDB = func(opts Options) (*gorm.DB, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, openErr
+	}
+	OpenRetryInterval = time.Hour
+	Register("mgr_fail", testOptions(t, "fail"))
+	for i := 0; i < 3; i++ {
+		if _, err := Get("mgr_fail"); err != openErr {
+			t.Fatalf("expect open error, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("failure should be cached, opened %d times", calls)
+	}
+	// 重新注册参数后立即重试
+	openDB = Open
+	Register("mgr_fail", testOptions(t, "fail"))
+	defer Close("mgr_fail")
+	if _, err := Get("mgr_fail"); err != nil {
+		t.Errorf("expect open after register, got %v", err)
+	}
+}
+
+func Test_ReadinessHandler(t *testing.T) {
+	// sqlite 驱动在 ping 返回后仍可能因 context 取消而中断链接，和下面关闭链接竞争
+	// 测试请求的 context 不会取消，不设置超时时 ping 结束后驱动不再访问链接
+	defer func(d time.Duration) { ReadinessTimeout = d }(ReadinessTimeout)
+	ReadinessTimeout = 0
+	Register("mgr_ready", testOptions(t, "ready"))
+	defer Close("mgr_ready")
+	db, err := Get("mgr_ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func() (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		ReadinessHandler()(rec, httptest.NewRequest("GET", "/ready", nil))
+		ret := map[string]string{}
+		json.NewDecoder(rec.Body).Decode(&ret)
+		return rec.Code, ret
+	}
+	if code, ret := check(); code != http.StatusOK || ret["mgr_ready"] != "ok" {
+		t.Errorf("expect ready, got %d %v", code, ret)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	if code, ret := check(); code != http.StatusServiceUnavailable || ret["mgr_ready"] == "ok" {
+		t.Errorf("expect unavailable, got %d %v", code, ret)
+	}
+}
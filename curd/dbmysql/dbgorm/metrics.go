@@ -0,0 +1,168 @@
+package dbgorm
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils"
+)
+
+// SQL 执行的 metrics，标签 db 为链接名称（Options.Name）
+var (
+	// QueryDuration SQL 耗时
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "dbgorm",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of SQL statements by table and operation.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"db", "table", "operation"})
+
+	// QueryErrors SQL 执行失败次数（不含记录未找到）
+	QueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dbgorm",
+		Name:      "query_errors_total",
+		Help:      "Number of failed SQL statements by table and operation.",
+	}, []string{"db", "table", "operation"})
+)
+
+func init() {
+	prometheus.MustRegister(QueryDuration, QueryErrors, poolCollector{})
+}
+
+// MetricsHandler prometheus 采集接口
+// beego.Handler("/metrics", dbgorm.MetricsHandler())
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+const startKey = "dbgorm:start"
+
+// 注册统计耗时、错误和慢查询的回调
+func useMetrics(db *gorm.DB, opts Options) (err error) {
+	slow := opts.SlowThreshold
+	if slow == 0 {
+		slow = DefaultSlowThreshold
+	}
+	before := func(db *gorm.DB) {
+		db.InstanceSet(startKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(db *gorm.DB) {
+			v, ok := db.InstanceGet(startKey)
+			if !ok {
+				return
+			}
+			elapsed := time.Since(v.(time.Time))
+			table := db.Statement.Table
+			QueryDuration.WithLabelValues(opts.Name, table, operation).Observe(elapsed.Seconds())
+			failed := db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)
+			if failed {
+				QueryErrors.WithLabelValues(opts.Name, table, operation).Inc()
+			}
+			if slow > 0 && elapsed >= slow {
+				q := SlowQuery{
+					DB:        opts.Name,
+					Table:     table,
+					Operation: operation,
+					SQL:       db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...),
+					Rows:      db.RowsAffected,
+					Elapsed:   elapsed,
+					Caller:    utils.FileWithLineNum(),
+					Time:      time.Now(),
+				}
+				if failed {
+					q.Error = db.Error.Error()
+				}
+				reportSlowQuery(q)
+			}
+		}
+	}
+	cb := db.Callback()
+	if err = cb.Create().Before("gorm:create").Register("dbgorm:metrics_before", before); err != nil {
+		return
+	}
+	if err = cb.Create().After("gorm:create").Register("dbgorm:metrics_after", after("create")); err != nil {
+		return
+	}
+	if err = cb.Query().Before("gorm:query").Register("dbgorm:metrics_before", before); err != nil {
+		return
+	}
+	if err = cb.Query().After("gorm:query").Register("dbgorm:metrics_after", after("query")); err != nil {
+		return
+	}
+	if err = cb.Update().Before("gorm:update").Register("dbgorm:metrics_before", before); err != nil {
+		return
+	}
+	if err = cb.Update().After("gorm:update").Register("dbgorm:metrics_after", after("update")); err != nil {
+		return
+	}
+	if err = cb.Delete().Before("gorm:delete").Register("dbgorm:metrics_before", before); err != nil {
+		return
+	}
+	if err = cb.Delete().After("gorm:delete").Register("dbgorm:metrics_after", after("delete")); err != nil {
+		return
+	}
+	if err = cb.Row().Before("gorm:row").Register("dbgorm:metrics_before", before); err != nil {
+		return
+	}
+	if err = cb.Row().After("gorm:row").Register("dbgorm:metrics_after", after("row")); err != nil {
+		return
+	}
+	if err = cb.Raw().Before("gorm:raw").Register("dbgorm:metrics_before", before); err != nil {
+		return
+	}
+	return cb.Raw().After("gorm:raw").Register("dbgorm:metrics_after", after("raw"))
+}
+
+// 连接池状态，采集时读取已打开的命名链接
+type poolCollector struct{}
+
+var (
+	poolOpenDesc = prometheus.NewDesc("dbgorm_pool_open_connections",
+		"Number of established connections.", []string{"db"}, nil)
+	poolInUseDesc = prometheus.NewDesc("dbgorm_pool_in_use_connections",
+		"Number of connections currently in use.", []string{"db"}, nil)
+	poolIdleDesc = prometheus.NewDesc("dbgorm_pool_idle_connections",
+		"Number of idle connections.", []string{"db"}, nil)
+	poolMaxOpenDesc = prometheus.NewDesc("dbgorm_pool_max_open_connections",
+		"Maximum number of open connections, 0 means unlimited.", []string{"db"}, nil)
+	poolWaitCountDesc = prometheus.NewDesc("dbgorm_pool_wait_count_total",
+		"Total number of connections waited for.", []string{"db"}, nil)
+	poolWaitDurationDesc = prometheus.NewDesc("dbgorm_pool_wait_duration_seconds_total",
+		"Total time blocked waiting for a new connection.", []string{"db"}, nil)
+)
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolOpenDesc
+	ch <- poolInUseDesc
+	ch <- poolIdleDesc
+	ch <- poolMaxOpenDesc
+	ch <- poolWaitCountDesc
+	ch <- poolWaitDurationDesc
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	mu.Lock()
+	dbs := make(map[string]*gorm.DB, len(conns))
+	for name, db := range conns {
+		dbs[name] = db
+	}
+	mu.Unlock()
+	for name, db := range dbs {
+		sqlDB, err := db.DB()
+		if err != nil {
+			continue
+		}
+		s := sqlDB.Stats()
+		ch <- prometheus.MustNewConstMetric(poolOpenDesc, prometheus.GaugeValue, float64(s.OpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(poolInUseDesc, prometheus.GaugeValue, float64(s.InUse), name)
+		ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(s.Idle), name)
+		ch <- prometheus.MustNewConstMetric(poolMaxOpenDesc, prometheus.GaugeValue, float64(s.MaxOpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(poolWaitCountDesc, prometheus.CounterValue, float64(s.WaitCount), name)
+		ch <- prometheus.MustNewConstMetric(poolWaitDurationDesc, prometheus.CounterValue, s.WaitDuration.Seconds(), name)
+	}
+}
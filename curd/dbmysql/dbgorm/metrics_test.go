@@ -0,0 +1,43 @@
+package dbgorm
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func Test_SlowQuery(t *testing.T) {
+	var events []SlowQuery
+	OnSlowQuery(func(q SlowQuery) {
+		if q.DB == "metrics" {
+			events = append(events, q)
+		}
+	})
+	db, err := Open(Options{Name: "metrics", Driver: "sqlite3", DSN: "file:metrics?mode=memory",
+		SlowThreshold: 1, Config: &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+	if err = db.AutoMigrate(&routeItem{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&routeItem{Name: "a"})
+	var items []routeItem
+	db.Where("name = ?", "a").Find(&items)
+	if len(events) == 0 {
+		t.Fatal("no slow query reported")
+	}
+	last := events[len(events)-1]
+	if last.Table != "route_items" || last.Operation != "query" || last.Rows != 1 || last.Caller == "" {
+		t.Errorf("unexpected slow query %+v", last)
+	}
+	if n := testutil.CollectAndCount(QueryDuration); n == 0 {
+		t.Error("query duration not observed")
+	}
+}
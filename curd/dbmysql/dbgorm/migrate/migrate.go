@@ -0,0 +1,197 @@
+// Package migrate 版本化的数据库迁移
+//
+// 各个包在 init 中注册自己的迁移（Go 函数或者 SQL），版本号全局唯一且按从小到大执行，
+// 已执行的版本记录在 schema_migrations 表中；执行前获取 schema_migrations_lock 表锁，
+// 多个实例同时启动时只有一个执行迁移
+//
+//	func init() {
+//		migrate.Register(migrate.Model(2022010100, "create op_log", &OpLog{}))
+//	}
+//	migrate.New(db).Up(ctx, 0)
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Migration 一个版本的迁移，Up/Down 与 UpSQL/DownSQL 二选一
+type Migration struct {
+	Version int64                // 版本号，全局唯一，建议使用日期+序号，如 2022010100
+	Name    string               // 描述
+	Up      func(*gorm.DB) error // 升级
+	Down    func(*gorm.DB) error // 回滚，为空时不能回滚
+	UpSQL   string               // 升级SQL，多条语句用分号换行分隔
+	DownSQL string               // 回滚SQL
+}
+
+func (m Migration) up(tx *gorm.DB) error {
+	if m.Up != nil {
+		return m.Up(tx)
+	}
+	return execSQL(tx, m.UpSQL)
+}
+
+func (m Migration) down(tx *gorm.DB) error {
+	if m.Down != nil {
+		return m.Down(tx)
+	}
+	if m.DownSQL == "" {
+		return fmt.Errorf("migration[%d %s] can not be rolled back", m.Version, m.Name)
+	}
+	return execSQL(tx, m.DownSQL)
+}
+
+// 逐条执行SQL（部分驱动不支持一次执行多条）
+func execSQL(tx *gorm.DB, sql string) error {
+	for _, stmt := range splitStatements(sql) {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按行尾的分号拆分语句
+func splitStatements(sql string) (stmts []string) {
+	var cur []string
+	for _, line := range strings.Split(sql, "\n") {
+		cur = append(cur, line)
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			stmts = appendStatement(stmts, cur)
+			cur = nil
+		}
+	}
+	return appendStatement(stmts, cur)
+}
+
+func appendStatement(stmts []string, lines []string) []string {
+	stmt := strings.TrimSuffix(strings.TrimSpace(strings.Join(lines, "\n")), ";")
+	if strings.TrimSpace(stmt) == "" {
+		return stmts
+	}
+	return append(stmts, stmt)
+}
+
+// 已注册的迁移
+var (
+	mu         sync.Mutex
+	registered = map[int64]Migration{}
+)
+
+// Register 注册迁移，版本号重复或者没有升级内容时 panic
+func Register(migrations ...Migration) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, m := range migrations {
+		if m.Up == nil && strings.TrimSpace(m.UpSQL) == "" {
+			panic(fmt.Sprintf("migration[%d %s] has no up", m.Version, m.Name))
+		}
+		if old, ok := registered[m.Version]; ok {
+			panic(fmt.Sprintf("migration version %d registered twice: %s, %s", m.Version, old.Name, m.Name))
+		}
+		registered[m.Version] = m
+	}
+}
+
+// Registered 已注册的迁移，按版本排序
+func Registered() []Migration {
+	mu.Lock()
+	defer mu.Unlock()
+	ms := make([]Migration, 0, len(registered))
+	for _, m := range registered {
+		ms = append(ms, m)
+	}
+	return sorted(ms)
+}
+
+func sorted(ms []Migration) []Migration {
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms
+}
+
+// Model 建表迁移：升级 AutoMigrate，回滚删除表；table 为空时使用模型的表名
+func Model(version int64, name string, model interface{}, table ...string) Migration {
+	scope := func(tx *gorm.DB) *gorm.DB {
+		if len(table) > 0 && table[0] != "" {
+			return tx.Table(table[0])
+		}
+		return tx
+	}
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(tx *gorm.DB) error {
+			return scope(tx).AutoMigrate(model)
+		},
+		Down: func(tx *gorm.DB) error {
+			if len(table) > 0 && table[0] != "" {
+				return tx.Migrator().DropTable(table[0])
+			}
+			return tx.Migrator().DropTable(model)
+		},
+	}
+}
+
+// LoadSQL 从目录加载SQL迁移，文件名格式 <版本>_<描述>.up.sql / <版本>_<描述>.down.sql
+//
+//	//go:embed migrations/*.sql
+//	var migrations embed.FS
+//	migrations, err := migrate.LoadSQL(migrations, "migrations")
+//	migrate.Register(migrations...)
+func LoadSQL(fsys fs.FS, dir string) (migrations []Migration, err error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return
+	}
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		file := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		var version int64
+		if version, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", file)
+		}
+		var data []byte
+		if data, err = fs.ReadFile(fsys, path.Join(dir, file)); err != nil {
+			return
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			if len(parts) == 2 {
+				m.Name = strings.ReplaceAll(parts[1], "_", " ")
+			}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.UpSQL = string(data)
+		} else {
+			m.DownSQL = string(data)
+		}
+	}
+	for _, m := range byVersion {
+		if m.UpSQL == "" {
+			return nil, fmt.Errorf("migration[%d] has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	return sorted(migrations), nil
+}
@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+func Test_UpDownStatus(t *testing.T) {
+	db := testdb.Open(t)
+	sqls, err := LoadSQL(fstest.MapFS{
+		"sql/2_add_color.up.sql":   {Data: []byte("ALTER TABLE widgets ADD COLUMN color varchar(20);\nUPDATE widgets SET color = 'red';")},
+		"sql/2_add_color.down.sql": {Data: []byte("ALTER TABLE widgets DROP COLUMN color;")},
+	}, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	m := New(db, append(sqls, Model(1, "create widgets", &widget{}))...)
+	done, err := m.Up(ctx, 1)
+	if err != nil || len(done) != 1 {
+		t.Fatalf("up to 1: %v %v", done, err)
+	}
+	if done, err = m.Up(ctx, 0); err != nil || len(done) != 1 || done[0].Name != "add color" {
+		t.Fatalf("up: %v %v", done, err)
+	}
+	if !db.Migrator().HasColumn(&widget{}, "color") {
+		t.Error("column color not added")
+	}
+	status, err := m.Status(ctx)
+	if err != nil || len(status) != 2 || !status[0].Applied || !status[1].Applied {
+		t.Fatalf("status: %+v %v", status, err)
+	}
+	if done, err = m.Down(ctx, 2); err != nil || len(done) != 2 || done[0].Version != 2 {
+		t.Fatalf("down: %v %v", done, err)
+	}
+	if db.Migrator().HasTable(&widget{}) {
+		t.Error("table widgets not dropped")
+	}
+}
+
+func Test_Lock(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	a, b := New(db, Model(1, "create widgets", &widget{})), New(db, Model(1, "create widgets", &widget{}))
+	a.Owner, b.Owner = "a", "b"
+	b.Wait, b.Interval = 50*time.Millisecond, 10*time.Millisecond
+	if err := a.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Up(ctx, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expect ErrLocked, got %v", err)
+	}
+	// 失效的锁可以被抢占
+	b.Stale = 0
+	if _, err := b.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,232 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ErrLocked 等待迁移锁超时
+var ErrLocked = errors.New("migration lock is held by another instance")
+
+// SchemaMigration 已执行的迁移
+type SchemaMigration struct {
+	Version   int64     `gorm:"primary_key;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"size:255" json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// SchemaMigrationLock 迁移锁，只有一行
+type SchemaMigrationLock struct {
+	ID       int       `gorm:"primary_key;autoIncrement:false"`
+	Owner    string    `gorm:"size:255"`
+	LockedAt time.Time `gorm:"column:locked_at"`
+}
+
+func (SchemaMigrationLock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// Status 迁移状态
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Missing   bool       `json:"missing,omitempty"` // 已执行但是没有注册（代码中已删除）
+}
+
+// Migrator 在一个数据库上执行迁移
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	Owner      string        // 锁的持有者，默认 hostname:pid
+	Wait       time.Duration // 等待锁的时间，默认1分钟
+	Stale      time.Duration // 超过该时间的锁视为失效（持有者异常退出），默认10分钟
+	Interval   time.Duration // 重试获取锁的间隔，默认1秒
+}
+
+// New 使用已注册的迁移（migrations 为空时）或者指定的迁移
+func New(db *gorm.DB, migrations ...Migration) *Migrator {
+	if len(migrations) == 0 {
+		migrations = Registered()
+	} else {
+		migrations = sorted(append([]Migration(nil), migrations...))
+	}
+	host, _ := os.Hostname()
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		Owner:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+		Wait:       time.Minute,
+		Stale:      10 * time.Minute,
+		Interval:   time.Second,
+	}
+}
+
+// 创建迁移记录表和锁表
+func (m *Migrator) prepare(ctx context.Context) error {
+	return m.db.WithContext(ctx).AutoMigrate(&SchemaMigration{}, &SchemaMigrationLock{})
+}
+
+// Lock 获取迁移锁，等待超过 Wait 返回 ErrLocked
+func (m *Migrator) Lock(ctx context.Context) (err error) {
+	if err = m.prepare(ctx); err != nil {
+		return
+	}
+	deadline := time.Now().Add(m.Wait)
+	for {
+		db := m.db.WithContext(ctx)
+		// 主键冲突表示锁被占用，不打印冲突的错误日志
+		if err = db.Session(&gorm.Session{Logger: logger.Discard}).Create(&SchemaMigrationLock{ID: 1, Owner: m.Owner, LockedAt: time.Now()}).Error; err == nil {
+			return nil
+		}
+		var lock SchemaMigrationLock
+		if qerr := db.First(&lock, 1).Error; qerr == nil && time.Since(lock.LockedAt) > m.Stale {
+			logs.Warn("migration lock held by %s since %s is stale, released", lock.Owner, lock.LockedAt)
+			db.Where("id = ? AND owner = ?", 1, lock.Owner).Delete(&SchemaMigrationLock{})
+			continue
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.Interval):
+		}
+	}
+}
+
+// Unlock 释放迁移锁
+func (m *Migrator) Unlock(ctx context.Context) error {
+	return m.db.WithContext(ctx).Where("id = ? AND owner = ?", 1, m.Owner).Delete(&SchemaMigrationLock{}).Error
+}
+
+// 已执行的版本
+func (m *Migrator) applied(ctx context.Context) (records map[int64]SchemaMigration, err error) {
+	var list []SchemaMigration
+	if err = m.db.WithContext(ctx).Order("version").Find(&list).Error; err != nil {
+		return
+	}
+	records = make(map[int64]SchemaMigration, len(list))
+	for _, r := range list {
+		records[r.Version] = r
+	}
+	return records, nil
+}
+
+// 加锁执行
+func (m *Migrator) locked(ctx context.Context, fn func() error) (err error) {
+	if err = m.Lock(ctx); err != nil {
+		logs.Error("lock migrations failed,", err.Error())
+		return
+	}
+	defer func() {
+		if uerr := m.Unlock(context.Background()); uerr != nil {
+			logs.Error("unlock migrations failed,", uerr.Error())
+		}
+	}()
+	return fn()
+}
+
+// Up 执行未执行的迁移，target 不为0时只执行到该版本（含）
+func (m *Migrator) Up(ctx context.Context, target int64) (done []Migration, err error) {
+	err = m.locked(ctx, func() error {
+		records, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if target != 0 && mig.Version > target {
+				break
+			}
+			if _, ok := records[mig.Version]; ok {
+				continue
+			}
+			mig := mig
+			if err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := mig.up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now()}).Error
+			}); err != nil {
+				logs.Error("migration[%d %s] up failed, %s", mig.Version, mig.Name, err.Error())
+				return fmt.Errorf("migration[%d %s] up failed, %w", mig.Version, mig.Name, err)
+			}
+			logs.Info("migration[%d %s] applied", mig.Version, mig.Name)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return
+}
+
+// Down 回滚最近执行的 steps 个迁移
+func (m *Migrator) Down(ctx context.Context, steps int) (done []Migration, err error) {
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+	err = m.locked(ctx, func() error {
+		var list []SchemaMigration
+		if err := m.db.WithContext(ctx).Order("version desc").Limit(steps).Find(&list).Error; err != nil {
+			return err
+		}
+		for _, r := range list {
+			mig, ok := known[r.Version]
+			if !ok {
+				return fmt.Errorf("migration[%d %s] is not registered", r.Version, r.Name)
+			}
+			if err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				if err := mig.down(tx); err != nil {
+					return err
+				}
+				return tx.Delete(&SchemaMigration{}, r.Version).Error
+			}); err != nil {
+				logs.Error("migration[%d %s] down failed, %s", mig.Version, mig.Name, err.Error())
+				return fmt.Errorf("migration[%d %s] down failed, %w", mig.Version, mig.Name, err)
+			}
+			logs.Info("migration[%d %s] rolled back", mig.Version, mig.Name)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return
+}
+
+// Status 所有迁移的执行状态，按版本排序
+func (m *Migrator) Status(ctx context.Context) (status []Status, err error) {
+	if err = m.prepare(ctx); err != nil {
+		return
+	}
+	records, err := m.applied(ctx)
+	if err != nil {
+		return
+	}
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Name: mig.Name}
+		if r, ok := records[mig.Version]; ok {
+			s.Applied, s.AppliedAt = true, &r.AppliedAt
+			delete(records, mig.Version)
+		}
+		status = append(status, s)
+	}
+	for _, r := range records {
+		r := r
+		status = append(status, Status{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: &r.AppliedAt, Missing: true})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+)
+
+// Run 执行迁移命令，供应用的命令行（或 cmd/migrate）使用
+//
+//	up [-to version]
+//	down [-steps 1]
+//	status
+func Run(ctx context.Context, db *gorm.DB, args []string, out io.Writer) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command: up, down or status")
+	}
+	m := New(db)
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	wait := fs.Duration("wait", m.Wait, "等待迁移锁的时间")
+	switch args[0] {
+	case "up":
+		to := fs.Int64("to", 0, "只执行到该版本（含），0 表示全部")
+		if err = fs.Parse(args[1:]); err != nil {
+			return
+		}
+		m.Wait = *wait
+		var done []Migration
+		if done, err = m.Up(ctx, *to); err != nil {
+			return
+		}
+		for _, mig := range done {
+			fmt.Fprintf(out, "up   %d %s\n", mig.Version, mig.Name)
+		}
+		fmt.Fprintf(out, "%d migrations applied\n", len(done))
+	case "down":
+		steps := fs.Int("steps", 1, "回滚的个数")
+		if err = fs.Parse(args[1:]); err != nil {
+			return
+		}
+		m.Wait = *wait
+		var done []Migration
+		if done, err = m.Down(ctx, *steps); err != nil {
+			return
+		}
+		for _, mig := range done {
+			fmt.Fprintf(out, "down %d %s\n", mig.Version, mig.Name)
+		}
+		fmt.Fprintf(out, "%d migrations rolled back\n", len(done))
+	case "status":
+		if err = fs.Parse(args[1:]); err != nil {
+			return
+		}
+		var status []Status
+		if status, err = m.Status(ctx); err != nil {
+			return
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tNAME")
+		for _, s := range status {
+			state, at := "pending", ""
+			if s.Applied {
+				state, at = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Missing {
+				state = "missing"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, state, at, s.Name)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown command %s, expect up, down or status", args[0])
+	}
+	return nil
+}
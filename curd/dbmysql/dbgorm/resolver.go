@@ -0,0 +1,188 @@
+package dbgorm
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+// 读写分离：配置了副本（Options.Replicas）的链接，查询默认仍然走主库，
+// 只有用 ReadReplica 标记的只读查询（列表、历史等）才走副本；
+// 同一个请求（WithRouting 的 context）里写过数据后，ReadReplica 也返回主库，避免读到复制延迟前的旧数据
+
+// 路由目标和原因（metrics 的标签）
+const (
+	RoutePrimary = "primary"
+	RouteReplica = "replica"
+
+	routeReasonWrite   = "write"   // 写操作或者显式指定主库
+	routeReasonDefault = "default" // 未标记的查询
+	routeReasonRead    = "read"    // ReadReplica 标记的查询
+	routeReasonSticky  = "sticky"  // 请求内写过数据
+
+	replicaResolver = "dbgorm:replica" // 副本在 dbresolver 中的名称
+)
+
+// RouteTotal 读写分离的路由次数
+var RouteTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "dbgorm",
+	Name:      "route_total",
+	Help:      "Number of statements routed to primary or replica.",
+}, []string{"target", "reason"})
+
+func init() {
+	prometheus.MustRegister(RouteTotal)
+}
+
+// 路由标记，只用于统计，不生成SQL
+type routeMark string
+
+func (m routeMark) Name() string                 { return "dbgorm:route:" + string(m) }
+func (m routeMark) Build(clause.Builder)         {}
+func (m routeMark) MergeClause(c *clause.Clause) { c.Expression = m }
+
+// 请求内的路由状态
+type routeState struct {
+	written int32
+}
+
+type routeStateKey struct{}
+
+// WithRouting 返回带路由状态的context，一个请求使用一个；
+// 之后用该context写过数据，后续的 ReadReplica 查询也走主库
+func WithRouting(ctx context.Context) context.Context {
+	if routeStateFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, routeStateKey{}, &routeState{})
+}
+
+// MarkWritten 标记请求已经写过数据（例如写操作没有使用该context时，按HTTP方法标记）
+func MarkWritten(ctx context.Context) {
+	if state := routeStateFrom(ctx); state != nil {
+		atomic.StoreInt32(&state.written, 1)
+	}
+}
+
+// Written 请求是否写过数据
+func Written(ctx context.Context) bool {
+	state := routeStateFrom(ctx)
+	return state != nil && atomic.LoadInt32(&state.written) == 1
+}
+
+func routeStateFrom(ctx context.Context) *routeState {
+	if ctx == nil {
+		return nil
+	}
+	state, _ := ctx.Value(routeStateKey{}).(*routeState)
+	return state
+}
+
+// ReadReplica 标记为只读查询，配置了副本时走副本；db 的context在请求内写过数据时仍走主库
+// dbgorm.ReadReplica(db.WithContext(ctx)).Find(&list)
+func ReadReplica(db *gorm.DB) *gorm.DB {
+	if Written(db.Statement.Context) {
+		return db.Clauses(dbresolver.Write, routeMark(routeReasonSticky))
+	}
+	return db.Clauses(dbresolver.Use(replicaResolver), dbresolver.Read, routeMark(routeReasonRead))
+}
+
+// Primary 强制走主库
+func Primary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write, routeMark(routeReasonWrite))
+}
+
+// 注册副本和路由回调
+// 副本注册为命名的resolver，没有全局resolver，未标记的语句使用原链接（主库）
+func useReplicas(db *gorm.DB, opts Options) (err error) {
+	var replicas []gorm.Dialector
+	for _, dsn := range opts.Replicas {
+		var dialector gorm.Dialector
+		if dialector, err = dialectorOf(opts.Driver, dsn); err != nil {
+			return
+		}
+		replicas = append(replicas, dialector)
+	}
+	resolver := dbresolver.Register(dbresolver.Config{Replicas: replicas}, replicaResolver)
+	if err = db.Use(resolver); err != nil {
+		return
+	}
+	// 连接池参数同样作用于副本
+	if opts.MaxOpenConns > 0 {
+		resolver.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		resolver.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		resolver.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	if opts.ConnMaxIdleTime > 0 {
+		resolver.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	}
+	const name = "dbgorm:route"
+	cb := db.Callback()
+	if err = cb.Query().Register(name, routeRead); err != nil {
+		return
+	}
+	if err = cb.Row().Register(name, routeRead); err != nil {
+		return
+	}
+	if err = cb.Raw().Register(name, routeRaw); err != nil {
+		return
+	}
+	if err = cb.Create().Register(name, routeWrite); err != nil {
+		return
+	}
+	if err = cb.Update().Register(name, routeWrite); err != nil {
+		return
+	}
+	return cb.Delete().Register(name, routeWrite)
+}
+
+// 事务内由事务的链接执行，不参与路由
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// 查询：统计路由结果
+func routeRead(db *gorm.DB) {
+	if inTransaction(db) {
+		return
+	}
+	reason := routeReasonDefault
+	for _, mark := range []routeMark{routeReasonRead, routeReasonSticky, routeReasonWrite} {
+		if _, ok := db.Statement.Clauses[mark.Name()]; ok {
+			reason = string(mark)
+			break
+		}
+	}
+	target := RoutePrimary
+	if reason == routeReasonRead {
+		target = RouteReplica
+	}
+	RouteTotal.WithLabelValues(target, reason).Inc()
+}
+
+// 原生SQL：select 同查询，其他按写操作处理
+func routeRaw(db *gorm.DB) {
+	if sql := strings.TrimSpace(db.Statement.SQL.String()); len(sql) >= 6 && strings.EqualFold(sql[:6], "select") {
+		routeRead(db)
+		return
+	}
+	routeWrite(db)
+}
+
+// 写操作：标记请求已写
+func routeWrite(db *gorm.DB) {
+	MarkWritten(db.Statement.Context)
+	if !inTransaction(db) {
+		RouteTotal.WithLabelValues(RoutePrimary, routeReasonWrite).Inc()
+	}
+}
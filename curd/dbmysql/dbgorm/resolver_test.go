@@ -0,0 +1,66 @@
+package dbgorm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type routeItem struct {
+	ID   uint
+	Name string
+}
+
+func Test_ReadReplica(t *testing.T) {
+	dir := t.TempDir()
+	primary, replica := filepath.Join(dir, "primary.db"), filepath.Join(dir, "replica.db")
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	// 副本单独写入不同的数据，用来区分查询走了哪个库
+	for dsn, name := range map[string]string{primary: "primary", replica: "replica"} {
+		db, err := Open(Options{Driver: "sqlite3", DSN: dsn, Config: &gorm.Config{Logger: config.Logger}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.AutoMigrate(&routeItem{}); err != nil {
+			t.Fatal(err)
+		}
+		db.Create(&routeItem{Name: name})
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}
+	db, err := Open(Options{Driver: "sqlite3", DSN: primary, Replicas: []string{replica}, Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+	name := func(db *gorm.DB) string {
+		var item routeItem
+		if err := db.First(&item).Error; err != nil {
+			t.Fatal(err)
+		}
+		return item.Name
+	}
+	if got := name(db); got != "primary" {
+		t.Errorf("default query routed to %s", got)
+	}
+	if got := name(ReadReplica(db)); got != "replica" {
+		t.Errorf("read only query routed to %s", got)
+	}
+	// 请求内写过数据后，只读查询也走主库
+	ctx := WithRouting(context.Background())
+	if got := name(ReadReplica(db.WithContext(ctx))); got != "replica" {
+		t.Errorf("read only query before write routed to %s", got)
+	}
+	if err = db.WithContext(ctx).Create(&routeItem{Name: "new"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := name(ReadReplica(db.WithContext(ctx))); got != "primary" {
+		t.Errorf("read only query after write routed to %s", got)
+	}
+}
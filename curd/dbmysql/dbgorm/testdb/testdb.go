@@ -0,0 +1,73 @@
+// Package testdb 单元测试使用的内存数据库（sqlite）
+package testdb
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var seq int64
+
+// Migration 迁移函数，建表或者初始化数据
+type Migration func(db *gorm.DB) error
+
+// New 打开一个内存数据库，迁移models，并注册为默认链接（dbgorm.DefaultName），测试结束时关闭
+// 默认链接是全局的，使用 New 的测试不要并行执行
+func New(t testing.TB, models ...interface{}) *gorm.DB {
+	return NewNamed(t, dbgorm.DefaultName, models...)
+}
+
+// NewNamed 同 New，注册为指定名称的链接
+func NewNamed(t testing.TB, name string, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db := Open(t)
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("auto migrate failed, %s", err.Error())
+	}
+	dbgorm.SetDB(name, db)
+	t.Cleanup(func() {
+		dbgorm.Close(name)
+	})
+	return db
+}
+
+// Open 打开一个独立的内存数据库（不注册），测试结束时关闭
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+	// 同一个内存库需要共享缓存，否则每个连接都是一个新的库
+	dsn := fmt.Sprintf("file:testdb_%d?mode=memory&cache=shared", atomic.AddInt64(&seq, 1))
+	db, err := dbgorm.Open(dbgorm.Options{
+		Driver: "sqlite3",
+		DSN:    dsn,
+		Config: &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)},
+	})
+	if err != nil {
+		t.Fatalf("open test db failed, %s", err.Error())
+	}
+	// 保持一个连接，避免最后一个连接关闭后内存库被释放
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get sql db failed, %s", err.Error())
+	}
+	sqlDB.SetMaxIdleConns(1)
+	sqlDB.SetConnMaxLifetime(0)
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+	return db
+}
+
+// Migrate 依次执行迁移函数
+func Migrate(t testing.TB, db *gorm.DB, migrations ...Migration) {
+	t.Helper()
+	for i, m := range migrations {
+		if err := m(db); err != nil {
+			t.Fatalf("migration[%d] failed, %s", i, err.Error())
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package flowcontroller
 
 import (
+	"net/http"
+	"reflect"
 	"runtime"
 	"strconv"
 
@@ -11,7 +13,7 @@ import (
 	"github.com/daimall/tools/curd/dbmysql/dbgorm"
 	"github.com/daimall/tools/curd/flow/v1/flowservice"
 	oplog "github.com/daimall/tools/curd/oplog"
-	"github.com/daimall/tools/functions"
+	"github.com/daimall/tools/curd/rbac"
 )
 
 // 继承公共基础
@@ -20,10 +22,15 @@ type BaseController struct {
 	Service     flowservice.FlowService //
 	ServiceName string                  //
 	uname       string                  // 用户名
+	changes     []oplog.Change          // 更新操作的字段变更（审计）
 }
 
 // 记录操作日志
 func (c *BaseController) LogFunc(serviceId uint, action, log string) {
+	if log == "" && len(c.changes) > 0 {
+		// 有字段变更时总是记录
+		log = oplog.DEFAULT_REMARK
+	}
 	if log == "" {
 		// 不记录操作日志
 		return
@@ -36,27 +43,84 @@ func (c *BaseController) LogFunc(serviceId uint, action, log string) {
 		logModel = &oplog.OpLog{User: c.uname, Action: action,
 			FlowId: serviceId, Flow: c.ServiceName, Remark: log}
 	}
-	oplog.AddOperationLog(dbgorm.GetDBInst(), logModel)
+	if len(c.changes) > 0 {
+		if setter, ok := logModel.(oplog.ChangesSetter); ok {
+			setter.SetChanges(oplog.EncodeChanges(c.changes))
+		}
+	}
+	db, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		logs.Error("get db failed, oplog[%s] not saved, %s", log, err.Error())
+		return
+	}
+	oplog.AddOperationLog(db, logModel)
+}
+
+// 审计：更新前的快照，未开启审计时返回nil
+func (c *BaseController) auditBefore() map[string]interface{} {
+	if !oplog.AuditEnabled() {
+		return nil
+	}
+	before, err := oplog.Snapshot(c.Service)
+	if err != nil {
+		logs.Error("snapshot service before update failed,", err.Error())
+		return nil
+	}
+	return before
+}
+
+// 审计：记录更新的字段变更，优先使用 service 记录的变更（BaseUpdate），否则比较更新前后的快照
+func (c *BaseController) auditChanges(before map[string]interface{}, ret interface{}) {
+	if before == nil {
+		return
+	}
+	if app, ok := c.Service.(flowservice.ChangesInf); ok && len(app.Changes()) > 0 {
+		c.changes = app.Changes()
+		return
+	}
+	var updated interface{} = c.Service
+	if ret != nil && reflect.TypeOf(ret) == reflect.TypeOf(c.Service) {
+		updated = ret
+	}
+	after, err := oplog.Snapshot(updated)
+	if err != nil {
+		logs.Error("snapshot service after update failed,", err.Error())
+		return
+	}
+	c.changes = oplog.Diff(before, after)
+}
+
+// 记录的字段变更时间线，日志表由 OplogModelInf 的模型确定
+func (c *BaseController) changeTimeline(serviceId uint) (ret interface{}, err error) {
+	var tblName string
+	if logService, ok := c.Service.(flowservice.OplogModelInf); ok {
+		if t, ok := logService.OplogModel(c.uname, c.ServiceName, serviceId, "", "").(interface{ TableName() string }); ok {
+			tblName = t.TableName()
+		}
+	}
+	db, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		return
+	}
+	return oplog.Timeline(dbgorm.ReadReplica(db.WithContext(c.Ctx.Request.Context())), tblName, c.ServiceName, serviceId)
 }
 
 // 预执行，获取service对象
 func (c *BaseController) Prepare() {
 	var PrepareFunc = func() {
+		// 加密请求自动解密
+		c.DecryptRequestBody()
+		c.routing()
 		c.ServiceName = c.Ctx.Input.Param(":service")
-		c.Service = flowservice.GetService(c.ServiceName)
-		if app, ok := c.Service.(flowservice.SetBaseControllerInf); ok {
-			app.SetBaseController(c.BaseController)
-		}
+		c.CheckPermission()
+		// 每个请求使用新的实例，请求相关的状态（SetBaseController）不能放在注册的共享实例上
+		c.Service = flowservice.GetService(c.ServiceName).NewInst()
+		common.SetRowScope(c.Ctx, rbac.RowScope(c.ServiceName, c.uname))
+		c.bindService()
 	}
 	var ok bool
-	// session 存储用户名模式
-	if c.uname, ok = c.GetSession(common.UserNameSessionKey).(string); ok {
-		PrepareFunc()
-		return
-	}
-	// 兼容token 模式（从token中解析出用户名）
-	var err error
-	if c.uname, err = functions.GetAccountIdFromToken(c.Ctx.Input.Header(common.TokenKey), []byte("test_group")); err == nil {
+	// 用户名由认证filter（common.AuthFilter）或者session提供
+	if c.uname, ok = common.GetUserName(c.Ctx); ok {
 		PrepareFunc()
 		return
 	}
@@ -70,6 +134,92 @@ func (c *BaseController) Prepare() {
 	c.StopRun()
 }
 
+// 当前请求绑定到service实例
+func (c *BaseController) bindService() {
+	if app, ok := c.Service.(flowservice.SetBaseControllerInf); ok {
+		app.SetBaseController(c.BaseController)
+	}
+}
+
+// 从数据库中加载流程实例，并绑定当前请求
+func (c *BaseController) loadService(serviceId uint) (err error) {
+	var service flowservice.FlowService
+	if service, err = c.Service.LoadInst(serviceId); err != nil {
+		return
+	}
+	c.Service = service
+	c.bindService()
+	return
+}
+
+// 请求绑定读写分离的路由状态，写请求的后续读取都走主库（见 dbgorm.ReadReplica）
+func (c *BaseController) routing() {
+	ctx := dbgorm.WithRouting(c.Ctx.Request.Context())
+	switch c.Ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		dbgorm.MarkWritten(ctx)
+	}
+	c.Ctx.Request = c.Ctx.Request.WithContext(ctx)
+}
+
+// 允许查询的字段，由service实现 flowservice.QueryFieldsInf 提供
+func (c *BaseController) QueryFields() []string {
+	if app, ok := c.Service.(flowservice.QueryFieldsInf); ok {
+		return app.QueryFields()
+	}
+	return nil
+}
+
+// 路由方法对应的权限action
+var methodActions = map[string]string{
+	"Post":            ServiceActionCreate,
+	"GetOne":          ServiceActionGetOne,
+	"GetAll":          ServiceActionGetAll,
+	"Put":             ServiceActionPut,
+	"Delete":          ServiceActionDelete,
+	"DeleteList":      ServiceActionDeleteList,
+	"Import":          ServiceActionImport,
+	"Export":          ServiceActionExport,
+	"Configs":         ServiceActionGetConfigs,
+	"Next":            ServiceActionNext,
+	"GetHistory":      ServiceOpList,
+	"GetOpLogHistory": ServiceOpList,
+	"GetPreHandlers":  ServiceOpList,
+	"GetTimeline":     ServiceOpList,
+}
+
+// PermissionAction 当前请求需要的权限action（自定义Action为 :action 参数）
+func (c *BaseController) PermissionAction() string {
+	_, method := c.GetControllerAndAction()
+	if method == "Action" {
+		return c.Ctx.Input.Param(":action")
+	}
+	if action, ok := methodActions[method]; ok {
+		return action
+	}
+	return method
+}
+
+// CheckPermission 校验用户是否有 service:action 权限（RBAC::Enable 开启时生效）
+func (c *BaseController) CheckPermission() {
+	if !rbac.Enabled() {
+		return
+	}
+	perm := rbac.Perm(c.ServiceName, c.PermissionAction())
+	ok, err := rbac.Allowed(c.uname, perm)
+	if err != nil {
+		logs.Error("check permission[%s] of user[%s] failed, %s", perm, c.uname, err.Error())
+		c.JSONResponse(common.ServerErr)
+		c.StopRun()
+	}
+	if !ok {
+		logs.Error("user[%s] has no permission[%s]", c.uname, perm)
+		c.JSONResponse(common.PermissionDenied)
+		c.StopRun()
+	}
+}
+
 //ResponseJSON（重写方法） 返回JSON格式结果
 func (c *BaseController) ResponseJSON(err error, ret interface{}, serviceId uint, action, oplog string) {
 	var method string
@@ -79,7 +229,7 @@ func (c *BaseController) ResponseJSON(err error, ret interface{}, serviceId uint
 		// 记录操作日志
 		c.LogFunc(serviceId, action, oplog)
 		c.JSONResponse(nil, ret)
-	} else if customErr, ok := err.(customerror.CustomError); ok {
+	} else if customErr, ok := customerror.As(err); ok {
 		logs.Error("FlowController[%s]%s(customErr)", method, err.Error())
 		c.JSONResponse(customErr, nil)
 	} else {
@@ -7,6 +7,9 @@ const (
 	ServiceActionPut        = "Update"
 	ServiceActionDelete     = "Delete"
 	ServiceActionDeleteList = "DeleteList"
+	ServiceActionImport     = "Import"
+	ServiceActionExport     = "Export"
+	ServiceActionNext       = "Next"
 
 	ServiceActionGetConfigs = "GetConfigs"
 	ServiceOpList           = "OperationList"
@@ -9,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
 	"github.com/daimall/tools/curd/common"
 	"github.com/daimall/tools/curd/customerror"
@@ -79,7 +78,7 @@ func (c *FlowController) Action() {
 		return
 	}
 	if serviceId != 0 {
-		if c.Service, err = c.Service.LoadInst(serviceId); err != nil {
+		if err = c.loadService(serviceId); err != nil {
 			logs.Error("service.LoadInst failed,", err.Error())
 			return
 		}
@@ -139,136 +138,27 @@ func (c *FlowController) GetAll() {
 	var err error
 	var oplog string
 	var serviceId uint
-	var ret struct {
-		Items interface{} `json:"items"`
-		Total int64       `json:"total"`
-	}
-	var l interface{}
-	var count int64
+	var ret interface{}
 	defer func() {
-		ret.Items = l
-		ret.Total = count
 		c.ResponseJSON(err, ret, serviceId, ServiceActionGetAll, oplog)
 	}()
-	//amis orderBy=id&orderDir=desc
-	// orderBy = sortBy
-	// orderDir = order
-	// limit = perPage
-	// offset = (page-1) * perPage
-	var fields []string
-	var sortby []string
-	var order []string
-	var query []*common.QueryConditon
-	var limit int = 10
-	var offset int
-	// fields: col1,col2,entity.col3
-	if v := c.GetString("fields"); v != "" {
-		fields = strings.Split(v, ",")
-	}
-	// limit: 10 (default is 10)
-	if v, err := c.GetInt("limit"); err == nil {
-		limit = v
-	}
-	// offset: 0 (default is 0)
-	if v, err := c.GetInt("offset"); err == nil {
-		offset = v
-	}
-	// 适配amis
-	if v, err := c.GetInt("perPage"); err == nil {
-		limit = v
-		if v, err := c.GetInt("page"); err == nil {
-			offset = (v - 1) * limit
-		}
-	}
-
-	// sortby: col1,col2
-	if v := c.GetString("sortby"); v != "" {
-		v = strings.Replace(v, ".", "__", -1)
-		sortby = strings.Split(v, ",")
-	}
-
-	// 适配amis
-	if v := c.GetString("orderBy"); v != "" {
-		sortby = []string{v}
-	}
-
-	// order: desc,asc
-	if v := c.GetString("order"); v != "" {
-		order = strings.Split(v, ",")
+	var req *common.ListRequest
+	if req, err = common.ParseListRequest(c.Ctx, c.QueryFields()...); err != nil {
+		return
 	}
-
-	// 适配amis
-	if v := c.GetString("orderDir"); v != "" {
-		order = []string{v}
+	if pageApp, ok := c.Service.(flowservice.GetAllByRequestInf); ok {
+		ret, oplog, err = pageApp.GetAllByRequest(c.uname, req)
+		return
 	}
-
-	var keepMap = map[string]struct{}{
-		"orderDir": {},
-		"orderBy":  {},
-		"page":     {},
-		"perPage":  {},
-	}
-	if beego.AppConfig.DefaultString("webKind", "BS") == "AMIS" {
-		// query: k|type=v,v,v  k|type:v|v|v  其中Type可以没有,默认值是 MultiText
-		kv := c.Ctx.Request.URL.Query()
-		for kInit, v1 := range kv {
-			if _, ok := keepMap[kInit]; ok {
-				continue
-			}
-			vInit := v1[0]
-			if len(strings.TrimSpace(vInit)) == 0 {
-				continue
-			}
-			qcondtion := new(common.QueryConditon)
-			key_type := strings.Split(kInit, "|") // 解析key中的type信息
-			if len(key_type) == 2 {
-				qcondtion.QueryKey = key_type[0]
-				qcondtion.QueryType = key_type[1]
-			} else if len(key_type) == 1 {
-				qcondtion.QueryKey = key_type[0]
-				qcondtion.QueryType = common.MultiText
-			} else {
-				logs.Error("Error: invalid query key|type format," + kInit)
-				c.JSONResponse(common.QueryCondErr)
-				return
-			}
-			qcondtion.QueryValues = strings.Split(vInit, ",") // 解析出values信息
-			//qcondtion.QueryKey = strings.Replace(qcondtion.QueryKey, ".", "__", -1)
-			query = append(query, qcondtion)
-		}
-	} else {
-		// query: k|type:v|v|v,k|type:v|v|v  其中Type可以没有,默认值是 MultiText
-		if v := c.GetString("query"); v != "" {
-			for _, cond := range strings.Split(v, ",") { // 分割多个查询key
-				qcondtion := new(common.QueryConditon)
-				kv := strings.SplitN(cond, ":", 2)
-				if len(kv) != 2 {
-					logs.Error("query condtion format error:%s, need key:value", kv)
-					c.JSONResponse(common.QueryCondErr)
-					return
-				}
-				kInit, vInit := kv[0], kv[1]          // 初始分割查询key和value（备注，value是多个用|分割）
-				key_type := strings.Split(kInit, "|") // 解析key中的type信息
-				if len(key_type) == 2 {
-					qcondtion.QueryKey = key_type[0]
-					qcondtion.QueryType = key_type[1]
-				} else if len(key_type) == 1 {
-					qcondtion.QueryKey = key_type[0]
-					qcondtion.QueryType = common.MultiText
-				} else {
-					logs.Error("Error: invalid query key|type format," + kInit)
-					c.JSONResponse(common.QueryCondErr)
-					return
-				}
-				qcondtion.QueryValues = strings.Split(vInit, "|") // 解析出values信息
-				//qcondtion.QueryKey = strings.Replace(qcondtion.QueryKey, ".", "__", -1)
-				query = append(query, qcondtion)
-			}
-		}
+	if req.CursorMode {
+		err = fmt.Errorf("cursor pagination is not supported by service %s", c.ServiceName)
+		return
 	}
-
 	if getAllApp, ok := c.Service.(flowservice.GetAllInf); ok {
-		l, count, oplog, err = getAllApp.GetAll(c.uname, query, fields, sortby, order, offset, limit)
+		var l interface{}
+		var count int64
+		l, count, oplog, err = getAllApp.GetAll(c.uname, req.Query, req.Fields, req.SortBy, req.Order, req.Offset, req.Limit)
+		ret = &common.Page{Items: l, Total: &count}
 		return
 	}
 	err = fmt.Errorf("getall interface is not implement")
@@ -303,13 +193,16 @@ func (c *FlowController) Put() {
 		return
 	}
 	if serviceId != 0 {
-		if c.Service, err = c.Service.LoadInst(serviceId); err != nil {
+		if err = c.loadService(serviceId); err != nil {
 			logs.Error("service.LoadInst failed,", err.Error())
 			return
 		}
 	}
 	if updateApp, ok := c.Service.(flowservice.UpdateInf); ok {
-		ret, oplog, err = updateApp.Update(serviceId, fields, c.BaseController.BaseController)
+		before := c.auditBefore()
+		if ret, oplog, err = updateApp.Update(serviceId, fields, c.BaseController.BaseController); err == nil {
+			c.auditChanges(before, ret)
+		}
 		return
 	}
 	err = fmt.Errorf("update interface is not implement")
@@ -381,7 +274,7 @@ func (c *FlowController) Import() {
 	var ret interface{}
 	var oplog string
 	defer func() {
-		c.ResponseJSON(err, ret, 0, ServiceActionDeleteList, oplog)
+		c.ResponseJSON(err, ret, 0, ServiceActionImport, oplog)
 	}()
 	if importApp, ok := c.Service.(flowservice.Import); ok {
 		var importFile io.Reader
@@ -411,11 +304,6 @@ func (c *FlowController) Export() {
 	var err error
 	var oplog string
 
-	var fields []string
-	var sortby []string
-	var order []string
-	var query []*common.QueryConditon
-
 	defer func() {
 		var method string
 		pc, _, _, _ := runtime.Caller(1)
@@ -424,7 +312,7 @@ func (c *FlowController) Export() {
 			// 记录操作日志
 			c.LogFunc(0, "export", oplog)
 		} else {
-			if customErr, ok := err.(customerror.CustomError); ok {
+			if customErr, ok := customerror.As(err); ok {
 				logs.Error("FlowController[%s]%s(customErr)", method, err.Error())
 				c.JSONResponse(customErr)
 			} else {
@@ -433,105 +321,26 @@ func (c *FlowController) Export() {
 			}
 		}
 	}()
-	// fields: col1,col2,entity.col3
-	if v := c.GetString("fields"); v != "" {
-		fields = strings.Split(v, ",")
-	}
-	// sortby: col1,col2
-	if v := c.GetString("sortby"); v != "" {
-		v = strings.Replace(v, ".", "__", -1)
-		sortby = strings.Split(v, ",")
-	}
-
-	// 适配amis
-	if v := c.GetString("orderBy"); v != "" {
-		sortby = []string{v}
-	}
-
-	// order: desc,asc
-	if v := c.GetString("order"); v != "" {
-		order = strings.Split(v, ",")
-	}
-
-	// 适配amis
-	if v := c.GetString("orderDir"); v != "" {
-		order = []string{v}
+	var req *common.ListRequest
+	if req, err = common.ParseExportRequest(c.Ctx, c.QueryFields()...); err != nil {
+		return
 	}
-
-	var keepMap = map[string]struct{}{
-		"orderDir": {},
-		"orderBy":  {},
-		"page":     {},
-		"perPage":  {},
-	}
-	if beego.AppConfig.DefaultString("webKind", "BS") == "AMIS" {
-		// query: k|type=v,v,v  k|type:v|v|v  其中Type可以没有,默认值是 MultiText
-		kv := c.Ctx.Request.URL.Query()
-		for kInit, v1 := range kv {
-			if _, ok := keepMap[kInit]; ok {
-				continue
-			}
-			vInit := v1[0]
-			qcondtion := new(common.QueryConditon)
-			key_type := strings.Split(kInit, "|") // 解析key中的type信息
-			if len(key_type) == 2 {
-				qcondtion.QueryKey = key_type[0]
-				qcondtion.QueryType = key_type[1]
-			} else if len(key_type) == 1 {
-				qcondtion.QueryKey = key_type[0]
-				qcondtion.QueryType = common.MultiText
-			} else {
-				logs.Error("Error: invalid query key|type format," + kInit)
-				c.JSONResponse(common.QueryCondErr)
-				return
-			}
-			qcondtion.QueryValues = strings.Split(vInit, ",") // 解析出values信息
-			//qcondtion.QueryKey = strings.Replace(qcondtion.QueryKey, ".", "__", -1)
-			query = append(query, qcondtion)
-		}
+	var content io.ReadSeeker
+	if exportApp, ok := c.Service.(flowservice.ExportByRequestInf); ok {
+		content, oplog, err = exportApp.ExportByRequest(c.uname, req)
+	} else if exportApp, ok := c.Service.(flowservice.Export); ok {
+		content, oplog, err = exportApp.Export(c.uname, req.Query, req.Fields, req.SortBy, req.Order)
 	} else {
-		// query: k|type:v|v|v,k|type:v|v|v  其中Type可以没有,默认值是 MultiText
-		if v := c.GetString("query"); v != "" {
-			for _, cond := range strings.Split(v, ",") { // 分割多个查询key
-				qcondtion := new(common.QueryConditon)
-				kv := strings.SplitN(cond, ":", 2)
-				if len(kv) != 2 {
-					logs.Error("query condtion format error:%s, need key:value", kv)
-					c.JSONResponse(common.QueryCondErr)
-					return
-				}
-				kInit, vInit := kv[0], kv[1]          // 初始分割查询key和value（备注，value是多个用|分割）
-				key_type := strings.Split(kInit, "|") // 解析key中的type信息
-				if len(key_type) == 2 {
-					qcondtion.QueryKey = key_type[0]
-					qcondtion.QueryType = key_type[1]
-				} else if len(key_type) == 1 {
-					qcondtion.QueryKey = key_type[0]
-					qcondtion.QueryType = common.MultiText
-				} else {
-					logs.Error("Error: invalid query key|type format," + kInit)
-					c.JSONResponse(common.QueryCondErr)
-					return
-				}
-				qcondtion.QueryValues = strings.Split(vInit, "|") // 解析出values信息
-				//qcondtion.QueryKey = strings.Replace(qcondtion.QueryKey, ".", "__", -1)
-				query = append(query, qcondtion)
-			}
-		}
+		err = fmt.Errorf("export interface not implement")
+		return
 	}
-
-	if exportApp, ok := c.Service.(flowservice.Export); ok {
-		var content io.ReadSeeker
-		if content, oplog, err = exportApp.Export(c.uname, query, fields, sortby, order); err != nil {
-			logs.Error("export failed,", err.Error())
-			return
-		}
-		c.Ctx.ResponseWriter.Header().Add("Content-Disposition", "attachment")
-		c.Ctx.ResponseWriter.Header().Add("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-		http.ServeContent(c.Ctx.ResponseWriter, c.Ctx.Request, "export", time.Now(), content)
+	if err != nil {
+		logs.Error("export failed,", err.Error())
 		return
 	}
-	err = fmt.Errorf("export interface not implement")
+	c.Ctx.ResponseWriter.Header().Add("Content-Disposition", "attachment")
+	c.Ctx.ResponseWriter.Header().Add("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	http.ServeContent(c.Ctx.ResponseWriter, c.Ctx.Request, "export", time.Now(), content)
 }
 
 // Configs ...
@@ -591,19 +400,15 @@ func (c *FlowController) Next() {
 		return
 	}
 	if serviceId != 0 {
-		if c.Service, err = c.Service.LoadInst(serviceId); err != nil {
+		if err = c.loadService(serviceId); err != nil {
 			logs.Error("service.LoadInst failed,", err.Error())
 			return
 		}
 	}
 	var step flowservice.FlowStep
-	if curStepApp, ok := c.Service.(flowservice.GetCurStepInf); ok {
-		if step, err = curStepApp.GetCurStep(); err != nil {
-			logs.Error("GetCurStep failed, %s", err.Error())
-			return
-		}
-	} else {
-		err = fmt.Errorf("GetCurStep interface is not implement")
+	// 并行分支中按处理记录所在的分支
+	if step, err = flowservice.HandlerStep(c.Service, c.ServiceName, c.uname, handlerId); err != nil {
+		logs.Error("GetCurStep failed, %s", err.Error())
 		return
 	}
 	action = step.Key()
@@ -634,7 +439,7 @@ func (c *FlowController) GetHistory() {
 		logs.Error("get serviceId failed,", err.Error())
 		return
 	}
-	if c.Service, err = c.Service.LoadInst(serviceId); err != nil {
+	if err = c.loadService(serviceId); err != nil {
 		logs.Error("service.LoadInst failed,", err.Error())
 		return
 	}
@@ -665,7 +470,7 @@ func (c *FlowController) GetOpLogHistory() {
 		logs.Error("get serviceId failed,", err.Error())
 		return
 	}
-	if c.Service, err = c.Service.LoadInst(serviceId); err != nil {
+	if err = c.loadService(serviceId); err != nil {
 		logs.Error("service.LoadInst failed,", err.Error())
 		return
 	}
@@ -676,6 +481,28 @@ func (c *FlowController) GetOpLogHistory() {
 	}
 }
 
+// GetTimeline ...
+// @Title Get 获取字段变更时间线（OpLog::Audit 开启后记录）
+// @Description get change timeline
+// @Param id path string true "The key for staticblock"
+// @Success 200 {object} []oplog.TimelineEntry
+// @Failure 403 :id is empty
+// @router /:service/:id/timeline [get]
+func (c *FlowController) GetTimeline() {
+	var err error
+	var ret interface{}
+	var serviceId uint
+	defer func() {
+		c.ResponseJSON(err, ret, serviceId, ServiceOpList, "")
+	}()
+	idStr := c.Ctx.Input.Param(":id")
+	if serviceId, err = getUintID(idStr); err != nil {
+		logs.Error("get serviceId failed,", err.Error())
+		return
+	}
+	ret, err = c.changeTimeline(serviceId)
+}
+
 // GetPreHandlers ...
 // @Title Get 获取上一步处理人（退回流程使用）
 // @Description get get operation list history
@@ -696,7 +523,7 @@ func (c *FlowController) GetPreHandlers() {
 		logs.Error("get serviceId failed,", err.Error())
 		return
 	}
-	if c.Service, err = c.Service.LoadInst(serviceId); err != nil {
+	if err = c.loadService(serviceId); err != nil {
 		logs.Error("service.LoadInst failed,", err.Error())
 		return
 	}
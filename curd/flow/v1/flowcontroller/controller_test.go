@@ -0,0 +1,142 @@
+package flowcontroller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/daimall/tools/curd/common"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+	"github.com/daimall/tools/curd/flow/v1/flowservice"
+	"github.com/daimall/tools/curd/rbac"
+	"gorm.io/gorm"
+)
+
+const docService = "ctrl-doc"
+
+type docFlow struct {
+	flowservice.CommFlow
+	Title string `gorm:"size:100" json:"title"`
+}
+
+func (f *docFlow) GetFlowName() string { return docService }
+func (f *docFlow) New(uname string, c common.BaseController) (uint, interface{}, string, error) {
+	return 0, nil, "", nil
+}
+func (f *docFlow) NewInst() flowservice.FlowService                  { return &docFlow{} }
+func (f *docFlow) LoadInst(id uint) (flowservice.FlowService, error) { return &docFlow{}, nil }
+func (f *docFlow) GetAll(uname string, query []*common.QueryConditon, fields []string,
+	sortby []string, order []string, offset int, limit int) (ret interface{}, count int64, oplog string, err error) {
+	db, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		return
+	}
+	var l []docFlow
+	ret, count, err = f.BaseGetAll(db, &docFlow{}, &l, query, fields, sortby, order, offset, limit)
+	return
+}
+
+func newDocRouter(t *testing.T) *beego.ControllerRegister {
+	db := testdb.New(t, append(rbac.Models(), &docFlow{})...)
+	for i, creator := range []string{"alice", "bob", "bob"} {
+		f := &docFlow{Title: fmt.Sprintf("doc%d", i)}
+		f.Creator = creator
+		db.Create(f)
+	}
+	handler := beego.NewControllerRegister()
+	// 测试用户由请求头指定
+	handler.InsertFilter("/*", beego.BeforeRouter, func(ctx *context.Context) {
+		common.SetUserName(ctx, ctx.Input.Header("X-User"))
+	})
+	handler.Add("/v1/flow/:service", &FlowController{}, "get:GetAll")
+	return handler
+}
+
+type docResult struct {
+	Code int `json:"code"`
+	Data struct {
+		Items []docFlow `json:"items"`
+		Total int64     `json:"total"`
+	} `json:"data"`
+}
+
+func getDocs(handler *beego.ControllerRegister, uname string) (ret docResult, status int, err error) {
+	req := httptest.NewRequest("GET", "/v1/flow/"+docService+"?limit=10", nil)
+	req.Header.Set("X-User", uname)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	err = json.Unmarshal(rec.Body.Bytes(), &ret)
+	return ret, rec.Code, err
+}
+
+func init() {
+	flowservice.Register(docService, &docFlow{})
+	rbac.RegisterRowScope(docService, func(uname string, db *gorm.DB) *gorm.DB {
+		return db.Where("creator = ?", uname)
+	})
+}
+
+func Test_RowScopeConcurrent(t *testing.T) {
+	handler := newDocRouter(t)
+	// 不同用户的请求同时执行，每个请求只能看到自己的记录
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 40; i++ {
+		uname, expect := "alice", 1
+		if i%2 == 1 {
+			uname, expect = "bob", 2
+		}
+		wg.Add(1)
+		go func(uname string, expect int) {
+			defer wg.Done()
+			ret, _, err := getDocs(handler, uname)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ret.Code != 0 || len(ret.Data.Items) != expect || ret.Data.Total != int64(expect) {
+				errs <- fmt.Errorf("%s: expect %d docs, got %+v", uname, expect, ret)
+				return
+			}
+			for _, d := range ret.Data.Items {
+				if d.Creator != uname {
+					errs <- fmt.Errorf("%s: got doc of %s", uname, d.Creator)
+					return
+				}
+			}
+		}(uname, expect)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func Test_CheckPermission(t *testing.T) {
+	handler := newDocRouter(t)
+	db, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rbac.CreateRole(db, "reader", "")
+	rbac.Grant(db, "reader", rbac.Perm(docService, ServiceActionGetAll))
+	rbac.AssignRole(db, "alice", "reader")
+	rbac.InvalidateCache()
+	defer rbac.InvalidateCache()
+	beego.AppConfig.Set("RBAC::Enable", "true")
+	defer beego.AppConfig.Set("RBAC::Enable", "false")
+
+	if ret, _, err := getDocs(handler, "alice"); err != nil || ret.Code != 0 || len(ret.Data.Items) != 1 {
+		t.Errorf("expect alice allowed, got %+v %v", ret, err)
+	}
+	ret, status, err := getDocs(handler, "bob")
+	if err != nil || status != 403 || ret.Code != common.PermissionDenied.GetCode() {
+		t.Errorf("expect bob denied, got %d %+v %v", status, ret, err)
+	}
+}
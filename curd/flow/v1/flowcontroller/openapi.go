@@ -0,0 +1,71 @@
+package flowcontroller
+
+import (
+	"fmt"
+	"html/template"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/flow/v1/flowservice"
+)
+
+// OpenAPIController 输出已注册服务的 OpenAPI 3 文档
+type OpenAPIController struct {
+	beego.Controller
+}
+
+// RegisterOpenAPIRouter 注册文档路由：path/openapi.json 和 path/docs
+func RegisterOpenAPIRouter(path string) {
+	beego.Router(path+"/openapi.json", &OpenAPIController{}, "get:JSON")
+	beego.Router(path+"/docs", &OpenAPIController{}, "get:UI")
+}
+
+// JSON ...
+// @Title OpenAPI 文档
+// @Description 根据已注册的服务生成 OpenAPI 3 文档
+// @Success 200 {object} common.OpenAPI
+// @router /openapi.json [get]
+func (c *OpenAPIController) JSON() {
+	c.Data["json"] = flowservice.OpenAPIDocument(
+		beego.AppConfig.DefaultString("OpenAPI::Title", beego.BConfig.AppName),
+		beego.AppConfig.DefaultString("OpenAPI::Version", "1.0.0"),
+		beego.AppConfig.DefaultString("OpenAPI::BasePath", "/"))
+	c.ServeJSON()
+}
+
+var openAPIUITpl = template.Must(template.New("openapi").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.Assets}}/swagger-ui-bundle.js"></script>
+<script>
+window.onload = function() { SwaggerUIBundle({url: "{{.SpecURL}}", dom_id: "#swagger-ui"}); };
+</script>
+</body>
+</html>
+`))
+
+// UI ...
+// @Title OpenAPI 文档页面
+// @Description swagger-ui 页面，静态资源地址由 OpenAPI::UIAssets 配置
+// @router /docs [get]
+func (c *OpenAPIController) UI() {
+	specURL := beego.AppConfig.String("OpenAPI::SpecURL")
+	if specURL == "" {
+		// 默认和页面同级的 openapi.json
+		specURL = "openapi.json"
+	}
+	c.Ctx.Output.Header("Content-Type", "text/html; charset=utf-8")
+	if err := openAPIUITpl.Execute(c.Ctx.ResponseWriter, map[string]string{
+		"Title":   beego.AppConfig.DefaultString("OpenAPI::Title", beego.BConfig.AppName),
+		"Assets":  beego.AppConfig.DefaultString("OpenAPI::UIAssets", "https://unpkg.com/swagger-ui-dist@4"),
+		"SpecURL": specURL,
+	}); err != nil {
+		c.Ctx.Output.SetStatus(500)
+		c.Ctx.WriteString(fmt.Sprintf("render openapi ui failed, %s", err.Error()))
+	}
+}
@@ -0,0 +1,121 @@
+package flowservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+// Calendar 工作时间，按工作时间计算步骤时限（Deadline.Business）
+type Calendar struct {
+	Start    time.Duration         // 上班时间（距0点）
+	End      time.Duration         // 下班时间
+	Weekdays map[time.Weekday]bool // 工作日
+	Holidays map[string]bool       // 节假日，格式 2006-01-02
+	Workdays map[string]bool       // 调休上班的日期
+}
+
+// DefaultCalendar 周一到周五 9:00-18:00
+func DefaultCalendar() *Calendar {
+	return &Calendar{Start: 9 * time.Hour, End: 18 * time.Hour, Weekdays: map[time.Weekday]bool{
+		time.Monday: true, time.Tuesday: true, time.Wednesday: true, time.Thursday: true, time.Friday: true}}
+}
+
+// CalendarFromConf 工作时间配置，未配置的项使用 DefaultCalendar
+// [Flow]
+// WorkHours = 09:00-18:00
+// WorkDays = 1,2,3,4,5  # 0 表示周日
+// Holidays = 2022-10-03,2022-10-04
+// ExtraWorkdays = 2022-10-08
+func CalendarFromConf() (c *Calendar, err error) {
+	c = DefaultCalendar()
+	if v := beego.AppConfig.String("Flow::WorkHours"); v != "" {
+		hours := strings.SplitN(v, "-", 2)
+		if len(hours) != 2 {
+			return nil, fmt.Errorf("invalid Flow::WorkHours %s", v)
+		}
+		if c.Start, err = parseClock(hours[0]); err != nil {
+			return
+		}
+		if c.End, err = parseClock(hours[1]); err != nil {
+			return
+		}
+		if c.Start >= c.End {
+			return nil, fmt.Errorf("invalid Flow::WorkHours %s", v)
+		}
+	}
+	if v := beego.AppConfig.String("Flow::WorkDays"); v != "" {
+		c.Weekdays = map[time.Weekday]bool{}
+		for _, d := range strings.Split(v, ",") {
+			n, perr := strconv.Atoi(strings.TrimSpace(d))
+			if perr != nil || n < 0 || n > 6 {
+				return nil, fmt.Errorf("invalid Flow::WorkDays %s", v)
+			}
+			c.Weekdays[time.Weekday(n)] = true
+		}
+	}
+	if c.Holidays, err = parseDates("Flow::Holidays"); err != nil {
+		return
+	}
+	c.Workdays, err = parseDates("Flow::ExtraWorkdays")
+	return
+}
+
+// 解析 15:04 格式的时间
+func parseClock(s string) (d time.Duration, err error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s, %s", s, err.Error())
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseDates(key string) (dates map[string]bool, err error) {
+	dates = map[string]bool{}
+	for _, d := range beego.AppConfig.Strings(key) {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		if _, err = time.Parse("2006-01-02", d); err != nil {
+			return nil, fmt.Errorf("invalid %s %s", key, d)
+		}
+		dates[d] = true
+	}
+	return
+}
+
+// IsWorkday 是否工作日
+func (c *Calendar) IsWorkday(t time.Time) bool {
+	date := t.Format("2006-01-02")
+	if c.Workdays[date] {
+		return true
+	}
+	return c.Weekdays[t.Weekday()] && !c.Holidays[date]
+}
+
+// Add from 之后经过工作时间 d 的时刻
+func (c *Calendar) Add(from time.Time, d time.Duration) time.Time {
+	t := from
+	// 最多查找10年，避免没有工作日时死循环
+	for i := 0; i < 3660; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		if c.IsWorkday(day) {
+			start, end := day.Add(c.Start), day.Add(c.End)
+			if t.Before(start) {
+				t = start
+			}
+			if t.Before(end) {
+				left := end.Sub(t)
+				if d <= left {
+					return t.Add(d)
+				}
+				d -= left
+			}
+		}
+		t = day.AddDate(0, 0, 1)
+	}
+	return t
+}
@@ -14,13 +14,16 @@ import (
 )
 
 type CheckHandler struct {
-	ID          uint   `gorm:"primary_key"  json:"id"`                                        // 自增主键
-	User        string `gorm:"size:100;column:user;unique_index:onerecord"  json:"user"`      // 操作者的账户id
-	Step        string `gorm:"size:20;column:step;unique_index:onerecord"  json:"step"`       // 步骤
-	ServiceId   uint   `gorm:"column:service_id;unique_index:onerecord"  json:"serviceId"`    // FlowId
-	ServiceName string `gorm:"size:50;column:service;unique_index:onerecord"  json:"service"` // FlowType
-	Conclusion  int    `gorm:"column:conclusion"  json:"conclusion"`                          // 评审结论,0未评审，1 通过，2 风险通过 3 拒绝, 100 转他人处理
-	Remark      string `gorm:"column:remark"  json:"remark"`                                  // 操作详情
+	ID          uint       `gorm:"primary_key"  json:"id"`                                        // 自增主键
+	User        string     `gorm:"size:100;column:user;unique_index:onerecord"  json:"user"`      // 操作者的账户id
+	Step        string     `gorm:"size:20;column:step;unique_index:onerecord"  json:"step"`       // 步骤
+	ServiceId   uint       `gorm:"column:service_id;unique_index:onerecord"  json:"serviceId"`    // FlowId
+	ServiceName string     `gorm:"size:50;column:service;unique_index:onerecord"  json:"service"` // FlowType
+	Conclusion  int        `gorm:"column:conclusion"  json:"conclusion"`                          // 评审结论,0未评审，1 通过，2 风险通过 3 拒绝, 100 转他人处理
+	Remark      string     `gorm:"column:remark"  json:"remark"`                                  // 操作详情
+	DueAt       *time.Time `gorm:"column:due_at;index"  json:"dueAt"`                             // 处理时限（见 Deadline），为空表示不限
+	RemindedAt  *time.Time `gorm:"column:reminded_at"  json:"remindedAt"`                         // 到期提醒的时间
+	EscalatedAt *time.Time `gorm:"column:escalated_at"  json:"escalatedAt"`                       // 超时升级的时间
 	//Attach      string      `gorm:"size:1000;column:attach;index"  json:"attach"` // 附件
 	CreatedAt     time.Time   `json:"created_at"` // 创建时间
 	UpdatedAt     time.Time   `json:"updated_at"` // 最后更新时间
@@ -44,7 +47,10 @@ func (h *CheckHandler) Do(uname string, c common.BaseController) (ret interface{
 			return
 		}
 	}
-	dbInst := dbgorm.GetDBInst()
+	var dbInst *gorm.DB
+	if dbInst, err = dbgorm.Get(dbgorm.DefaultName); err != nil {
+		return
+	}
 	tx := dbInst.Begin()
 	defer func() {
 		if err == nil {
@@ -111,7 +117,11 @@ func (h *CheckHandler) Do(uname string, c common.BaseController) (ret interface{
 		return
 	}
 	var curStepHandlers []FlowHandler
-	if napp, ok := h.Flow.(GoNextInf); ok {
+	if napp, ok := h.Flow.(GoNextFromInf); ok {
+		if curStepHandlers, err = napp.GoNextFrom(tx, h.Step, checkHandler.PreStepHandlerIds); err != nil {
+			return
+		}
+	} else if napp, ok := h.Flow.(GoNextInf); ok {
 		if curStepHandlers, err = napp.GoNext(tx, checkHandler.PreStepHandlerIds); err != nil {
 			return
 		}
@@ -140,18 +150,16 @@ func (h *CheckHandler) LoadInst(flow FlowService, uname string, id uint) (ret Fl
 		handler.ServiceId = flow.GetID()
 		handler.ServiceName = flow.GetFlowName()
 		var step FlowStep
-		if curApp, ok := flow.(GetCurStepInf); ok {
-			if step, err = curApp.GetCurStep(); err != nil {
-				logs.Error("get cur step failed,", err.Error(), flow)
-				return
-			}
-		} else {
-			err = fmt.Errorf("GetCurStepInf is not implement")
+		if step, err = HandlerStep(flow, flow.GetFlowName(), uname, 0); err != nil {
+			logs.Error("get cur step failed,", err.Error(), flow)
 			return
 		}
 		handler.Step = step.Key()
 	}
-	dbInst := dbgorm.GetDBInst()
+	var dbInst *gorm.DB
+	if dbInst, err = dbgorm.Get(dbgorm.DefaultName); err != nil {
+		return
+	}
 	if err = dbInst.Table(h.TableName()).Where(handler).First(handler).Error; err != nil {
 		logs.Error("get handler failed,", err.Error(), handler)
 		return
@@ -1,7 +1,6 @@
 package flowservice
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -10,6 +9,7 @@ import (
 
 	"github.com/astaxie/beego/logs"
 	"github.com/daimall/tools/curd/common"
+	oplog "github.com/daimall/tools/curd/oplog"
 	"gorm.io/gorm"
 )
 
@@ -30,7 +30,7 @@ func (c *CommFlow) Create(dbInst *gorm.DB, obj interface{}) (err error) {
 func (c *CommFlow) BaseGetAll(dbInst *gorm.DB, crudModel interface{}, ret interface{}, querys []*common.QueryConditon, fields []string, sortby []string, order []string,
 	offset int, limit int) (ml interface{}, totalcount int64, err error) {
 	var g *gorm.DB
-	if g, err = c.BaseQuery(dbInst, crudModel, querys, fields, sortby, order); err != nil {
+	if g, err = c.BaseQuery(c.readDB(dbInst, nil), crudModel, querys, fields, sortby, order); err != nil {
 		return
 	}
 	if err = g.Count(&totalcount).Error; err != nil {
@@ -49,6 +49,11 @@ func (c *CommFlow) BaseGetAll(dbInst *gorm.DB, crudModel interface{}, ret interf
 	return ret, totalcount, nil
 }
 
+// 按请求参数分页查询 CRUD 对象，支持游标分页（见 common.FindPage）
+func (c *CommFlow) BaseGetPage(dbInst *gorm.DB, crudModel interface{}, ret interface{}, req *common.ListRequest) (page *common.Page, err error) {
+	return common.FindPage(c.readDB(dbInst, req.Ctx), crudModel, ret, req)
+}
+
 // 获取一个CRUD对象
 func (c *CommFlow) BaseGetOne(dbInst *gorm.DB, crudModel interface{}, id int64) (ret interface{}, err error) {
 	if err = dbInst.First(crudModel, id).Error; err != nil {
@@ -59,6 +64,7 @@ func (c *CommFlow) BaseGetOne(dbInst *gorm.DB, crudModel interface{}, id int64)
 }
 
 // 更新一个CRUD 对象
+// 开启审计（oplog.AuditEnabled）时记录更新前后数据库中记录的字段变更，见 Changes
 func (c *CommFlow) BaseUpdate(dbInst *gorm.DB, crudModel interface{}, fields []string, saveNil ...bool) (ret interface{}, err error) {
 	var count int64
 	var fieldsMap = map[string]struct{}{}
@@ -68,6 +74,23 @@ func (c *CommFlow) BaseUpdate(dbInst *gorm.DB, crudModel interface{}, fields []s
 	if count == 0 {
 		return nil, fmt.Errorf("record not found")
 	}
+	if oplog.AuditEnabled() {
+		var before interface{}
+		if before, err = reloadModel(dbInst, crudModel); err != nil {
+			logs.Error("load crud obj before update failed,", err.Error())
+			return
+		}
+		defer func() {
+			if err != nil {
+				return
+			}
+			if after, rerr := reloadModel(dbInst, crudModel); rerr == nil {
+				c.changes = oplog.DiffModels(before, after)
+			} else {
+				logs.Error("load crud obj after update failed,", rerr.Error())
+			}
+		}()
+	}
 	if len(fields) > 0 {
 		for _, v := range fields {
 			fieldsMap[v] = struct{}{}
@@ -92,63 +115,33 @@ func (c *CommFlow) BaseUpdate(dbInst *gorm.DB, crudModel interface{}, fields []s
 	return crudModel, err
 }
 
-// 基础方法 ---
-func (c *CommFlow) BaseQuery(dbInst *gorm.DB, crudModel interface{}, querys []*common.QueryConditon,
-	fields []string, sortby []string, order []string) (o *gorm.DB, err error) {
-	g := dbInst.Model(crudModel)
-	for _, query := range querys {
-		switch query.QueryType {
-		case common.MultiSelect: // 多选
-			g = g.Where(fmt.Sprintf("%s in (?)", query.QueryKey), query.QueryValues)
-		case common.NumRange: // 数字范围
-			if len(query.QueryValues) == 2 {
-				g = g.Where(fmt.Sprintf("%s >= ? AND %s <= ?", query.QueryKey, query.QueryKey), query.QueryValues[0], query.QueryValues[1])
-			} else {
-				return nil, fmt.Errorf("query params err %+v", query)
-			}
-		case common.NotIn: //
-			g = g.Not(query.QueryKey, query.QueryValues)
-		case common.CommaMultiSelect:
-			var whereCond []string
-			var values []interface{}
-			if len(query.QueryValues) > 0 {
-				for _, v := range query.QueryValues {
-					whereCond = append(whereCond, fmt.Sprintf("FIND_IN_SET(?,%s)", query.QueryKey))
-					values = append(values, v)
-				}
-				g = g.Where(strings.Join(whereCond, " OR "), values...)
-			}
-		default:
-			// case common.MultiText: // 模糊多值匹配
-			sql := make([]string, len(query.QueryValues))
-			val := make([]interface{}, len(query.QueryValues))
-			for i := range query.QueryValues {
-				sql[i] = fmt.Sprintf("%s LIKE ?", query.QueryKey)
-				val[i] = fmt.Sprintf("%%%s%%", query.QueryValues[i])
-			}
-			g = g.Where(strings.Join(sql, " or "), val...)
-		}
+// 按主键从数据库重新读取一个新的对象（不影响 crudModel）
+func reloadModel(dbInst *gorm.DB, crudModel interface{}) (ret interface{}, err error) {
+	stmt := &gorm.Statement{DB: dbInst}
+	if err = stmt.Parse(crudModel); err != nil {
+		return
 	}
-
-	if len(fields) > 0 {
-		g = g.Select(fields)
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if pk == nil {
+		return nil, fmt.Errorf("%s has no primary key", stmt.Schema.Name)
 	}
-	if len(sortby) != 0 {
-		if len(sortby) == len(order) {
-			// 1) for each sort field, there is an associated order
-			for i := range sortby {
-				g = g.Order(fmt.Sprintf("%s %s", sortby[i], order[i]))
-			}
+	id, _ := pk.ValueOf(reflect.Indirect(reflect.ValueOf(crudModel)))
+	ret = reflect.New(reflect.Indirect(reflect.ValueOf(crudModel)).Type()).Interface()
+	err = dbInst.Session(&gorm.Session{NewDB: true}).Table(stmt.Table).
+		Where(fmt.Sprintf("%s = ?", pk.DBName), id).First(ret).Error
+	return
+}
 
-		} else if len(sortby) != len(order) && len(order) == 1 {
-			for i := range sortby {
-				g = g.Order(fmt.Sprintf("%s %s", sortby[i], order[0]))
-			}
-		} else if len(sortby) != len(order) && len(order) != 1 {
-			return nil, errors.New("error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
-		}
+// 基础方法 ---
+// 查询条件的构造见 common.BuildQuery
+func (c *CommFlow) BaseQuery(dbInst *gorm.DB, crudModel interface{}, querys []*common.QueryConditon,
+	fields []string, sortby []string, order []string) (o *gorm.DB, err error) {
+	dbInst = dbInst.Model(crudModel)
+	// 当前用户的行级数据权限（见 rbac.RegisterRowScope），BaseController 由 flowcontroller 按请求设置
+	if scope := common.RowScopeOf(c.BaseController.Ctx); scope != nil {
+		dbInst = dbInst.Scopes(scope)
 	}
-	return g, nil
+	return common.BuildQuery(dbInst, querys, fields, sortby, order)
 }
 
 // 导入数据
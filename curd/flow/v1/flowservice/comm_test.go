@@ -0,0 +1,117 @@
+package flowservice
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/daimall/tools/curd/common"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+	"gorm.io/gorm"
+)
+
+type testFlow struct {
+	CommFlow
+	Title string `gorm:"size:100" json:"title"`
+	Tags  string `gorm:"size:100" json:"tags"` // 逗号分隔
+}
+
+func Test_BaseGetAll(t *testing.T) {
+	db := testdb.New(t, &testFlow{})
+	for _, f := range []testFlow{
+		{Title: "alpha", Tags: "a,b"},
+		{Title: "beta", Tags: "b,c"},
+		{Title: "gamma", Tags: "c"},
+		{Title: "Alphabet", Tags: "ab"},
+	} {
+		f := f
+		if err := db.Create(&f).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		query []*common.QueryConditon
+		count int64
+	}{
+		{[]*common.QueryConditon{{QueryKey: "tags", QueryType: common.CommaMultiSelect, QueryValues: []string{"b"}}}, 2},
+		{[]*common.QueryConditon{{QueryKey: "tags", QueryType: common.CommaMultiSelect, QueryValues: []string{"a", "c"}}}, 3},
+		{[]*common.QueryConditon{{QueryKey: "title", QueryType: common.MultiText, QueryValues: []string{"alpha"}}}, 2},
+		{[]*common.QueryConditon{{QueryKey: "title", QueryType: common.Eq, QueryValues: []string{"beta"}}}, 1},
+		{[]*common.QueryConditon{{QueryKey: "title", QueryType: common.IsNull}}, 0},
+	}
+	for i, c := range cases {
+		var l []testFlow
+		_, count, err := (&CommFlow{}).BaseGetAll(db, &testFlow{}, &l, c.query, nil, []string{"id"}, []string{"asc"}, 0, 10)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err.Error())
+		}
+		if count != c.count || int64(len(l)) != c.count {
+			t.Errorf("case %d: want %d, got %d/%d", i, c.count, count, len(l))
+		}
+	}
+	// 非法字段名
+	var l []testFlow
+	if _, _, err := (&CommFlow{}).BaseGetAll(db, &testFlow{}, &l, []*common.QueryConditon{
+		{QueryKey: "title;drop table test_flows", QueryValues: []string{"x"}},
+	}, nil, nil, nil, 0, 10); err == nil {
+		t.Error("invalid query key should be rejected")
+	}
+}
+
+func Test_RowScope(t *testing.T) {
+	db := testdb.New(t, &testFlow{})
+	for _, f := range []testFlow{{Title: "a"}, {Title: "b"}, {Title: "c"}} {
+		f := f
+		f.Creator = "alice"
+		if f.Title == "b" {
+			f.Creator = "bob"
+		}
+		if err := db.Create(&f).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.NewContext()
+	ctx.Reset(httptest.NewRecorder(), httptest.NewRequest("GET", "/?limit=10", nil))
+	common.SetRowScope(ctx, func(db *gorm.DB) *gorm.DB { return db.Where("creator = ?", "alice") })
+	c := &CommFlow{}
+	c.BaseController.Ctx = ctx
+
+	var l []testFlow
+	if _, count, err := c.BaseGetAll(db, &testFlow{}, &l, nil, nil, nil, nil, 0, 10); err != nil || count != 2 || len(l) != 2 {
+		t.Errorf("GetAll: expect 2 rows of alice, got %d %d %v", count, len(l), err)
+	}
+	req, err := common.ParseListRequest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paged []testFlow
+	page, err := c.BaseGetPage(db, &testFlow{}, &paged, req)
+	if err != nil || *page.Total != 2 || len(paged) != 2 {
+		t.Errorf("GetPage: expect 2 rows of alice, got %v %d %v", page, len(paged), err)
+	}
+	for _, f := range append(l, paged...) {
+		if f.Creator != "alice" {
+			t.Errorf("unexpected row of %s", f.Creator)
+		}
+	}
+}
+
+func Test_BaseUpdateAudit(t *testing.T) {
+	db := testdb.New(t, &testFlow{})
+	beego.AppConfig.Set("OpLog::Audit", "true")
+	defer beego.AppConfig.Set("OpLog::Audit", "false")
+	f := &testFlow{Title: "alpha", Tags: "a"}
+	if err := db.Create(f).Error; err != nil {
+		t.Fatal(err)
+	}
+	f.Title, f.Tags = "beta", "b"
+	// 只更新 title，tags 的内存值不算变更
+	if _, err := f.BaseUpdate(db, f, []string{"title"}); err != nil {
+		t.Fatal(err)
+	}
+	changes := f.Changes()
+	if len(changes) != 1 || changes[0].Field != "title" || changes[0].Old != "alpha" || changes[0].New != "beta" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/astaxie/beego/context"
 	"github.com/astaxie/beego/logs"
 	"github.com/daimall/tools/curd/common"
 	"github.com/daimall/tools/curd/dbmysql/dbgorm"
@@ -25,6 +26,8 @@ type CommFlow struct {
 	UpdatedAt time.Time `json:"updated_at"` // 最后更新时间
 
 	BaseController common.BaseController `gorm:"-" json:"-"` //
+
+	changes []oplog.Change // BaseUpdate 记录的字段变更
 }
 
 // 获取ID
@@ -37,6 +40,11 @@ func (s *CommFlow) GetFlowName() string {
 	return ""
 }
 
+// 当前步骤的标识（cur_step）
+func (f *CommFlow) GetCurStepKey() string {
+	return f.CurStep
+}
+
 // 获取某个属性值（供step跳转使用）
 func (f *CommFlow) GetValue(flow FlowService, attr string) reflect.Value {
 	v := reflect.ValueOf(flow)
@@ -72,13 +80,12 @@ func (x *CommFlow) GoNext(tx *gorm.DB, flow FlowService, tblName, serviceName st
 		step                FlowStep
 		remainCheckHandlers []CheckHandler
 	)
-	if curapp, ok := flow.(GetCurStepInf); ok {
-		if step, err = curapp.GetCurStep(); err != nil {
-			logs.Error("get curstep failed,", err.Error())
-			return
-		}
-	} else {
-		err = fmt.Errorf("GetCurStepInf is not implement")
+	def := definitionOf(flow, serviceName)
+	if x.CurStep == StepParallel {
+		return nil, fmt.Errorf("flow %d is in parallel steps, use GoNextFrom", flow.GetID())
+	}
+	if step, err = x.curStep(flow, def); err != nil {
+		logs.Error("get curstep failed,", err.Error())
 		return
 	}
 
@@ -88,50 +95,41 @@ func (x *CommFlow) GoNext(tx *gorm.DB, flow FlowService, tblName, serviceName st
 		logs.Error("LoadHandlers failed,", err.Error(), flow.GetID(), step.Key())
 		return
 	}
-	var total = len(curStepHandlers)
-	var pass, nopass int
-	for _, record := range curStepHandlers {
-		if record.IsFinish() && record.GetConclusion() {
-			pass++
-		}
-		if record.IsFinish() && !record.GetConclusion() {
-			nopass++
-		}
-	}
+	goNext, goBack := checkPass(step, curStepHandlers)
 	// 部分责任人完成处理，只保存处理结果，不跳转
-	if pass*100/total < step.PassRate() && nopass*100/total <= 100-step.PassRate() {
+	if !goNext && !goBack {
 		logs.Debug("check one but not enough")
 		return
 	}
 	var next FlowStep
 	var preStep = false
 	// 判断满足通过率
-	if pass*100/total >= step.PassRate() {
-		if napp, ok := flow.(GetNextStepInf); ok {
-			if next, err = napp.GetNextStep(); err != nil {
+	if goNext {
+		if def != nil && def.parallel {
+			// 并行分支
+			var t *Transition
+			if t, err = def.nextTransition(flow, x.CurStep); err != nil {
 				logs.Error("GetNextStep failed,", err.Error())
 				return
 			}
-		} else {
-			err = fmt.Errorf("GetNextStepInf is not implement")
+			if len(t.Parallel) > 0 {
+				err = x.fork(tx, flow, def, serviceName, x.CurStep, t.Parallel)
+				return
+			}
+		}
+		if next, err = x.nextStep(flow, def); err != nil {
+			logs.Error("GetNextStep failed,", err.Error())
 			return
 		}
-
 	}
 	// 已经不满足通过率
-	if nopass*100/total > 100-step.PassRate() {
+	if goBack {
 		// 退回流程
-		if papp, ok := flow.(GetPreStepInf); ok {
-			if next, err = papp.GetPreStep(); err != nil {
-				logs.Error("GetPreStep failed,", err.Error())
-				return
-			}
-			preStep = true
-		} else {
-			err = fmt.Errorf("GetPreStepInf is not implement")
+		if next, err = x.preStep(flow, def); err != nil {
+			logs.Error("GetPreStep failed,", err.Error())
 			return
 		}
-
+		preStep = true
 	}
 	if next == nil {
 		// 最后一步
@@ -157,13 +155,8 @@ func (x *CommFlow) GoNext(tx *gorm.DB, flow FlowService, tblName, serviceName st
 					return
 				}
 			}
-			if curapp, ok := flow.(GetCurStepInf); ok {
-				if curStep, err = curapp.GetCurStep(); err != nil {
-					logs.Error("get cur step failed,", err.Error())
-					return
-				}
-			} else {
-				err = fmt.Errorf("GetCurStepInf is not implement")
+			if curStep, err = x.curStep(flow, def); err != nil {
+				logs.Error("get cur step failed,", err.Error())
 				return
 			}
 
@@ -205,6 +198,60 @@ func (x *CommFlow) GoNext(tx *gorm.DB, flow FlowService, tblName, serviceName st
 	return
 }
 
+// service 的流程定义，按注册名称查找，找不到时按流程名称
+func definitionOf(flow FlowService, serviceName string) *FlowDefinition {
+	if def := GetDefinition(serviceName); def != nil {
+		return def
+	}
+	return GetDefinition(flow.GetFlowName())
+}
+
+// CurStepOf 流程的当前步骤，有流程定义时按定义（service 需要嵌入 CommFlow），否则使用 service 实现的 GetCurStepInf
+func CurStepOf(flow FlowService, serviceName string) (step FlowStep, err error) {
+	if kapp, ok := flow.(GetCurStepKeyInf); ok {
+		if def := definitionOf(flow, serviceName); def != nil {
+			return def.Step(kapp.GetCurStepKey())
+		}
+	}
+	if curapp, ok := flow.(GetCurStepInf); ok {
+		return curapp.GetCurStep()
+	}
+	return nil, fmt.Errorf("GetCurStepInf is not implement")
+}
+
+// 当前步骤，有流程定义时按定义，否则使用 service 实现的 GetCurStepInf
+func (x *CommFlow) curStep(flow FlowService, def *FlowDefinition) (step FlowStep, err error) {
+	if def != nil {
+		return def.Step(x.CurStep)
+	}
+	if curapp, ok := flow.(GetCurStepInf); ok {
+		return curapp.GetCurStep()
+	}
+	return nil, fmt.Errorf("GetCurStepInf is not implement")
+}
+
+// 下一步，有流程定义时按定义的流转条件，否则使用 service 实现的 GetNextStepInf
+func (x *CommFlow) nextStep(flow FlowService, def *FlowDefinition) (step FlowStep, err error) {
+	if def != nil {
+		return def.NextStep(flow, x.CurStep)
+	}
+	if napp, ok := flow.(GetNextStepInf); ok {
+		return napp.GetNextStep()
+	}
+	return nil, fmt.Errorf("GetNextStepInf is not implement")
+}
+
+// 退回的步骤，有流程定义时按定义，否则使用 service 实现的 GetPreStepInf
+func (x *CommFlow) preStep(flow FlowService, def *FlowDefinition) (step FlowStep, err error) {
+	if def != nil {
+		return def.RejectStep(x.CurStep)
+	}
+	if papp, ok := flow.(GetPreStepInf); ok {
+		return papp.GetPreStep()
+	}
+	return nil, fmt.Errorf("GetPreStepInf is not implement")
+}
+
 // 获取上一步
 func (f *CommFlow) GetPreStep(steps map[string]FlowStep) (step FlowStep, err error) {
 	if f.CurStep == "" {
@@ -252,14 +299,17 @@ func (f *CommFlow) GetNextStep(steps map[string]FlowStep, firstStep ...FlowStep)
 			return steps[keys[i+1]], nil
 		}
 	}
-	return nil, fmt.Errorf("step[%s] not found", f.CurStep)
+	return nil, common.StepTypeNotFound.WithParams(map[string]interface{}{"step": f.CurStep})
 }
 
 // 获取操作状态（含历史）
 func (f *CommFlow) OpHistory(offset, limit int, name, tblName string) (ret interface{}, err error) {
 	handlers := []CheckHandler{}
-	g := dbgorm.GetDBInst()
-	g = g.Table(tblName).Where("service_id = ?", f.GetID()).
+	g, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		return
+	}
+	g = f.readDB(g, nil).Table(tblName).Where("service_id = ?", f.GetID()).
 		Where("service = ?", name)
 	if limit != 0 {
 		g = g.Offset(offset).Limit(limit)
@@ -274,8 +324,11 @@ func (f *CommFlow) OpHistory(offset, limit int, name, tblName string) (ret inter
 // 获取下一步
 func (f *CommFlow) OpLogHistory(offset, limit int, name, tblName string) (ret interface{}, err error) {
 	handlers := []oplog.OpLog{}
-	g := dbgorm.GetDBInst()
-	g = g.Table(tblName).Where("flow_id = ?", f.GetID()).
+	g, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		return
+	}
+	g = f.readDB(g, nil).Table(tblName).Where("flow_id = ?", f.GetID()).
 		Where("flow = ?", name)
 	if limit != 0 {
 		g = g.Offset(offset).Limit(limit)
@@ -290,7 +343,10 @@ func (f *CommFlow) OpLogHistory(offset, limit int, name, tblName string) (ret in
 // 获取上一步的handlerid，给部分退回时使用
 func (f *CommFlow) GetStepHandlers(name, tblName, stepName string) (ret interface{}, err error) {
 	handlers := []CheckHandler{}
-	g := dbgorm.GetDBInst()
+	g, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		return
+	}
 	g = g.Table(tblName).Where("service_id = ?", f.GetID()).
 		Where("service = ?", name).Where("step = ?", stepName).Where("conclusion <> ?", 0)
 	if err = g.Find(&handlers).Error; err != nil {
@@ -303,3 +359,20 @@ func (f *CommFlow) GetStepHandlers(name, tblName, stepName string) (ret interfac
 func (f *CommFlow) SetBaseController(c common.BaseController) {
 	f.BaseController = c
 }
+
+// 最近一次 BaseUpdate 的字段变更（开启审计时），实现 ChangesInf
+func (f *CommFlow) Changes() []oplog.Change {
+	return f.changes
+}
+
+// 只读查询（列表、历史），配置了副本时走副本，绑定请求的context以便写后读走主库
+// ctx 为空时使用 SetBaseController 设置的请求（flowcontroller 每个请求一个实例）
+func (f *CommFlow) readDB(db *gorm.DB, ctx *context.Context) *gorm.DB {
+	if ctx == nil {
+		ctx = f.BaseController.Ctx
+	}
+	if ctx != nil && ctx.Request != nil {
+		db = db.WithContext(ctx.Request.Context())
+	}
+	return dbgorm.ReadReplica(db)
+}
@@ -3,6 +3,7 @@ package flowservice
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/astaxie/beego/logs"
 	"gorm.io/gorm"
@@ -17,6 +18,8 @@ type CommStep struct {
 	LoadConfig         func(flow FlowService) []ConfigPage
 	LoadJSONConfig     func(flow FlowService) interface{}                      // json生成的map嵌套对象
 	CancelDo           func(tx *gorm.DB, flowId uint, flow string) (err error) // 返回当前部署时，清空数据
+
+	deadline *Deadline // 处理时限，只能在流程定义中设置（见 StepDef.Deadline），为空表示不限
 }
 
 func (s *CommStep) Key() (stepKey string) {
@@ -44,9 +47,13 @@ func (s *CommStep) LoadHandlers(tx *gorm.DB, flowId uint) (handlers []FlowHandle
 	return s.Handler.LoadStepHandlers(tx, flowId, s.Key())
 }
 
-// handlers 入库
+// handlers 入库，步骤有处理时限时设置到期时间
 func (s *CommStep) AddHandlers(tx *gorm.DB, handlers []FlowHandler) (err error) {
 	for _, handler := range handlers {
+		if h, ok := handler.(*CheckHandler); ok && s.deadline != nil && h.DueAt == nil {
+			due := s.deadline.Due(time.Now())
+			h.DueAt = &due
+		}
 		if err = tx.Table(s.Hander().TableName()).Create(handler).Error; err != nil {
 			logs.Error("create handler failed,", err.Error())
 			return
@@ -77,14 +84,9 @@ func (s *CommStep) GetDefaultHandlers(flow FlowService) (handers string) {
 	var ret = []string{}
 	keys := strings.Split(s.HandlersInFlowAttr, ",")
 	for _, key := range keys {
-		if gvapp, ok := flow.(GetValueInf); ok {
-			refv := gvapp.GetValue(key)
-			if refv.IsValid() {
-				v := refv.String()
-				ret = append(ret, v)
-			}
-		} else {
-			logs.Error("GetValueInf is not implement")
+		// 未实现 GetValueInf 时直接按属性名读取
+		if refv := flowValue(flow, key); refv.IsValid() {
+			ret = append(ret, refv.String())
 		}
 	}
 	handers = strings.Join(ret, ",")
@@ -0,0 +1,345 @@
+package flowservice
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/curd/oplog"
+	"github.com/daimall/tools/curd/rbac"
+	"github.com/daimall/tools/email"
+	"gorm.io/gorm"
+)
+
+// 超时后的处理
+const (
+	TimeoutReject = "reject"  // 自动拒绝（按步骤的通过率退回）
+	TimeoutState  = "timeout" // 流程进入超时状态（FlowStateTimeOut）
+)
+
+// DeadlineOperator 时限处理记录操作日志使用的用户
+const DeadlineOperator = "system"
+
+// Deadline 步骤的处理时限（SLA），处理人创建时计算到期时间（CheckHandler.DueAt），由 ScanDeadlines 检查
+// 只能在流程定义的步骤中设置（校验定义时解析时长），没有流程定义的服务不检查时限
+//
+//	deadline: {sla: 16h, business: true, remind: 4h, escalate: "role:legal-lead,boss", onTimeout: reject}
+type Deadline struct {
+	SLA       string `yaml:"sla" json:"sla"`             // 时限，如 48h 2d
+	Business  bool   `yaml:"business" json:"business"`   // 按工作时间计算，见 CalendarFromConf
+	Remind    string `yaml:"remind" json:"remind"`       // 到期前多久提醒处理人，为空不提醒
+	Escalate  string `yaml:"escalate" json:"escalate"`   // 超时后通知的用户，多个逗号分隔，role:xx 表示角色下的所有用户
+	OnTimeout string `yaml:"onTimeout" json:"onTimeout"` // 超时后的处理：为空只通知，reject 自动拒绝，timeout 流程超时
+
+	sla, remind time.Duration
+}
+
+// 校验并解析时长
+func (d *Deadline) parse() (err error) {
+	if d.sla, err = oplog.ParseDuration(d.SLA); err != nil || d.sla <= 0 {
+		return fmt.Errorf("invalid sla %q", d.SLA)
+	}
+	if d.Remind != "" {
+		if d.remind, err = oplog.ParseDuration(d.Remind); err != nil || d.remind <= 0 {
+			return fmt.Errorf("invalid remind %q", d.Remind)
+		}
+	}
+	if d.OnTimeout != "" && d.OnTimeout != TimeoutReject && d.OnTimeout != TimeoutState {
+		return fmt.Errorf("invalid onTimeout %s", d.OnTimeout)
+	}
+	return nil
+}
+
+// Due 从 from 开始计算的到期时间
+func (d *Deadline) Due(from time.Time) time.Time {
+	if !d.Business {
+		return from.Add(d.sla)
+	}
+	cal, err := CalendarFromConf()
+	if err != nil {
+		logs.Error("load calendar failed, use default,", err.Error())
+		cal = DefaultCalendar()
+	}
+	return cal.Add(from, d.sla)
+}
+
+// UserEmail 用户的邮箱地址，默认为包含@的用户名，或者用户名加 Flow::EmailDomain，返回空时不发送
+var UserEmail = func(user string) string {
+	if strings.Contains(user, "@") {
+		return user
+	}
+	if domain := beego.AppConfig.String("Flow::EmailDomain"); domain != "" {
+		return user + "@" + domain
+	}
+	return ""
+}
+
+// 发送邮件（测试时替换）
+var sendMail = func(to []string, subject, body string) error {
+	return email.New(beego.AppConfig.DefaultString("Flow::EmailSender", "flow"), subject, body,
+		strings.Join(to, ";")).LoadBeegoConf().Send()
+}
+
+// 用户都没有邮箱地址，重试也不会发送成功
+var errNoAddress = errors.New("no email address")
+
+// 给用户发送邮件，没有邮箱的用户忽略，都没有邮箱时返回 errNoAddress
+func notify(users []string, subject, body string) (err error) {
+	var to []string
+	for _, u := range users {
+		if addr := UserEmail(u); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if len(to) == 0 {
+		logs.Warn("no email address of %v, skip mail %s", users, subject)
+		return errNoAddress
+	}
+	if err = sendMail(to, subject, body); err != nil {
+		logs.Error("send mail to %v failed, %s", to, err.Error())
+	}
+	return
+}
+
+// 超时通知的用户
+func escalateUsers(db *gorm.DB, escalate string) (users []string, err error) {
+	for _, item := range strings.Split(escalate, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if strings.HasPrefix(item, "role:") {
+			var roleUsers []string
+			if roleUsers, err = rbac.UsersInRole(db, strings.TrimPrefix(item, "role:")); err != nil {
+				return
+			}
+			users = append(users, roleUsers...)
+		} else {
+			users = append(users, item)
+		}
+	}
+	return
+}
+
+// 记录操作日志，service 实现 OplogModelInf 时使用其日志对象
+func addDeadlineLog(db *gorm.DB, serviceName string, flowId uint, action, remark string) {
+	var logModel interface{}
+	if logService, ok := services[serviceName].(OplogModelInf); ok {
+		logModel = logService.OplogModel(DeadlineOperator, serviceName, flowId, action, remark)
+	}
+	if logModel == nil {
+		logModel = &oplog.OpLog{User: DeadlineOperator, Action: action, FlowId: flowId, Flow: serviceName, Remark: remark}
+	}
+	oplog.AddOperationLog(db, logModel)
+}
+
+// ScanDeadlines 检查流程定义中设置了时限的步骤：到期前提醒处理人，超时后通知升级用户并按 OnTimeout 处理
+// 每条处理记录先按条件更新（reminded_at、escalated_at 为空）认领后再处理，多个实例同时执行时不会重复通知
+func ScanDeadlines(db *gorm.DB, now time.Time) (err error) {
+	names := make([]string, 0, len(definitions))
+	for name := range definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		def := definitions[name]
+		for _, s := range def.Steps {
+			if s.Deadline == nil {
+				continue
+			}
+			tbl := def.steps[s.Key].Hander().TableName()
+			if err = remindStep(db, tbl, name, s.Key, s.Deadline, now); err != nil {
+				return
+			}
+			if err = escalateStep(db, tbl, name, s.Key, s.Deadline, now); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// 到期前提醒处理人，发送失败时下次重试
+func remindStep(db *gorm.DB, tbl, serviceName, stepKey string, d *Deadline, now time.Time) (err error) {
+	if d.remind <= 0 {
+		return
+	}
+	var handlers []CheckHandler
+	if err = db.Table(tbl).Where("service = ? and step = ? and conclusion = 0 and reminded_at is null and escalated_at is null and due_at > ? and due_at <= ?",
+		serviceName, stepKey, now, now.Add(d.remind)).Order("id").Find(&handlers).Error; err != nil {
+		logs.Error("query handlers to remind failed,", err.Error())
+		return
+	}
+	for _, h := range handlers {
+		var claimed bool
+		if claimed, err = claimHandlers(db, tbl, "reminded_at", []uint{h.ID}, now); err != nil {
+			return
+		}
+		if !claimed {
+			// 其他实例已提醒
+			continue
+		}
+		subject := fmt.Sprintf("[%s] %d 步骤 %s 即将到期", serviceName, h.ServiceId, stepKey)
+		body := fmt.Sprintf("%s 您好，流程 %s %d 的步骤 %s 将于 %s 到期，请尽快处理。",
+			h.User, serviceName, h.ServiceId, stepKey, h.DueAt.Format("2006-01-02 15:04"))
+		if nerr := notify([]string{h.User}, subject, body); errors.Is(nerr, errNoAddress) {
+			// 没有邮箱地址时保留认领，不再重试
+			continue
+		} else if nerr != nil {
+			// 发送失败时取消认领，下次重试
+			if err = db.Table(tbl).Where("id = ?", h.ID).Update("reminded_at", nil).Error; err != nil {
+				logs.Error("reset reminded_at failed,", err.Error())
+				return
+			}
+			continue
+		}
+		addDeadlineLog(db, serviceName, h.ServiceId, oplog.OP_ACTION_REMIND, fmt.Sprintf("remind %s of step %s", h.User, stepKey))
+	}
+	return
+}
+
+// 超时：通知升级用户，按 OnTimeout 自动拒绝或者流程超时
+func escalateStep(db *gorm.DB, tbl, serviceName, stepKey string, d *Deadline, now time.Time) (err error) {
+	var handlers []CheckHandler
+	if err = db.Table(tbl).Where("service = ? and step = ? and conclusion = 0 and escalated_at is null and due_at <= ?",
+		serviceName, stepKey, now).Order("service_id, id").Find(&handlers).Error; err != nil {
+		logs.Error("query overdue handlers failed,", err.Error())
+		return
+	}
+	for len(handlers) > 0 {
+		// 按流程处理
+		n := 1
+		for n < len(handlers) && handlers[n].ServiceId == handlers[0].ServiceId {
+			n++
+		}
+		flowId, overdue := handlers[0].ServiceId, handlers[:n]
+		handlers = handlers[n:]
+		var users []string
+		ids := make([]uint, 0, len(overdue))
+		for _, h := range overdue {
+			users = append(users, h.User)
+			ids = append(ids, h.ID)
+		}
+		// 认领和超时处理在同一个事务中，处理失败时回滚，下次重试
+		if err = db.Transaction(func(tx *gorm.DB) error {
+			claimed, err := claimHandlers(tx, tbl, "escalated_at", ids, now)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				return errClaimed
+			}
+			if d.OnTimeout == "" {
+				return nil
+			}
+			return timeoutFlow(tx, tbl, serviceName, stepKey, flowId, ids, d.OnTimeout)
+		}); err != nil {
+			if !errors.Is(err, errClaimed) {
+				// 单个流程处理失败不影响其他流程
+				logs.Error("timeout flow %s %d failed, %s", serviceName, flowId, err.Error())
+			}
+			err = nil
+			continue
+		}
+		remark := fmt.Sprintf("step %s overdue, handlers %s", stepKey, strings.Join(users, ","))
+		if d.Escalate != "" {
+			var to []string
+			if to, err = escalateUsers(db, d.Escalate); err != nil {
+				logs.Error("get escalate users failed,", err.Error())
+				return
+			}
+			subject := fmt.Sprintf("[%s] %d 步骤 %s 已超时", serviceName, flowId, stepKey)
+			body := fmt.Sprintf("流程 %s %d 的步骤 %s 已于 %s 超时，处理人：%s。",
+				serviceName, flowId, stepKey, overdue[0].DueAt.Format("2006-01-02 15:04"), strings.Join(users, ","))
+			if notify(to, subject, body) == nil {
+				remark += ", escalated to " + strings.Join(to, ",")
+			}
+		}
+		addDeadlineLog(db, serviceName, flowId, oplog.OP_ACTION_ESCALATE, remark)
+		if d.OnTimeout != "" {
+			addDeadlineLog(db, serviceName, flowId, oplog.OP_ACTION_TIMEOUT, fmt.Sprintf("step %s %s", stepKey, d.OnTimeout))
+		}
+	}
+	return
+}
+
+// 其他实例已认领
+var errClaimed = errors.New("handlers claimed by another scan")
+
+// 认领处理记录：column 为空时更新为 now，全部更新成功才算认领（其他实例已认领时返回 false）
+func claimHandlers(db *gorm.DB, tbl, column string, ids []uint, now time.Time) (claimed bool, err error) {
+	ret := db.Table(tbl).Where("id in (?) and "+column+" is null", ids).Update(column, now)
+	if err = ret.Error; err != nil {
+		logs.Error("update %s failed, %s", column, err.Error())
+		return
+	}
+	return ret.RowsAffected == int64(len(ids)), nil
+}
+
+// 超时的处理：reject 拒绝超时的处理记录后流转，timeout 删除未处理的记录，流程进入超时状态
+func timeoutFlow(tx *gorm.DB, tbl, serviceName, stepKey string, flowId uint, ids []uint, onTimeout string) (err error) {
+	service, ok := services[serviceName]
+	if !ok {
+		return fmt.Errorf("service %s not registered", serviceName)
+	}
+	var flow FlowService
+	if flow, err = service.LoadInst(flowId); err != nil {
+		return
+	}
+	if flow == nil {
+		return fmt.Errorf("flow %s %d not found", serviceName, flowId)
+	}
+	if onTimeout == TimeoutReject {
+		if err = tx.Table(tbl).Where("id in (?)", ids).
+			Updates(map[string]interface{}{"conclusion": ConclusionReject, "remark": "timeout"}).Error; err != nil {
+			return
+		}
+		if napp, ok := flow.(GoNextFromInf); ok {
+			_, err = napp.GoNextFrom(tx, stepKey, nil)
+		} else if napp, ok := flow.(GoNextInf); ok {
+			_, err = napp.GoNext(tx, nil)
+		} else {
+			err = fmt.Errorf("GoNextInf is not implement")
+		}
+		return
+	}
+	if err = tx.Table(tbl).Where("service = ? and service_id = ? and conclusion = 0", serviceName, flowId).
+		Delete(&CheckHandler{}).Error; err != nil {
+		return
+	}
+	if err = tx.Where("service = ? and service_id = ?", serviceName, flowId).Delete(&ActiveStep{}).Error; err != nil {
+		return
+	}
+	return tx.Model(flow).Updates(map[string]interface{}{"cur_step": "-", "state": FlowStateTimeOut}).Error
+}
+
+// StartDeadlines 按间隔（Flow::DeadlineInterval，默认5m）执行 ScanDeadlines，返回停止函数
+func StartDeadlines(db *gorm.DB) (stop func()) {
+	interval := 5 * time.Minute
+	if v := beego.AppConfig.String("Flow::DeadlineInterval"); v != "" {
+		if d, err := oplog.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		} else {
+			logs.Error("invalid Flow::DeadlineInterval %s, use %s", v, interval)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ScanDeadlines(db, time.Now()); err != nil {
+					logs.Error("scan flow deadlines failed,", err.Error())
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
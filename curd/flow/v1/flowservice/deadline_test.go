@@ -0,0 +1,219 @@
+package flowservice
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/common"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+	"github.com/daimall/tools/curd/oplog"
+	"github.com/daimall/tools/curd/rbac"
+	"gorm.io/gorm"
+)
+
+func Test_CalendarAdd(t *testing.T) {
+	beego.AppConfig.Set("Flow::Holidays", "2022-10-03")
+	beego.AppConfig.Set("Flow::ExtraWorkdays", "2022-10-08")
+	defer beego.AppConfig.Set("Flow::Holidays", "")
+	defer beego.AppConfig.Set("Flow::ExtraWorkdays", "")
+	cal, err := CalendarFromConf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		from, due string
+		d         time.Duration
+	}{
+		{"2022-09-29 10:00", "2022-09-29 14:00", 4 * time.Hour},
+		{"2022-09-29 20:00", "2022-09-30 10:00", time.Hour},
+		{"2022-09-30 16:00", "2022-10-04 11:00", 4 * time.Hour}, // 周末和节假日
+		{"2022-10-07 17:00", "2022-10-08 10:00", 2 * time.Hour}, // 调休上班
+	} {
+		from, _ := time.ParseInLocation("2006-01-02 15:04", c.from, time.Local)
+		if due := cal.Add(from, c.d).Format("2006-01-02 15:04"); due != c.due {
+			t.Errorf("%s + %s: expect %s, got %s", c.from, c.d, c.due, due)
+		}
+	}
+}
+
+const leaveDefinition = `
+name: leave
+steps:
+  - key: apply
+    handlers: Creator
+    next: [{to: approve}]
+  - key: approve
+    handlers: Manager
+    reject: apply
+    deadline: {sla: 2h, remind: 1h, escalate: "role:hr,boss", onTimeout: reject}
+    next: [{to: review}]
+  - key: review
+    handlers: Reviewer
+    deadline: {sla: 1h, onTimeout: timeout}
+    next: [{to: end}]
+`
+
+type leaveFlow struct {
+	CommFlow
+	Manager  string `gorm:"size:100" json:"manager"`
+	Reviewer string `gorm:"size:100" json:"reviewer"`
+
+	def *FlowDefinition `gorm:"-"`
+}
+
+func (f *leaveFlow) GetFlowName() string { return "leave" }
+func (f *leaveFlow) New(uname string, c common.BaseController) (uint, interface{}, string, error) {
+	return 0, nil, "", nil
+}
+func (f *leaveFlow) NewInst() FlowService            { return &leaveFlow{} }
+func (f *leaveFlow) FlowDefinition() *FlowDefinition { return f.def }
+func (f *leaveFlow) LoadInst(id uint) (FlowService, error) {
+	db, err := dbgorm.Get(dbgorm.DefaultName)
+	if err != nil {
+		return nil, err
+	}
+	flow := &leaveFlow{}
+	return flow, db.First(flow, id).Error
+}
+func (f *leaveFlow) GoNext(tx *gorm.DB, remainingIds []uint) ([]FlowHandler, error) {
+	return f.CommFlow.GoNext(tx, f, "", "leave", remainingIds)
+}
+
+func Test_ScanDeadlines(t *testing.T) {
+	def, err := LoadDefinition([]byte(strings.Replace(leaveDefinition, "sla: 2h", "sla: 0h", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = def.Validate(&leaveFlow{}); err == nil {
+		t.Error("expect invalid sla error")
+	}
+
+	models := append([]interface{}{&leaveFlow{}, &CheckHandler{}, &ActiveStep{}, &oplog.OpLog{}}, rbac.Models()...)
+	db := testdb.New(t, models...)
+	if def, err = LoadDefinition([]byte(leaveDefinition)); err != nil {
+		t.Fatal(err)
+	}
+	Register("leave", &leaveFlow{def: def})
+	rbac.CreateRole(db, "hr", "")
+	rbac.AssignRole(db, "helen", "hr")
+	beego.AppConfig.Set("Flow::EmailDomain", "example.com")
+	defer beego.AppConfig.Set("Flow::EmailDomain", "")
+	var mails []string
+	defer func(send func(to []string, subject, body string) error) { sendMail = send }(sendMail)
+	sendMail = func(to []string, subject, body string) error {
+		mails = append(mails, strings.Join(to, ";"))
+		return nil
+	}
+
+	start := time.Now()
+	newFlow := func(step string) *leaveFlow {
+		f := &leaveFlow{Manager: "mike", Reviewer: "rita"}
+		f.CurStep, f.State, f.Creator = step, FlowStateRunning, "alice"
+		db.Create(f)
+		s, _ := def.Step(step)
+		user := f.Manager
+		if step == "review" {
+			user = f.Reviewer
+		}
+		if err := s.AddHandlers(db, []FlowHandler{&CheckHandler{User: user, Step: step, ServiceId: f.ID, ServiceName: "leave"}}); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	approve, review := newFlow("approve"), newFlow("review")
+	var h CheckHandler
+	db.Where("service_id = ? and step = ?", approve.ID, "approve").First(&h)
+	if h.DueAt == nil || h.DueAt.Sub(start) < 2*time.Hour || h.DueAt.Sub(start) > 2*time.Hour+time.Minute {
+		t.Fatalf("unexpected due time %v", h.DueAt)
+	}
+
+	// 到期前1小时内提醒，只提醒一次
+	for i := 0; i < 2; i++ {
+		if err = ScanDeadlines(db, start.Add(90*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(mails, "|") != "mike@example.com" {
+		t.Fatalf("expect one reminder to mike, got %v", mails)
+	}
+
+	// 超时：通知 hr 角色和 boss，approve 自动拒绝退回 apply，review 流程超时
+	mails = nil
+	if err = ScanDeadlines(db, start.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(mails, "|") != "helen@example.com;boss@example.com" {
+		t.Errorf("expect escalation to helen and boss, got %v", mails)
+	}
+	// 退回时清空 approve 的处理记录，添加 apply 的处理人
+	var saved, timeout leaveFlow
+	db.First(&saved, approve.ID)
+	var steps []string
+	db.Model(&CheckHandler{}).Where("service_id = ?", approve.ID).Pluck("step", &steps)
+	if saved.CurStep != "apply" || strings.Join(steps, ",") != "apply" {
+		t.Errorf("expect approve rejected to apply, got %s %v", saved.CurStep, steps)
+	}
+	db.First(&timeout, review.ID)
+	var pending int64
+	db.Model(&CheckHandler{}).Where("service_id = ? and conclusion = 0", review.ID).Count(&pending)
+	if timeout.State != FlowStateTimeOut || timeout.CurStep != "-" || pending != 0 {
+		t.Errorf("expect review flow timeout, got %s %d %d", timeout.CurStep, timeout.State, pending)
+	}
+
+	if err = oplog.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	db.Model(&oplog.OpLog{}).Order("id").Pluck("action", &actions)
+	if strings.Join(actions, ",") != "remind,escalate,timeout,escalate,timeout" {
+		t.Errorf("unexpected op logs %v", actions)
+	}
+
+	// 超时处理失败（流程已删除）时回滚认领，不通知，下次重试
+	mails = nil
+	lost := newFlow("approve")
+	db.Delete(&leaveFlow{}, lost.ID)
+	for i := 0; i < 2; i++ {
+		if err = ScanDeadlines(db, start.Add(6*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Where("service_id = ? and step = ?", lost.ID, "approve").First(&h)
+	if h.EscalatedAt != nil || len(mails) != 0 {
+		t.Errorf("expect unclaimed handler without mails, got %v %v", h.EscalatedAt, mails)
+	}
+	// 已被其他实例认领
+	if claimed, err := claimHandlers(db, "step_handlers", "escalated_at", []uint{h.ID}, start); err != nil || !claimed {
+		t.Fatalf("expect claimed, got %v %v", claimed, err)
+	}
+	if claimed, err := claimHandlers(db, "step_handlers", "escalated_at", []uint{h.ID}, start); err != nil || claimed {
+		t.Errorf("expect claimed by another scan, got %v %v", claimed, err)
+	}
+
+	// 没有邮箱地址时按已提醒处理，不重复提醒
+	defer func(email func(string) string) { UserEmail = email }(UserEmail)
+	UserEmail = func(string) string { return "" }
+	mails = nil
+	nomail := newFlow("approve")
+	now := time.Now().Add(90 * time.Minute)
+	if err = ScanDeadlines(db, now); err != nil {
+		t.Fatal(err)
+	}
+	h = CheckHandler{}
+	db.Where("service_id = ? and step = ?", nomail.ID, "approve").First(&h)
+	if h.RemindedAt == nil || len(mails) != 0 {
+		t.Fatalf("expect reminder claimed without mails, got %v %v", h.RemindedAt, mails)
+	}
+	remindedAt := *h.RemindedAt
+	if err = ScanDeadlines(db, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	db.Where("service_id = ? and step = ?", nomail.ID, "approve").First(&h)
+	if h.RemindedAt == nil || !h.RemindedAt.Equal(remindedAt) {
+		t.Errorf("expect no retry of reminder, got %v", h.RemindedAt)
+	}
+}
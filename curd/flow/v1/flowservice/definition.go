@@ -0,0 +1,431 @@
+package flowservice
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/daimall/tools/conditions/stringmatch"
+	"gopkg.in/yaml.v2"
+)
+
+// StepEnd 流转到结束（流程完成）
+const StepEnd = "end"
+
+// FlowDefinition 声明式的流程定义（YAML/JSON），service 实现 DefinitionInf 后在 Register 时校验，
+// GoNext 按定义的流转关系跳转，不再依赖步骤key的排序
+//
+//	name: purchase
+//	start: apply
+//	conditions:
+//	  big: {field: Amount, op: ">=", value: 10000}
+//	  urgent: {field: Level, op: in, value: "high,critical"}
+//	steps:
+//	  - key: apply
+//	    handlers: Creator
+//	    next:
+//	      - {to: director, when: big AND urgent}
+//	      - {to: manager}
+//	  - key: manager
+//	    handlers: Manager
+//	    passRate: 100
+//	    reject: apply
+//	    next: [{parallel: [legal, finance]}]
+//	  - {key: legal, handlers: Legal, reject: apply, next: [{to: sign}]}
+//	  - {key: finance, handlers: Finance, reject: apply, next: [{to: sign}]}
+//	  - {key: sign, handlers: Signer, join: all, next: [{to: end}]}
+//
+// 并行分支见 GoNextFrom，不支持分支中再次并行
+type FlowDefinition struct {
+	Name       string               `yaml:"name" json:"name"`
+	Start      string               `yaml:"start" json:"start"` // 第一步，为空时为第一个步骤
+	Conditions map[string]Condition `yaml:"conditions" json:"conditions"`
+	Steps      []StepDefinition     `yaml:"steps" json:"steps"`
+
+	Handler FlowHandler `yaml:"-" json:"-"` // 步骤的处理人记录（为空时使用 CheckHandler），步骤可以单独指定
+
+	steps    map[string]FlowStep // 校验后生成
+	parallel bool                // 有并行分支
+}
+
+// StepDefinition 步骤定义
+type StepDefinition struct {
+	Key      string       `yaml:"key" json:"key"`
+	Handlers string       `yaml:"handlers" json:"handlers"` // 责任人所在的流程属性，多个逗号分隔
+	PassRate int          `yaml:"passRate" json:"passRate"` // 通过率，默认 100
+	Next     []Transition `yaml:"next" json:"next"`         // 按顺序取第一条满足条件的流转
+	Reject   string       `yaml:"reject" json:"reject"`     // 不通过时退回的步骤，为空表示退回草稿
+	Join     string       `yaml:"join" json:"join"`         // 汇合步骤：all 所有分支完成，any 任一分支完成，数字N 完成N个分支
+	Deadline *Deadline    `yaml:"deadline" json:"deadline"` // 处理时限，见 Deadline
+
+	Step FlowStep `yaml:"-" json:"-"` // 自定义的步骤实现（需要 Configs、CancelDo 等时），key 必须一致
+}
+
+// Transition 流转，When 为空表示无条件
+type Transition struct {
+	To       string   `yaml:"to" json:"to"`             // 目标步骤，end 表示结束
+	Parallel []string `yaml:"parallel" json:"parallel"` // 并行的分支（fork），和 To 二选一
+	When     string   `yaml:"when" json:"when"`         // 条件表达式，条件名用 AND OR 和括号组合（stringmatch）
+}
+
+// 流转的目标步骤
+func (t Transition) targets() []string {
+	if len(t.Parallel) > 0 {
+		return t.Parallel
+	}
+	return []string{t.To}
+}
+
+// Condition 对流程属性（GetValue）的比较
+type Condition struct {
+	Field string `yaml:"field" json:"field"` // 属性名，嵌套属性用.分隔
+	Op    string `yaml:"op" json:"op"`       // == != > >= < <= in not_in contains empty not_empty
+	Value string `yaml:"value" json:"value"` // in/not_in 多个值逗号分隔
+}
+
+// DefinitionInf service 的声明式流程定义
+type DefinitionInf interface {
+	FlowDefinition() *FlowDefinition
+}
+
+// LoadDefinition 解析YAML或者JSON格式的流程定义
+func LoadDefinition(data []byte) (def *FlowDefinition, err error) {
+	def = new(FlowDefinition)
+	if err = yaml.Unmarshal(data, def); err != nil {
+		return nil, fmt.Errorf("parse flow definition failed, %s", err.Error())
+	}
+	return
+}
+
+// LoadDefinitionFile 从文件加载流程定义
+func LoadDefinitionFile(path string) (def *FlowDefinition, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	return LoadDefinition(data)
+}
+
+var conditionOps = map[string]bool{
+	"==": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true,
+	"in": true, "not_in": true, "contains": true, "empty": true, "not_empty": true,
+}
+
+// 条件表达式的最大深度
+const conditionStackSize = 64
+
+// Validate 校验流程定义并生成步骤，flow 为流程实例（用于检查条件中的属性），可以为空
+func (d *FlowDefinition) Validate(flow FlowService) (err error) {
+	if len(d.Steps) == 0 {
+		return fmt.Errorf("flow definition %s has no steps", d.Name)
+	}
+	for name, c := range d.Conditions {
+		if !conditionOps[c.Op] {
+			return fmt.Errorf("condition %s: invalid op %s", name, c.Op)
+		}
+		if flow != nil && !hasField(reflect.TypeOf(flow), c.Field) {
+			return fmt.Errorf("condition %s: field %s not found", name, c.Field)
+		}
+	}
+	steps := make(map[string]FlowStep, len(d.Steps))
+	for i, s := range d.Steps {
+		if s.Key == "" || s.Key == StepEnd {
+			return fmt.Errorf("step %d: invalid key %q", i, s.Key)
+		}
+		if _, ok := steps[s.Key]; ok {
+			return fmt.Errorf("duplicate step %s", s.Key)
+		}
+		if s.PassRate < 0 || s.PassRate > 100 {
+			return fmt.Errorf("step %s: pass rate must be 0-100", s.Key)
+		}
+		if _, err = joinCount(s.Join, 1); err != nil {
+			return fmt.Errorf("step %s: %s", s.Key, err.Error())
+		}
+		if s.Deadline != nil {
+			if err = s.Deadline.parse(); err != nil {
+				return fmt.Errorf("step %s: %s", s.Key, err.Error())
+			}
+		}
+		step := s.Step
+		if step == nil {
+			handler := d.Handler
+			if handler == nil {
+				handler = &CheckHandler{}
+			}
+			step = &CommStep{KeyId: s.Key, Rate: s.PassRate, Handler: handler, HandlersInFlowAttr: s.Handlers, deadline: s.Deadline}
+		} else if step.Key() != s.Key {
+			return fmt.Errorf("step %s: key of custom step is %s", s.Key, step.Key())
+		} else if cs, ok := step.(*CommStep); ok {
+			cs.deadline = s.Deadline
+		}
+		steps[s.Key] = step
+	}
+	for _, s := range d.Steps {
+		if len(s.Next) == 0 {
+			return fmt.Errorf("step %s has no transitions", s.Key)
+		}
+		for _, t := range s.Next {
+			if len(t.Parallel) > 0 {
+				if t.To != "" || len(t.Parallel) < 2 {
+					return fmt.Errorf("step %s: parallel transition needs at least 2 branches and no to", s.Key)
+				}
+				d.parallel = true
+			}
+			for _, to := range t.targets() {
+				if _, ok := steps[to]; !ok && to != StepEnd {
+					return fmt.Errorf("step %s: transition to unknown step %s", s.Key, to)
+				}
+			}
+			if t.When == "" {
+				continue
+			}
+			var unknown string
+			if _, err = stringmatch.Calculate(t.When, conditionStackSize, func(name string) bool {
+				if _, ok := d.Conditions[name]; !ok && unknown == "" {
+					unknown = name
+				}
+				return true
+			}); err != nil {
+				return fmt.Errorf("step %s: invalid condition %q, %s", s.Key, t.When, err.Error())
+			}
+			if unknown != "" {
+				return fmt.Errorf("step %s: unknown condition %s", s.Key, unknown)
+			}
+		}
+		if _, ok := steps[s.Reject]; !ok && s.Reject != "" {
+			return fmt.Errorf("step %s: reject to unknown step %s", s.Key, s.Reject)
+		}
+	}
+	if d.Start == "" {
+		d.Start = d.Steps[0].Key
+	}
+	if _, ok := steps[d.Start]; !ok {
+		return fmt.Errorf("start step %s not found", d.Start)
+	}
+	// 所有步骤都要能从第一步到达
+	reached := map[string]bool{d.Start: true}
+	for queue := []string{d.Start}; len(queue) > 0; queue = queue[1:] {
+		for _, t := range d.stepDef(queue[0]).Next {
+			for _, to := range t.targets() {
+				if to != StepEnd && !reached[to] {
+					reached[to] = true
+					queue = append(queue, to)
+				}
+			}
+		}
+	}
+	for _, s := range d.Steps {
+		if !reached[s.Key] {
+			return fmt.Errorf("step %s is unreachable from %s", s.Key, d.Start)
+		}
+	}
+	d.steps = steps
+	return nil
+}
+
+func (d *FlowDefinition) stepDef(key string) *StepDefinition {
+	for i := range d.Steps {
+		if d.Steps[i].Key == key {
+			return &d.Steps[i]
+		}
+	}
+	return nil
+}
+
+// StepMap 所有步骤（Validate 之后可用）
+func (d *FlowDefinition) StepMap() map[string]FlowStep {
+	return d.steps
+}
+
+// Step 获取步骤
+func (d *FlowDefinition) Step(key string) (step FlowStep, err error) {
+	if step, ok := d.steps[key]; ok {
+		return step, nil
+	}
+	return nil, fmt.Errorf("step %s not defined in flow %s", key, d.Name)
+}
+
+// NextStep 当前步骤通过后的下一步，cur 为空时返回第一步，流转到结束时返回nil
+// 并行的流转没有单一的下一步，使用 GoNextFrom
+func (d *FlowDefinition) NextStep(flow FlowService, cur string) (step FlowStep, err error) {
+	if cur == "" {
+		return d.Step(d.Start)
+	}
+	var t *Transition
+	if t, err = d.nextTransition(flow, cur); err != nil {
+		return
+	}
+	if len(t.Parallel) > 0 {
+		return nil, fmt.Errorf("step %s goes to parallel branches %v", cur, t.Parallel)
+	}
+	if t.To == StepEnd {
+		return nil, nil
+	}
+	return d.Step(t.To)
+}
+
+// 当前步骤通过后第一条满足条件的流转
+func (d *FlowDefinition) nextTransition(flow FlowService, cur string) (t *Transition, err error) {
+	s := d.stepDef(cur)
+	if s == nil {
+		return nil, fmt.Errorf("step %s not defined in flow %s", cur, d.Name)
+	}
+	for i := range s.Next {
+		t = &s.Next[i]
+		var ok = true
+		if t.When != "" {
+			if ok, err = stringmatch.Calculate(t.When, conditionStackSize, func(name string) bool {
+				return d.Conditions[name].Match(flow)
+			}); err != nil {
+				return nil, fmt.Errorf("evaluate condition %q failed, %s", t.When, err.Error())
+			}
+		}
+		if ok {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no transition matched from step %s", cur)
+}
+
+// RejectStep 当前步骤不通过时退回的步骤，为nil表示退回草稿
+func (d *FlowDefinition) RejectStep(cur string) (step FlowStep, err error) {
+	s := d.stepDef(cur)
+	if s == nil {
+		return nil, fmt.Errorf("step %s not defined in flow %s", cur, d.Name)
+	}
+	if s.Reject == "" {
+		return nil, nil
+	}
+	return d.Step(s.Reject)
+}
+
+// Match 流程属性是否满足条件
+func (c Condition) Match(flow FlowService) bool {
+	v := flowValue(flow, c.Field)
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.Value{}
+			break
+		}
+		v = v.Elem()
+	}
+	switch c.Op {
+	case "empty":
+		return !v.IsValid() || v.IsZero()
+	case "not_empty":
+		return v.IsValid() && !v.IsZero()
+	}
+	if !v.IsValid() {
+		return false
+	}
+	switch c.Op {
+	case "in", "not_in":
+		in := false
+		for _, item := range strings.Split(c.Value, ",") {
+			in = in || compareValue(v, strings.TrimSpace(item)) == 0
+		}
+		return in == (c.Op == "in")
+	case "contains":
+		return v.Kind() == reflect.String && strings.Contains(v.String(), c.Value)
+	}
+	cmp := compareValue(v, c.Value)
+	switch c.Op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp == 1
+	case ">=":
+		return cmp == 1 || cmp == 0
+	case "<":
+		return cmp == -1
+	case "<=":
+		return cmp == -1 || cmp == 0
+	}
+	return false
+}
+
+// 属性值和字符串比较，数字按数值比较，返回 -1 0 1，无法比较时返回 2
+func compareValue(v reflect.Value, s string) int {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 2
+		}
+		var x float64
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			x = v.Float()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			x = float64(v.Uint())
+		default:
+			x = float64(v.Int())
+		}
+		switch {
+		case x < f:
+			return -1
+		case x > f:
+			return 1
+		}
+		return 0
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return 2
+		}
+		if v.Bool() == b {
+			return 0
+		}
+		return 2
+	case reflect.String:
+		return strings.Compare(v.String(), s)
+	}
+	return 2
+}
+
+// 流程属性值，实现了 GetValueInf 时使用其实现
+func flowValue(flow FlowService, attr string) reflect.Value {
+	if gvapp, ok := flow.(GetValueInf); ok {
+		return gvapp.GetValue(attr)
+	}
+	v := reflect.ValueOf(flow)
+	for _, p := range strings.Split(attr, ".") {
+		if v = GetRefValue(v); !v.IsValid() {
+			return v
+		}
+		v = v.FieldByName(p)
+	}
+	return v
+}
+
+// 类型中是否有该属性（嵌套属性用.分隔）
+func hasField(t reflect.Type, attr string) bool {
+	for _, p := range strings.Split(attr, ".") {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return false
+		}
+		f, ok := t.FieldByName(p)
+		if !ok {
+			return false
+		}
+		t = f.Type
+	}
+	return true
+}
+
+// 已注册的流程定义
+var definitions = make(map[string]*FlowDefinition)
+
+// GetDefinition 获取 service 注册时的流程定义，没有定义时返回nil
+func GetDefinition(serviceType string) *FlowDefinition {
+	return definitions[serviceType]
+}
@@ -0,0 +1,307 @@
+package flowservice
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego/context"
+	"github.com/daimall/tools/curd/common"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+	"gorm.io/gorm"
+)
+
+const purchaseDefinition = `
+name: purchase
+start: apply
+conditions:
+  big: {field: Amount, op: ">=", value: 10000}
+  urgent: {field: Level, op: in, value: "high,critical"}
+steps:
+  - key: apply
+    handlers: Creator
+    next:
+      - {to: director, when: big AND urgent}
+      - {to: manager}
+  - key: manager
+    handlers: Manager
+    reject: apply
+    next: [{to: end}]
+  - key: director
+    handlers: Director
+    passRate: 50
+    next: [{to: end}]
+`
+
+type purchaseFlow struct {
+	CommFlow
+	Amount   int    `json:"amount"`
+	Level    string `gorm:"size:20" json:"level"`
+	Manager  string `gorm:"size:100" json:"manager"`
+	Director string `gorm:"size:100" json:"director"`
+
+	def *FlowDefinition `gorm:"-"`
+}
+
+func (f *purchaseFlow) GetFlowName() string { return "purchase" }
+func (f *purchaseFlow) New(uname string, c common.BaseController) (uint, interface{}, string, error) {
+	return 0, nil, "", nil
+}
+func (f *purchaseFlow) NewInst() FlowService                  { return &purchaseFlow{} }
+func (f *purchaseFlow) LoadInst(id uint) (FlowService, error) { return nil, nil }
+func (f *purchaseFlow) FlowDefinition() *FlowDefinition       { return f.def }
+
+func Test_DefinitionValidate(t *testing.T) {
+	cases := map[string]string{
+		"unknown step":      strings.Replace(purchaseDefinition, "{to: manager}", "{to: boss}", 1),
+		"unknown condition": strings.Replace(purchaseDefinition, "big AND urgent", "big AND vip", 1),
+		"invalid op":        strings.Replace(purchaseDefinition, `op: ">="`, `op: "=~"`, 1),
+		"unknown field":     strings.Replace(purchaseDefinition, "field: Amount", "field: Price", 1),
+		"unreachable":       strings.Replace(purchaseDefinition, "{to: director, when: big AND urgent}", "{to: end, when: big}", 1),
+		"bad reject":        strings.Replace(purchaseDefinition, "reject: apply", "reject: draft", 1),
+		"bad expression":    strings.Replace(purchaseDefinition, "big AND urgent", "(big AND", 1),
+	}
+	for name, data := range cases {
+		def, err := LoadDefinition([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if err = def.Validate(&purchaseFlow{}); err == nil {
+			t.Errorf("%s: expect validate error", name)
+		}
+	}
+	def, err := LoadDefinition([]byte(purchaseDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = def.Validate(&purchaseFlow{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		amount int
+		level  string
+		next   string
+	}{{20000, "high", "director"}, {20000, "low", "manager"}, {100, "critical", "manager"}} {
+		step, err := def.NextStep(&purchaseFlow{Amount: c.amount, Level: c.level}, "apply")
+		if err != nil || step.Key() != c.next {
+			t.Errorf("%d/%s: expect %s, got %v %v", c.amount, c.level, c.next, step, err)
+		}
+	}
+	if step, err := def.NextStep(&purchaseFlow{}, "manager"); err != nil || step != nil {
+		t.Errorf("expect end after manager, got %v %v", step, err)
+	}
+	if step, err := def.RejectStep("manager"); err != nil || step.Key() != "apply" {
+		t.Errorf("expect reject to apply, got %v %v", step, err)
+	}
+}
+
+func Test_DefinitionGoNext(t *testing.T) {
+	db := testdb.New(t, &purchaseFlow{}, &CheckHandler{})
+	def, err := LoadDefinition([]byte(purchaseDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Register("purchase-test", &purchaseFlow{def: def})
+	if GetDefinition("purchase-test") != def || def.StepMap()["director"].PassRate() != 50 {
+		t.Fatal("definition not registered")
+	}
+
+	f := &purchaseFlow{Amount: 50000, Level: "critical", Director: "dora", Manager: "mike"}
+	f.CurStep, f.State, f.Creator = "apply", FlowStateRunning, "alice"
+	db.Create(f)
+	db.Create(&CheckHandler{User: "alice", Step: "apply", ServiceId: f.ID, ServiceName: "purchase-test", Conclusion: ConclusionGo})
+	if _, err = f.GoNext(db, f, "", "purchase-test", nil); err != nil {
+		t.Fatal(err)
+	}
+	var saved purchaseFlow
+	db.First(&saved, f.ID)
+	var handlers []CheckHandler
+	db.Where("step = ?", "director").Find(&handlers)
+	if saved.CurStep != "director" || len(handlers) != 1 || handlers[0].User != "dora" {
+		t.Fatalf("expect director step handled by dora, got %s %+v", saved.CurStep, handlers)
+	}
+	// 没有实现 GetCurStepInf，按定义查找当前步骤
+	if step, err := CurStepOf(&saved, "purchase-test"); err != nil || step.Key() != "director" || step.PassRate() != 50 {
+		t.Fatalf("unexpected cur step %v %v", step, err)
+	}
+
+	// director 通过后结束
+	db.Model(&handlers[0]).Update("conclusion", ConclusionGo)
+	f.CurStep = "director"
+	if _, err = f.GoNext(db, f, "", "purchase-test", nil); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&saved, f.ID)
+	if saved.State != FlowStateFinish || saved.CurStep != "-" {
+		t.Errorf("expect finished flow, got %s %d", saved.CurStep, saved.State)
+	}
+}
+
+const contractDefinition = `
+name: contract
+steps:
+  - key: manager
+    handlers: Manager
+    reject: manager
+    next: [{parallel: [legal, finance, tax]}]
+  - {key: legal, handlers: Legal, reject: manager, next: [{to: sign}]}
+  - {key: finance, handlers: Finance, next: [{to: sign}]}
+  - {key: tax, handlers: Tax, next: [{to: sign}]}
+  - {key: sign, handlers: Signer, join: "2", next: [{to: end}]}
+`
+
+type contractFlow struct {
+	CommFlow
+	Manager string `gorm:"size:100" json:"manager"`
+	Legal   string `gorm:"size:100" json:"legal"`
+	Finance string `gorm:"size:100" json:"finance"`
+	Tax     string `gorm:"size:100" json:"tax"`
+	Signer  string `gorm:"size:100" json:"signer"`
+
+	def     *FlowDefinition `gorm:"-"`
+	service string          `gorm:"-"`
+}
+
+func (f *contractFlow) GetFlowName() string { return "contract" }
+func (f *contractFlow) New(uname string, c common.BaseController) (uint, interface{}, string, error) {
+	return 0, nil, "", nil
+}
+func (f *contractFlow) NewInst() FlowService                  { return &contractFlow{} }
+func (f *contractFlow) LoadInst(id uint) (FlowService, error) { return nil, nil }
+func (f *contractFlow) FlowDefinition() *FlowDefinition       { return f.def }
+func (f *contractFlow) GoNextFrom(tx *gorm.DB, step string, remainingIds []uint) ([]FlowHandler, error) {
+	return f.CommFlow.GoNextFrom(tx, f, "", f.service, step, remainingIds)
+}
+
+func Test_ParallelJoin(t *testing.T) {
+	for name, data := range map[string]string{
+		"bad join":   strings.Replace(contractDefinition, `join: "2"`, "join: most", 1),
+		"one branch": strings.Replace(contractDefinition, "[legal, finance, tax]", "[legal]", 1),
+	} {
+		def, _ := LoadDefinition([]byte(data))
+		if err := def.Validate(&contractFlow{}); err == nil {
+			t.Errorf("%s: expect validate error", name)
+		}
+	}
+
+	db := testdb.New(t, &contractFlow{}, &CheckHandler{}, &ActiveStep{})
+	def, err := LoadDefinition([]byte(contractDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const service = "contract-test"
+	Register(service, &contractFlow{def: def})
+	newFlow := func() *contractFlow {
+		f := &contractFlow{Manager: "mike", Legal: "lee", Finance: "fay", Tax: "tom", Signer: "sam"}
+		f.CurStep, f.State = "manager", FlowStateRunning
+		db.Create(f)
+		db.Create(&CheckHandler{User: "mike", Step: "manager", ServiceId: f.ID, ServiceName: service, Conclusion: ConclusionGo})
+		if _, err := f.GoNext(db, f, "", service, nil); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	conclude := func(f *contractFlow, step string, conclusion int) {
+		db.Model(&CheckHandler{}).Where("service_id = ? and step = ?", f.ID, step).Update("conclusion", conclusion)
+		if _, err := f.CommFlow.GoNextFrom(db, f, "", service, step, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending := func(f *contractFlow) (steps []string) {
+		db.Model(&CheckHandler{}).Where("service_id = ? and conclusion = 0", f.ID).Order("id").Pluck("step", &steps)
+		return
+	}
+
+	// 分叉：三个分支同时处理
+	f := newFlow()
+	active, _ := ActiveSteps(db, service, f.ID)
+	if f.CurStep != StepParallel || len(active) != 3 || strings.Join(pending(f), ",") != "legal,finance,tax" {
+		t.Fatalf("expect 3 parallel branches, got %s %+v %v", f.CurStep, active, pending(f))
+	}
+	if _, err = f.GoNext(db, f, "", service, nil); err == nil {
+		t.Error("expect GoNext error in parallel steps")
+	}
+	// 完成2个分支后汇合，取消 tax 分支
+	conclude(f, "legal", ConclusionGo)
+	if f.CurStep != StepParallel {
+		t.Fatalf("expect waiting for join, got %s", f.CurStep)
+	}
+	conclude(f, "finance", ConclusionGoWithRisk)
+	active, _ = ActiveSteps(db, service, f.ID)
+	if f.CurStep != "sign" || len(active) != 0 || strings.Join(pending(f), ",") != "sign" {
+		t.Fatalf("expect joined at sign, got %s %+v %v", f.CurStep, active, pending(f))
+	}
+	conclude(f, "sign", ConclusionGo)
+	if f.State != FlowStateFinish {
+		t.Errorf("expect finished flow, got %d", f.State)
+	}
+
+	// 分支中退回：取消所有分支
+	f = newFlow()
+	conclude(f, "finance", ConclusionGo)
+	conclude(f, "legal", ConclusionReject)
+	var count int64
+	db.Model(&CheckHandler{}).Where("service_id = ? and step in ?", f.ID, []string{"legal", "finance", "tax"}).Count(&count)
+	active, _ = ActiveSteps(db, service, f.ID)
+	if f.CurStep != "manager" || count != 0 || len(active) != 0 || strings.Join(pending(f), ",") != "manager" {
+		t.Errorf("expect rejected to manager, got %s %d %+v %v", f.CurStep, count, active, pending(f))
+	}
+}
+
+func Test_ParallelCheckHandler(t *testing.T) {
+	db := testdb.New(t, &contractFlow{}, &CheckHandler{}, &ActiveStep{})
+	def, err := LoadDefinition([]byte(contractDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const service = "contract-check"
+	Register(service, &contractFlow{def: def, service: service})
+	f := &contractFlow{Manager: "mike", Legal: "lee", Finance: "fay", Tax: "tom", Signer: "sam", service: service}
+	f.CurStep, f.State = "manager", FlowStateRunning
+	db.Create(f)
+	db.Create(&CheckHandler{User: "mike", Step: "manager", ServiceId: f.ID, ServiceName: service, Conclusion: ConclusionGo})
+	if _, err = f.CommFlow.GoNext(db, f, "", service, nil); err != nil || f.CurStep != StepParallel {
+		t.Fatalf("expect parallel steps, got %s %v", f.CurStep, err)
+	}
+	// 同 FlowController.Next：按处理记录（或者用户未处理的记录）所在的分支处理
+	do := func(uname string, handlerId uint) {
+		step, err := HandlerStep(f, service, uname, handlerId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := step.Hander().LoadInst(f, uname, handlerId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.NewContext()
+		ctx.Reset(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		ctx.Input.RequestBody = []byte(`{"conclusion":1}`)
+		c := common.BaseController{}
+		c.Ctx = ctx
+		if _, _, err = h.Do(uname, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var finance CheckHandler
+	db.Where("service_id = ? and step = ?", f.ID, "finance").First(&finance)
+	if step, err := HandlerStep(f, service, "fay", finance.ID); err != nil || step.Key() != "finance" {
+		t.Fatalf("expect finance step, got %v %v", step, err)
+	}
+	do("fay", finance.ID)
+	if f.CurStep != StepParallel {
+		t.Fatalf("expect waiting for join, got %s", f.CurStep)
+	}
+	if _, err = HandlerStep(f, service, "fay", 0); err == nil {
+		t.Error("expect no pending handler of fay")
+	}
+	if step, err := HandlerStep(f, service, "lee", 0); err != nil || step.Key() != "legal" {
+		t.Fatalf("expect legal step of lee, got %v %v", step, err)
+	}
+	var legal CheckHandler
+	db.Where("service_id = ? and step = ?", f.ID, "legal").First(&legal)
+	do("lee", legal.ID)
+	active, _ := ActiveSteps(db, service, f.ID)
+	if f.CurStep != "sign" || len(active) != 0 {
+		t.Errorf("expect joined at sign, got %s %+v", f.CurStep, active)
+	}
+}
@@ -0,0 +1,45 @@
+package flowservice
+
+import (
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/migrate"
+	"gorm.io/gorm"
+)
+
+// 数据库迁移，见 migrate 包
+// 使用自定义审批表名（CheckHandler.TblName）的 service 需要自行注册：
+// migrate.Register(migrate.Model(version, "create xx_handlers", &flowservice.CheckHandler{}, "xx_handlers"))
+func init() {
+	migrate.Register(migrate.Model(2022010101, "create step_handlers", &CheckHandler{}))
+	migrate.Register(migrate.Model(2022010300, "create flow_active_steps", &ActiveStep{}))
+	migrate.Register(migrate.Migration{
+		Version: 2022010301,
+		Name:    "add step_handlers deadline",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"DueAt", "RemindedAt", "EscalatedAt"} {
+				if tx.Migrator().HasColumn(&CheckHandler{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&CheckHandler{}, field); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&CheckHandler{}, "DueAt") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&CheckHandler{}, "DueAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&CheckHandler{}, "DueAt") {
+				if err := tx.Migrator().DropIndex(&CheckHandler{}, "DueAt"); err != nil {
+					return err
+				}
+			}
+			for _, field := range []string{"DueAt", "RemindedAt", "EscalatedAt"} {
+				if err := tx.Migrator().DropColumn(&CheckHandler{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
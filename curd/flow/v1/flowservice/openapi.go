@@ -0,0 +1,184 @@
+package flowservice
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/daimall/tools/curd/common"
+	oplog "github.com/daimall/tools/curd/oplog"
+)
+
+// 文档中列出的可选接口
+var optionalInterfaces = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"ActionInf", reflect.TypeOf((*ActionInf)(nil)).Elem()},
+	{"GetOneInf", reflect.TypeOf((*GetOneInf)(nil)).Elem()},
+	{"GetAllInf", reflect.TypeOf((*GetAllInf)(nil)).Elem()},
+	{"GetAllByRequestInf", reflect.TypeOf((*GetAllByRequestInf)(nil)).Elem()},
+	{"QueryFieldsInf", reflect.TypeOf((*QueryFieldsInf)(nil)).Elem()},
+	{"UpdateInf", reflect.TypeOf((*UpdateInf)(nil)).Elem()},
+	{"DeleteInf", reflect.TypeOf((*DeleteInf)(nil)).Elem()},
+	{"DeleteCompatible1Inf", reflect.TypeOf((*DeleteCompatible1Inf)(nil)).Elem()},
+	{"MultiDeleteInf", reflect.TypeOf((*MultiDeleteInf)(nil)).Elem()},
+	{"Import", reflect.TypeOf((*Import)(nil)).Elem()},
+	{"Export", reflect.TypeOf((*Export)(nil)).Elem()},
+	{"ExportByRequestInf", reflect.TypeOf((*ExportByRequestInf)(nil)).Elem()},
+	{"GetConfigsInf", reflect.TypeOf((*GetConfigsInf)(nil)).Elem()},
+	{"OpHistoryInf", reflect.TypeOf((*OpHistoryInf)(nil)).Elem()},
+	{"OpLogHistoryInf", reflect.TypeOf((*OpLogHistoryInf)(nil)).Elem()},
+	{"PreHandlersInf", reflect.TypeOf((*PreHandlersInf)(nil)).Elem()},
+	{"GoNextInf", reflect.TypeOf((*GoNextInf)(nil)).Elem()},
+	{"GoNextFromInf", reflect.TypeOf((*GoNextFromInf)(nil)).Elem()},
+	{"GetCurStepInf", reflect.TypeOf((*GetCurStepInf)(nil)).Elem()},
+	{"OplogModelInf", reflect.TypeOf((*OplogModelInf)(nil)).Elem()},
+}
+
+// Interfaces 服务实现的可选接口名称
+func Interfaces(service FlowService) (names []string) {
+	t := reflect.TypeOf(service)
+	for _, inf := range optionalInterfaces {
+		if t.Implements(inf.typ) {
+			names = append(names, inf.name)
+		}
+	}
+	return
+}
+
+// OpenAPIDocument 根据已注册的服务生成 OpenAPI 3 文档
+// basePath 为 FlowController 路由的前缀，例如 /v1/flow
+func OpenAPIDocument(title, version, basePath string) *common.OpenAPI {
+	doc := &common.OpenAPI{
+		OpenAPI:    "3.0.3",
+		Info:       common.OpenAPIInfo{Title: title, Version: version},
+		Servers:    []common.OpenAPIServer{{URL: basePath}},
+		Paths:      map[string]common.PathItem{},
+		Components: common.OpenAPIComponents{Schemas: map[string]*common.Schema{}},
+	}
+	for _, name := range ServiceNames() {
+		addServicePaths(doc, name, services[name])
+	}
+	return doc
+}
+
+// 单个服务的接口描述
+func addServicePaths(doc *common.OpenAPI, name string, service FlowService) {
+	infs := Interfaces(service)
+	implemented := map[string]bool{}
+	for _, inf := range infs {
+		implemented[inf] = true
+	}
+	doc.Tags = append(doc.Tags, common.OpenAPITag{Name: name, Interfaces: infs})
+
+	model := common.SchemaOf(reflect.TypeOf(service.NewInst()), doc.Components.Schemas)
+	idParam := common.Parameter{Name: "id", In: "path", Required: true, Schema: &common.Schema{Type: "integer"}}
+	base := "/" + name
+	op := func(summary, id string, params []common.Parameter, body *common.Schema, data *common.Schema) *common.Operation {
+		o := &common.Operation{
+			Tags:        []string{name},
+			Summary:     summary,
+			OperationID: id + strings.ToUpper(name[:1]) + name[1:],
+			Parameters:  params,
+			Responses: map[string]*common.Response{
+				"200": {Description: "OK", Content: common.JSONContent(common.RestResultSchema(data))},
+			},
+		}
+		if body != nil {
+			o.RequestBody = &common.RequestBody{Required: true, Content: common.JSONContent(body)}
+		}
+		return o
+	}
+	add := func(path, method string, o *common.Operation) {
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = common.PathItem{}
+		}
+		doc.Paths[path][method] = o
+	}
+
+	add(base, "post", op("新建", "create", nil, model, model))
+	if implemented["GetAllInf"] || implemented["GetAllByRequestInf"] {
+		page := &common.Schema{Type: "object", Properties: map[string]*common.Schema{
+			"items":  {Type: "array", Items: model},
+			"total":  {Type: "integer", Format: "int64"},
+			"approx": {Type: "boolean"},
+			"next":   {Type: "string"},
+			"prev":   {Type: "string"},
+		}}
+		add(base, "get", op("列表查询", "list", listParams(service), nil, page))
+	}
+	if implemented["GetOneInf"] {
+		add(base+"/{id}", "get", op("查询详情", "get", []common.Parameter{idParam}, nil, model))
+	}
+	if implemented["UpdateInf"] {
+		fields := common.Parameter{Name: "fields", In: "query", Description: "指定更新的字段，逗号分隔", Schema: &common.Schema{Type: "string"}}
+		add(base+"/{id}", "put", op("更新", "update", []common.Parameter{idParam, fields}, model, model))
+	}
+	if implemented["DeleteInf"] || implemented["DeleteCompatible1Inf"] {
+		add(base+"/{id}", "delete", op("删除", "delete", []common.Parameter{idParam}, nil, nil))
+	}
+	if implemented["MultiDeleteInf"] {
+		ids := common.Parameter{Name: "ids", In: "query", Required: true, Description: "逗号分隔的id", Schema: &common.Schema{Type: "string"}}
+		add(base+"/deletelist", "delete", op("批量删除", "deleteList", []common.Parameter{ids}, nil, nil))
+	}
+	if implemented["Import"] {
+		o := op("导入excel", "import", nil, nil, nil)
+		o.RequestBody = &common.RequestBody{Required: true, Content: map[string]common.MediaType{
+			"multipart/form-data": {Schema: &common.Schema{Type: "object", Properties: map[string]*common.Schema{
+				"importFile": {Type: "string", Format: "binary"},
+			}}},
+		}}
+		add(base+"/import", "post", o)
+	}
+	if implemented["Export"] || implemented["ExportByRequestInf"] {
+		o := op("导出excel", "export", listParams(service), nil, nil)
+		o.Responses["200"] = &common.Response{Description: "excel文件", Content: map[string]common.MediaType{
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": {Schema: &common.Schema{Type: "string", Format: "binary"}},
+		}}
+		add(base+"/export", "get", o)
+	}
+	if implemented["GetConfigsInf"] {
+		add(base+"/{id}/config", "get", op("获取处理参数", "getConfigs", []common.Parameter{idParam}, nil, nil))
+	}
+	// 有流程定义时按定义查找当前步骤，见 CurStepOf
+	_, stepKey := service.(GetCurStepKeyInf)
+	if implemented["ActionInf"] || implemented["GetCurStepInf"] || stepKey && definitionOf(service, name) != nil {
+		action := common.Parameter{Name: "action", In: "path", Required: true,
+			Description: "自定义动作名称（ActionInf）或者当前步骤的处理记录id（流程处理）", Schema: &common.Schema{Type: "string"}}
+		add(base+"/{id}/{action}", "post", op("自定义动作/流程处理", "do", []common.Parameter{idParam, action}, &common.Schema{Type: "object"}, nil))
+	}
+	if implemented["OpHistoryInf"] {
+		add(base+"/{id}/oplist", "get", op("操作历史", "opList", []common.Parameter{idParam}, nil, nil))
+	}
+	if implemented["OpLogHistoryInf"] {
+		add(base+"/{id}/oploglist", "get", op("操作日志", "opLogList", []common.Parameter{idParam}, nil, nil))
+	}
+	if implemented["UpdateInf"] {
+		timeline := common.SchemaOf(reflect.TypeOf([]oplog.TimelineEntry{}), doc.Components.Schemas)
+		add(base+"/{id}/timeline", "get", op("字段变更时间线", "timeline", []common.Parameter{idParam}, nil, timeline))
+	}
+	if implemented["PreHandlersInf"] {
+		add(base+"/{id}/prehandlers", "get", op("上一步处理人", "preHandlers", []common.Parameter{idParam}, nil, nil))
+	}
+}
+
+// 列表查询参数（见 common.ParseListRequest）
+func listParams(service FlowService) []common.Parameter {
+	str := &common.Schema{Type: "string"}
+	integer := &common.Schema{Type: "integer"}
+	queryDesc := "查询条件 k|type:v|v,k|type:v|v"
+	if app, ok := service.(QueryFieldsInf); ok {
+		queryDesc += "，可查询字段：" + strings.Join(app.QueryFields(), ",")
+	}
+	return []common.Parameter{
+		{Name: "query", In: "query", Description: queryDesc, Schema: str},
+		{Name: "fields", In: "query", Description: "返回字段，逗号分隔", Schema: str},
+		{Name: "sortby", In: "query", Description: "排序字段，逗号分隔", Schema: str},
+		{Name: "order", In: "query", Description: "排序方向 asc/desc", Schema: str},
+		{Name: "limit", In: "query", Schema: integer},
+		{Name: "offset", In: "query", Schema: integer},
+		{Name: "cursor", In: "query", Description: "游标分页，第一页传空值", Schema: str},
+		{Name: "count", In: "query", Schema: &common.Schema{Type: "string", Enum: []string{common.CountExact, common.CountApprox, common.CountNone}}},
+		{Name: "tz", In: "query", Description: "日期查询的时区", Schema: str},
+	}
+}
@@ -0,0 +1,362 @@
+package flowservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StepParallel 流程处于并行分支中时的 cur_step，各分支的当前步骤见 ActiveSteps
+const StepParallel = "*"
+
+// ActiveStep 并行分支的当前步骤，一个分支一条记录，汇合或者退回后删除
+type ActiveStep struct {
+	ID          uint   `gorm:"primary_key" json:"id"`
+	ServiceId   uint   `gorm:"column:service_id;index" json:"serviceId"`
+	ServiceName string `gorm:"size:100;column:service;index" json:"service"`
+	Fork        string `gorm:"size:20;column:fork" json:"fork"`     // 分叉的步骤
+	Branch      string `gorm:"size:20;column:branch" json:"branch"` // 分支的第一步
+	Step        string `gorm:"size:20;column:step" json:"step"`     // 分支的当前步骤，到达汇合步骤后为汇合步骤
+	Joined      bool   `gorm:"column:joined" json:"joined"`         // 已到达汇合步骤
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ActiveStep) TableName() string {
+	return "flow_active_steps"
+}
+
+// GoNextFromInf 从指定步骤流转，流程有并行分支时 CheckHandler 优先使用
+type GoNextFromInf interface {
+	// step：处理完成的步骤， remainingIds 同 GoNextInf
+	GoNextFrom(tx *gorm.DB, step string, remainingIds []uint) (handlers []FlowHandler, err error)
+}
+
+// ActiveSteps 流程并行分支的当前步骤，没有并行分支时为空
+func ActiveSteps(tx *gorm.DB, serviceName string, flowId uint) (steps []ActiveStep, err error) {
+	err = tx.Where("service = ? and service_id = ?", serviceName, flowId).Order("id").Find(&steps).Error
+	return
+}
+
+// HandlerStep 处理记录所在的步骤：流程处于并行分支中时，在各分支的当前步骤中查找 handlerId
+// （为0时查找 uname 未处理的记录），否则为流程的当前步骤（见 CurStepOf）
+func HandlerStep(flow FlowService, serviceName, uname string, handlerId uint) (step FlowStep, err error) {
+	if kapp, ok := flow.(GetCurStepKeyInf); !ok || kapp.GetCurStepKey() != StepParallel {
+		return CurStepOf(flow, serviceName)
+	}
+	def := definitionOf(flow, serviceName)
+	if def == nil {
+		return nil, fmt.Errorf("flow %s has no definition for parallel steps", serviceName)
+	}
+	var dbInst *gorm.DB
+	if dbInst, err = dbgorm.Get(dbgorm.DefaultName); err != nil {
+		return
+	}
+	var branches []ActiveStep
+	if branches, err = ActiveSteps(dbInst, serviceName, flow.GetID()); err != nil {
+		logs.Error("load active steps failed,", err.Error())
+		return
+	}
+	for _, b := range branches {
+		if b.Joined {
+			continue
+		}
+		if step, err = def.Step(b.Step); err != nil {
+			return
+		}
+		q := dbInst.Table(step.Hander().TableName()).Where("step = ? and service_id = ? and service = ?", b.Step, flow.GetID(), serviceName)
+		if handlerId != 0 {
+			q = q.Where("id = ?", handlerId)
+		} else {
+			q = q.Where("user = ? and conclusion = 0", uname)
+		}
+		var count int64
+		if err = q.Count(&count).Error; err != nil {
+			logs.Error("query handlers of active step failed,", err.Error())
+			return
+		}
+		if count > 0 {
+			return
+		}
+	}
+	return nil, fmt.Errorf("no handler[%d] of %s in active steps of flow %d", handlerId, uname, flow.GetID())
+}
+
+// 汇合需要完成的分支数：all（默认）全部，any 任一，数字N 完成N个（超过分支数时为全部）
+func joinCount(join string, branches int) (n int, err error) {
+	switch join {
+	case "", "all":
+		return branches, nil
+	case "any":
+		return 1, nil
+	}
+	if n, err = strconv.Atoi(join); err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid join %s, must be all, any or a positive number", join)
+	}
+	if n > branches {
+		n = branches
+	}
+	return
+}
+
+// GoNextFrom 从 stepKey 流转，流程定义中有并行流转（parallel）时使用：
+// 分叉时每个分支记录一个 ActiveStep 并添加处理人，分支到达汇合步骤（join 或者 end）后按汇合条件进入汇合步骤，
+// 此时未完成分支的未处理记录被删除；分支中退回时取消所有分支
+func (x *CommFlow) GoNextFrom(tx *gorm.DB, flow FlowService, tblName, serviceName, stepKey string, remainingIds []uint) (curStepHandlers []FlowHandler, err error) {
+	if x.CurStep != StepParallel {
+		if stepKey != x.CurStep {
+			return nil, fmt.Errorf("step %s is not the current step %s", stepKey, x.CurStep)
+		}
+		return x.GoNext(tx, flow, tblName, serviceName, remainingIds)
+	}
+	def := definitionOf(flow, serviceName)
+	if def == nil {
+		return nil, fmt.Errorf("flow %s has no definition for parallel steps", serviceName)
+	}
+	// 锁定流程，同一流程的分支串行流转，避免同时到达汇合步骤时都认为未满足汇合条件
+	var curStep []string
+	q := tx.Model(flow).Where("id = ?", flow.GetID())
+	if dbgorm.Dialect(tx) != dbgorm.DialectSQLite {
+		q = q.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	if err = q.Pluck("cur_step", &curStep).Error; err != nil {
+		logs.Error("lock flow failed,", err.Error())
+		return
+	}
+	if len(curStep) == 0 || curStep[0] != StepParallel {
+		return nil, fmt.Errorf("flow %d is no longer in parallel steps", flow.GetID())
+	}
+	var branches []ActiveStep
+	if branches, err = ActiveSteps(tx, serviceName, flow.GetID()); err != nil {
+		logs.Error("load active steps failed,", err.Error())
+		return
+	}
+	var branch *ActiveStep
+	for i := range branches {
+		if branches[i].Step == stepKey && !branches[i].Joined {
+			branch = &branches[i]
+		}
+	}
+	if branch == nil {
+		return nil, fmt.Errorf("step %s is not active in flow %d", stepKey, flow.GetID())
+	}
+	var step FlowStep
+	if step, err = def.Step(stepKey); err != nil {
+		return
+	}
+	if curStepHandlers, err = step.LoadHandlers(tx, flow.GetID()); err != nil {
+		logs.Error("LoadHandlers failed,", err.Error(), flow.GetID(), step.Key())
+		return
+	}
+	goNext, goBack := checkPass(step, curStepHandlers)
+	if goBack {
+		err = x.rejectBranches(tx, flow, def, serviceName, step, branches, remainingIds)
+		return
+	}
+	if !goNext {
+		logs.Debug("check one but not enough")
+		return
+	}
+	var t *Transition
+	if t, err = def.nextTransition(flow, stepKey); err != nil {
+		logs.Error("GetNextStep failed,", err.Error())
+		return
+	}
+	if len(t.Parallel) > 0 {
+		return nil, fmt.Errorf("step %s: parallel in parallel branch is not supported", stepKey)
+	}
+	if s := def.stepDef(t.To); t.To != StepEnd && s.Join == "" {
+		// 分支内的下一步
+		if err = tx.Model(branch).Update("step", t.To).Error; err != nil {
+			logs.Error("update active step failed,", err.Error())
+			return
+		}
+		err = x.addStepHandlers(tx, flow, def, serviceName, t.To, nil)
+		return
+	}
+	err = x.joinBranch(tx, flow, def, serviceName, branch, branches, t.To)
+	return
+}
+
+// 分叉，进入并行的分支
+func (x *CommFlow) fork(tx *gorm.DB, flow FlowService, def *FlowDefinition, serviceName, forkKey string, branches []string) (err error) {
+	if err = tx.Model(flow).Update("cur_step", StepParallel).Error; err != nil {
+		logs.Error("update flow to parallel steps failed,", err.Error())
+		return
+	}
+	for _, key := range branches {
+		active := &ActiveStep{ServiceId: flow.GetID(), ServiceName: serviceName, Fork: forkKey, Branch: key, Step: key}
+		if err = tx.Create(active).Error; err != nil {
+			logs.Error("create active step failed,", err.Error())
+			return
+		}
+		if err = x.addStepHandlers(tx, flow, def, serviceName, key, nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 分支到达汇合步骤，满足汇合条件时取消未完成的分支，进入汇合步骤
+func (x *CommFlow) joinBranch(tx *gorm.DB, flow FlowService, def *FlowDefinition, serviceName string,
+	branch *ActiveStep, branches []ActiveStep, joinKey string) (err error) {
+	if err = tx.Model(branch).Updates(map[string]interface{}{"step": joinKey, "joined": true}).Error; err != nil {
+		logs.Error("update active step failed,", err.Error())
+		return
+	}
+	var join string
+	if s := def.stepDef(joinKey); s != nil {
+		join = s.Join
+	}
+	need, err := joinCount(join, len(branches))
+	if err != nil {
+		return
+	}
+	var joined int
+	var pending []string
+	for _, b := range branches {
+		if b.Joined && b.Step == joinKey || b.ID == branch.ID {
+			joined++
+		} else if !b.Joined {
+			pending = append(pending, b.Step)
+		}
+	}
+	if joined < need {
+		return
+	}
+	// 取消未完成的分支，保留已处理的记录
+	for _, key := range pending {
+		var step FlowStep
+		if step, err = def.Step(key); err != nil {
+			return
+		}
+		if err = tx.Table(step.Hander().TableName()).Where("step = ? and service_id = ? and service = ? and conclusion = 0",
+			key, flow.GetID(), serviceName).Delete(&CheckHandler{}).Error; err != nil {
+			logs.Error("clear handlers of cancelled branch failed,", err.Error())
+			return
+		}
+	}
+	if err = tx.Where("service = ? and service_id = ?", serviceName, flow.GetID()).Delete(&ActiveStep{}).Error; err != nil {
+		logs.Error("delete active steps failed,", err.Error())
+		return
+	}
+	if joinKey == StepEnd {
+		if err = tx.Model(flow).Updates(map[string]interface{}{"cur_step": "-", "state": FlowStateFinish}).Error; err != nil {
+			logs.Error("update flow to finish failed,", err.Error())
+		}
+		return
+	}
+	if err = tx.Model(flow).Update("cur_step", joinKey).Error; err != nil {
+		logs.Error("update flow to join step failed,", err.Error())
+		return
+	}
+	return x.addStepHandlers(tx, flow, def, serviceName, joinKey, nil)
+}
+
+// 分支中退回：取消所有分支，清空各分支和退回步骤的处理记录
+func (x *CommFlow) rejectBranches(tx *gorm.DB, flow FlowService, def *FlowDefinition, serviceName string,
+	step FlowStep, branches []ActiveStep, remainingIds []uint) (err error) {
+	var next FlowStep
+	if next, err = def.RejectStep(step.Key()); err != nil {
+		logs.Error("GetPreStep failed,", err.Error())
+		return
+	}
+	var users []string
+	if next != nil && remainingIds != nil {
+		if err = tx.Table(next.Hander().TableName()).Where("id in (?)", remainingIds).Pluck("user", &users).Error; err != nil {
+			logs.Error("get remain check handlers failed,", err.Error())
+			return
+		}
+	}
+	var steps []string
+	for _, b := range branches {
+		steps = append(steps, def.branchSteps(b.Branch)...)
+	}
+	if next != nil {
+		steps = append(steps, next.Key())
+	}
+	if err = step.ClearHandlers(tx, flow.GetID(), serviceName, steps, nil); err != nil {
+		logs.Error("go prestep, clear handlers failed,", err.Error())
+		return
+	}
+	if err = tx.Where("service = ? and service_id = ?", serviceName, flow.GetID()).Delete(&ActiveStep{}).Error; err != nil {
+		logs.Error("delete active steps failed,", err.Error())
+		return
+	}
+	if next == nil {
+		// 同 GoNext，退回草稿
+		if err = tx.Model(flow).Updates(map[string]interface{}{"cur_step": "-", "state": FlowStateFinish}).Error; err != nil {
+			logs.Error("update flow to finish failed,", err.Error())
+		}
+		return
+	}
+	if err = tx.Model(flow).Update("cur_step", next.Key()).Error; err != nil {
+		logs.Error("update flow to pre step failed,", err.Error())
+		return
+	}
+	return x.addStepHandlers(tx, flow, def, serviceName, next.Key(), users)
+}
+
+// 分支中的所有步骤（到汇合步骤为止）
+func (d *FlowDefinition) branchSteps(start string) (steps []string) {
+	reached := map[string]bool{start: true}
+	for queue := []string{start}; len(queue) > 0; queue = queue[1:] {
+		steps = append(steps, queue[0])
+		for _, t := range d.stepDef(queue[0]).Next {
+			if s := d.stepDef(t.To); s != nil && s.Join == "" && !reached[t.To] {
+				reached[t.To] = true
+				queue = append(queue, t.To)
+			}
+		}
+	}
+	return
+}
+
+// 添加步骤的处理人，users 为空时使用步骤的默认处理人
+func (x *CommFlow) addStepHandlers(tx *gorm.DB, flow FlowService, def *FlowDefinition, serviceName, stepKey string, users []string) (err error) {
+	var step FlowStep
+	if step, err = def.Step(stepKey); err != nil {
+		return
+	}
+	if len(users) == 0 {
+		users = strings.Split(step.GetDefaultHandlers(flow), ",")
+	}
+	var handlers []FlowHandler
+	for _, user := range users {
+		handlers = append(handlers, &CheckHandler{
+			User:        user,
+			Step:        stepKey,
+			ServiceId:   flow.GetID(),
+			ServiceName: serviceName,
+		})
+	}
+	if err = step.AddHandlers(tx, handlers); err != nil {
+		logs.Error("AddHandlers failed,", err.Error())
+	}
+	return
+}
+
+// 按通过率判断是否进入下一步（goNext）或者退回（goBack），都不满足时等待其他处理人
+func checkPass(step FlowStep, handlers []FlowHandler) (goNext, goBack bool) {
+	var total = len(handlers)
+	var pass, nopass int
+	for _, record := range handlers {
+		if record.IsFinish() && record.GetConclusion() {
+			pass++
+		}
+		if record.IsFinish() && !record.GetConclusion() {
+			nopass++
+		}
+	}
+	if total == 0 {
+		return
+	}
+	return pass*100/total >= step.PassRate(), nopass*100/total > 100-step.PassRate()
+}
@@ -3,8 +3,10 @@ package flowservice
 import (
 	"io"
 	"reflect"
+	"sort"
 
 	"github.com/daimall/tools/curd/common"
+	oplog "github.com/daimall/tools/curd/oplog"
 	"gorm.io/gorm"
 )
 
@@ -75,6 +77,16 @@ type GetAllInf interface {
 		limit int) (ret interface{}, count int64, oplog string, err error)
 }
 
+type GetAllByRequestInf interface {
+	// 按请求参数查询，支持游标分页，优先于 GetAllInf
+	GetAllByRequest(uname string, req *common.ListRequest) (page *common.Page, oplog string, err error)
+}
+
+type QueryFieldsInf interface {
+	// 允许查询、排序和返回的字段，未实现时只校验字段名格式
+	QueryFields() []string
+}
+
 type UpdateInf interface {
 	// 更新对象
 	Update(flowid uint, fields []string, c common.BaseController) (ret interface{}, oplog string, err error) // 刷新流程基础信息
@@ -102,6 +114,11 @@ type OplogModelInf interface {
 	OplogModel(uname, flow string, flowid uint, action, remark string) interface{}
 }
 
+// 更新时的字段变更（审计），CommFlow 已实现（BaseUpdate 记录）
+type ChangesInf interface {
+	Changes() []oplog.Change
+}
+
 // 导入接口
 type Import interface {
 	// 导入操作
@@ -115,6 +132,11 @@ type Export interface {
 		sortby []string, order []string) (content io.ReadSeeker, oplog string, err error)
 }
 
+// 按请求参数导出，优先于 Export，单次条数上限为 ExportMaxLimit
+type ExportByRequestInf interface {
+	ExportByRequest(uname string, req *common.ListRequest) (content io.ReadSeeker, oplog string, err error)
+}
+
 type OpHistoryInf interface {
 	// 获取操作状态
 	GetOpHistory() (ret interface{}, oplog string, err error)
@@ -147,6 +169,10 @@ type GetCurStepInf interface {
 	// 获取当前步骤
 	GetCurStep() (step FlowStep, err error)
 }
+type GetCurStepKeyInf interface {
+	// 当前步骤的标识，有流程定义时按定义查找当前步骤（见 CurStepOf），CommFlow 已实现
+	GetCurStepKey() string
+}
 type GetNextStepInf interface {
 	// 获取下一步
 	GetNextStep() (step FlowStep, err error)
@@ -168,6 +194,7 @@ type SetValueInf interface {
 var services = make(map[string]FlowService)
 
 //Register 注册新类型的服务
+// service 实现了 DefinitionInf 时校验流程定义，定义有误时 panic
 func Register(serviceType string, service FlowService) {
 	if service == nil {
 		panic("service: Register error, service is nil")
@@ -175,9 +202,28 @@ func Register(serviceType string, service FlowService) {
 	if _, ok := services[serviceType]; ok {
 		panic("service: Register called twice for flow " + serviceType)
 	}
+	if dapp, ok := service.(DefinitionInf); ok {
+		def := dapp.FlowDefinition()
+		if def == nil {
+			panic("service: flow definition of " + serviceType + " is nil")
+		}
+		if err := def.Validate(service); err != nil {
+			panic("service: invalid flow definition of " + serviceType + ", " + err.Error())
+		}
+		definitions[serviceType] = def
+	}
 	services[serviceType] = service
 }
 
+// ServiceNames 已注册的服务名称（已排序）
+func ServiceNames() (names []string) {
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
 // GetService 获取Servie 对象
 func GetService(serviceType string) FlowService {
 	if v, ok := services[serviceType]; ok {
@@ -0,0 +1,267 @@
+package oplog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/io/file/txcloud"
+	"gorm.io/gorm"
+)
+
+// ArchiveStore 归档文件的存储
+type ArchiveStore interface {
+	// Save 保存归档文件，返回用于 Open 的位置
+	Save(name string, r io.Reader) (location string, err error)
+	Open(location string) (io.ReadCloser, error)
+}
+
+// LocalStore 保存到本地目录
+type LocalStore struct {
+	Dir string
+}
+
+func (s LocalStore) Save(name string, r io.Reader) (location string, err error) {
+	location = filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err = os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Create(location); err != nil {
+		return
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return
+	}
+	return location, f.Close()
+}
+
+func (s LocalStore) Open(location string) (io.ReadCloser, error) {
+	return os.Open(location)
+}
+
+// TxCloudStore 保存到腾讯云COS（配置见 txcloud 包，配置了 TXCloud::EncryptKey 时加密保存）
+type TxCloudStore struct {
+	Prefix string // 对象键前缀
+}
+
+func (s TxCloudStore) Save(name string, r io.Reader) (location string, err error) {
+	location = s.Prefix + name
+	_, err = txcloud.UploadFile(r, location)
+	return
+}
+
+func (s TxCloudStore) Open(location string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(txcloud.DownloadFile(location, pw))
+	}()
+	return pr, nil
+}
+
+// StoreFromConf 归档存储配置
+// [OpLog]
+// ArchiveStore = local  # local txcloud
+// ArchiveDir = /data/oplog-archive  # 本地目录或者COS对象键前缀
+func StoreFromConf() (store ArchiveStore, err error) {
+	dir := beego.AppConfig.String("OpLog::ArchiveDir")
+	switch kind := beego.AppConfig.DefaultString("OpLog::ArchiveStore", "local"); kind {
+	case "local":
+		if dir == "" {
+			dir = "oplog-archive"
+		}
+		return LocalStore{Dir: dir}, nil
+	case "txcloud":
+		return TxCloudStore{Prefix: dir}, nil
+	default:
+		return nil, fmt.Errorf("invalid OpLog::ArchiveStore %s", kind)
+	}
+}
+
+// Archive 一个归档文件的记录
+type Archive struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	Table     string    `gorm:"size:100;column:tbl;index" json:"table"`
+	Rule      string    `gorm:"size:100;column:rule" json:"rule"` // 归档使用的保留规则
+	Location  string    `gorm:"size:500;column:location" json:"location"`
+	FirstID   uint      `gorm:"column:first_id" json:"firstId"`
+	LastID    uint      `gorm:"column:last_id" json:"lastId"`
+	Count     int       `gorm:"column:count" json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Archive) TableName() string {
+	return "op_log_archives"
+}
+
+// ArchivedLink 归档删除的一条链上日志的hash和prev_hash，校验哈希链时用来接上被归档的缺口（见 VerifyChain）
+type ArchivedLink struct {
+	ID        uint   `gorm:"primary_key" json:"id"`
+	Table     string `gorm:"size:100;column:tbl;index:idx_op_log_archived_link" json:"table"`
+	Hash      string `gorm:"size:64;column:hash;index:idx_op_log_archived_link" json:"hash"`
+	PrevHash  string `gorm:"size:64;column:prev_hash" json:"prevHash"`
+	ArchiveID uint   `gorm:"column:archive_id;index" json:"archiveId"`
+}
+
+func (ArchivedLink) TableName() string {
+	return "op_log_archived_links"
+}
+
+// 一次归档的最大条数，每批一个文件
+const archiveBatchSize = 1000
+
+// 归档查询出的一批日志：写入gzip压缩的JSONL文件、记录归档、删除日志
+func archiveBatch(db *gorm.DB, tblName, rule string, store ArchiveStore, rows []map[string]interface{}, now time.Time) (archive *Archive, err error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	ids := make([]interface{}, 0, len(rows))
+	var links []ArchivedLink
+	for _, row := range rows {
+		if err = enc.Encode(row); err != nil {
+			return
+		}
+		ids = append(ids, row["id"])
+		if hash := toString(row["hash"]); hash != "" {
+			links = append(links, ArchivedLink{Table: tblName, Hash: hash, PrevHash: toString(row["prev_hash"])})
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+	archive = &Archive{Table: tblName, Rule: rule, Count: len(rows), CreatedAt: now,
+		FirstID: toUint(rows[0]["id"]), LastID: toUint(rows[len(rows)-1]["id"])}
+	name := fmt.Sprintf("%s/%s-%d-%d.jsonl.gz", tblName, now.Format("20060102150405"), archive.FirstID, archive.LastID)
+	if archive.Location, err = store.Save(name, &buf); err != nil {
+		logs.Error("save oplog archive %s failed, %s", name, err.Error())
+		return nil, err
+	}
+	// 文件保存成功后再删除，删除失败时下次会重复归档这些日志
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(archive).Error; err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			for i := range links {
+				links[i].ArchiveID = archive.ID
+			}
+			if err := tx.CreateInBatches(links, 200).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Table(tblName).Where("id IN ?", ids).Delete(&OpLog{}).Error
+	})
+	if err != nil {
+		logs.Error("delete archived oplogs of %s failed, %s", archive.Location, err.Error())
+		return nil, err
+	}
+	return archive, nil
+}
+
+func toUint(v interface{}) uint {
+	switch id := v.(type) {
+	case int64:
+		return uint(id)
+	case uint64:
+		return uint(id)
+	case int32:
+		return uint(id)
+	case uint32:
+		return uint(id)
+	case int:
+		return uint(id)
+	case uint:
+		return id
+	}
+	return 0
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+// Import 导入归档文件到 tblName 用于调查（为空时为原表名加 _archive），表不存在时按 oplogModel 创建，返回导入的条数
+func Import(db *gorm.DB, store ArchiveStore, archive *Archive, tblName string, oplogModel interface{}) (count int, err error) {
+	if oplogModel == nil {
+		oplogModel = &OpLog{}
+	}
+	if tblName == "" {
+		tblName = archive.Table + "_archive"
+	}
+	if !db.Migrator().HasTable(tblName) {
+		if err = db.Table(tblName).AutoMigrate(oplogModel); err != nil {
+			logs.Error("create table %s failed, %s", tblName, err.Error())
+			return
+		}
+	}
+	var rc io.ReadCloser
+	if rc, err = store.Open(archive.Location); err != nil {
+		return
+	}
+	defer rc.Close()
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	batch := make([]map[string]interface{}, 0, 100)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := db.Table(tblName).Create(&batch).Error; err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for scanner.Scan() {
+		row := map[string]interface{}{}
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		if err = dec.Decode(&row); err != nil {
+			return
+		}
+		for k, v := range row {
+			// JSON 中的时间还原为 time.Time，整数还原为 int64
+			if s, ok := v.(string); ok {
+				if t, perr := time.Parse(time.RFC3339Nano, s); perr == nil {
+					row[k] = t
+				}
+			} else if n, ok := v.(json.Number); ok {
+				if i, perr := n.Int64(); perr == nil {
+					row[k] = i
+				} else {
+					row[k] = n.String()
+				}
+			}
+		}
+		if batch = append(batch, row); len(batch) >= cap(batch) {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	err = flush()
+	return
+}
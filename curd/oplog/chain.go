@@ -0,0 +1,375 @@
+package oplog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/aes/secrets"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"github.com/daimall/tools/sign"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 防篡改的哈希链：每条日志保存内容的hash和上一条日志的hash，修改或者删除中间的日志都会使链断开
+// 链的范围（OpLog::HashChain）：
+const (
+	ChainGlobal = "global" // 整张表一条链
+	ChainFlow   = "flow"   // 每个流程实例（flow + flow_id）一条链
+)
+
+// ChainMode 哈希链模式，空表示不开启
+func ChainMode() string {
+	return beego.AppConfig.String("OpLog::HashChain")
+}
+
+// Chainable 参与哈希链的日志模型，OpLog 和嵌入 OpLog 的自定义模型已实现
+type Chainable interface {
+	ChainEntry() *OpLog
+}
+
+// ChainEntry 参与哈希链的日志
+func (l *OpLog) ChainEntry() *OpLog {
+	return l
+}
+
+// ComputeHash 日志内容（含 PrevHash）的hash，创建时间使用UTC的秒级时间戳，和数据库保存的时区无关
+func (l *OpLog) ComputeHash() string {
+	data, _ := json.Marshal([]interface{}{l.PrevHash, l.User, l.Action, l.FlowId, l.Flow, l.Remark, l.Changes, l.CreatedAt.UTC().Unix()})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChainHead 每条链的最后一条hash，写入同一条链时锁定这一行（包括链的第一条）
+type ChainHead struct {
+	ID    uint   `gorm:"primary_key" json:"id"`
+	Table string `gorm:"size:100;column:tbl;uniqueIndex:uix_op_log_chain_head" json:"table"`
+	Chain string `gorm:"size:150;column:chain;uniqueIndex:uix_op_log_chain_head" json:"chain"` // 链的标识，见 chainKey
+	Hash  string `gorm:"size:64;column:hash" json:"hash"`
+}
+
+func (ChainHead) TableName() string {
+	return "op_log_chain_heads"
+}
+
+// 日志所在的链，整张表一条链时为空
+func chainKey(entry *OpLog, mode string) string {
+	if mode == ChainFlow {
+		return fmt.Sprintf("%s:%d", entry.Flow, entry.FlowId)
+	}
+	return ""
+}
+
+// 链上的上一条日志
+func chainScope(db *gorm.DB, entry *OpLog, mode string) *gorm.DB {
+	db = db.Where("hash <> ''")
+	if mode == ChainFlow {
+		db = db.Where("flow = ? AND flow_id = ?", entry.Flow, entry.FlowId)
+	}
+	return db
+}
+
+// 锁定链头，不存在时创建（唯一索引保证并发时只有一个创建成功，其他等待）
+func lockChainHead(tx *gorm.DB, tblName, key string) (head ChainHead, err error) {
+	if err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ChainHead{Table: tblName, Chain: key}).Error; err != nil {
+		logs.Error("create oplog chain head failed,", err.Error())
+		return
+	}
+	q := tx.Where("tbl = ? AND chain = ?", tblName, key)
+	if dbgorm.Dialect(tx) != dbgorm.DialectSQLite {
+		q = q.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	err = q.First(&head).Error
+	return
+}
+
+// AppendChained 计算hash并写入一条日志，在同一个事务中锁定链头（ChainHead），同一条链的写入串行执行
+func AppendChained(db *gorm.DB, oplogModel interface{}, mode string) error {
+	c, ok := oplogModel.(Chainable)
+	if !ok {
+		return db.Create(oplogModel).Error
+	}
+	entry := c.ChainEntry()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(oplogModel); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		head, err := lockChainHead(tx, stmt.Table, chainKey(entry, mode))
+		if err != nil {
+			return err
+		}
+		entry.PrevHash = head.Hash
+		if head.Hash == "" {
+			// 开启链头之前写入的日志
+			var prev []string
+			if err = chainScope(tx.Model(oplogModel), entry, mode).Order("id desc").Limit(1).Pluck("hash", &prev).Error; err != nil {
+				return err
+			}
+			if len(prev) > 0 {
+				entry.PrevHash = prev[0]
+			}
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+		}
+		// 按UTC保存到秒，读回的时间和计算hash时一致
+		entry.CreatedAt = entry.CreatedAt.Truncate(time.Second).UTC()
+		entry.Hash = entry.ComputeHash()
+		if err = tx.Create(oplogModel).Error; err != nil {
+			return err
+		}
+		return tx.Model(&head).Update("hash", entry.Hash).Error
+	})
+}
+
+// ChainBreak 链断开的位置
+type ChainBreak struct {
+	ID     uint   `json:"id"`     // 第一条校验失败的日志
+	Reason string `json:"reason"` // hash mismatch（内容被修改）或者 prev_hash mismatch（前面的日志被删除或者修改）
+}
+
+// VerifyChain 按id顺序校验整张表的哈希链，返回第一个断开的位置（nil 表示完整）和校验的条数
+// tblName 为空时使用 OpLog 的表名；没有hash的日志（开启前写入的）跳过
+func VerifyChain(db *gorm.DB, tblName, mode string) (broken *ChainBreak, checked int64, err error) {
+	if tblName == "" {
+		tblName = OpLog{}.TableName()
+	}
+	last := map[string]string{} // 链 -> 最后一条的hash
+	archived := archivedLinks(db, tblName)
+	var batch []OpLog
+	err = db.Table(tblName).Where("hash <> ''").Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			l := &batch[i]
+			key := chainKey(l, mode)
+			checked++
+			if l.PrevHash != last[key] && !archived.connects(l.PrevHash, last[key]) {
+				broken = &ChainBreak{ID: l.ID, Reason: "prev_hash mismatch"}
+			} else if l.ComputeHash() != l.Hash {
+				broken = &ChainBreak{ID: l.ID, Reason: "hash mismatch"}
+			}
+			if broken != nil {
+				return errStopWalk
+			}
+			last[key] = l.Hash
+		}
+		return nil
+	}).Error
+	if errors.Is(err, errStopWalk) {
+		err = nil
+	}
+	if err != nil {
+		logs.Error("verify oplog chain failed,", err.Error())
+	}
+	return
+}
+
+// 归档删除的日志 hash -> prev_hash
+type archivedChain map[string]string
+
+// 从 prev 沿归档的日志向前能否接到 last（链的上一条保留的日志，链开头为空），
+// 归档只删除保留期外的日志，缺口中的每一条都必须有归档记录，没有归档直接删除的仍然断开
+func (a archivedChain) connects(prev, last string) bool {
+	for n := 0; n <= len(a); n++ {
+		p, ok := a[prev]
+		if !ok {
+			return false
+		}
+		if prev = p; prev == last {
+			return true
+		}
+	}
+	return false
+}
+
+// 表的归档记录，没有归档时为空
+func archivedLinks(db *gorm.DB, tblName string) archivedChain {
+	ret := archivedChain{}
+	if !db.Migrator().HasTable(&ArchivedLink{}) {
+		return ret
+	}
+	var links []ArchivedLink
+	err := db.Select("id", "hash", "prev_hash").Where("tbl = ?", tblName).FindInBatches(&links, 1000, func(tx *gorm.DB, _ int) error {
+		for _, l := range links {
+			ret[l.Hash] = l.PrevHash
+		}
+		return nil
+	}).Error
+	if err != nil {
+		logs.Error("query oplog archived links failed,", err.Error())
+	}
+	return ret
+}
+
+var (
+	errStopWalk = errors.New("stop walk")
+	// ErrNoCheckpointKey 未配置检查点签名密钥
+	ErrNoCheckpointKey = errors.New("OpLog::CheckpointKey not configured")
+)
+
+// Checkpoint 签名的检查点：Digest 为上一个检查点的 Digest 加上 (上一个检查点, LastID] 之间所有日志hash的摘要，
+// 用 sign.HMACSHA256 签名（密钥 OpLog::CheckpointKey，可加密），保存到数据库外的副本可以证明检查点之前的日志没有被整体重写
+// sign.Sign 的参数签名是和调用方约定的 sha1 方案（密钥拼接在原文中），不适合服务端自己签发的数据，所以使用 HMAC
+type Checkpoint struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	Table     string    `gorm:"size:100;column:tbl;index" json:"table"`
+	LastID    uint      `gorm:"column:last_id" json:"lastId"`
+	Count     uint      `gorm:"column:count" json:"count"` // 本检查点包含的日志条数
+	Digest    string    `gorm:"size:64;column:digest" json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+	Sign      string    `gorm:"size:64;column:sign" json:"sign"`
+}
+
+func (Checkpoint) TableName() string {
+	return "op_log_checkpoints"
+}
+
+// 检查点签名的密钥
+func checkpointKey() (key []byte, err error) {
+	var v string
+	if v, err = secrets.Resolve("OpLog", "CheckpointKey"); err != nil {
+		return
+	}
+	if v == "" {
+		return nil, ErrNoCheckpointKey
+	}
+	return []byte(v), nil
+}
+
+// 检查点签名的内容，创建时间使用UTC的时间戳，避免读回的时区不同
+func (c *Checkpoint) signData() []byte {
+	data, _ := json.Marshal([]interface{}{c.Table, c.LastID, c.Count, c.Digest, c.CreatedAt.UTC().Unix()})
+	return data
+}
+
+// 累加 (afterID, ...] 之间日志的hash，最多到 untilID（0 不限制）
+func digestSince(db *gorm.DB, tblName string, afterID, untilID uint, digest string) (lastID, count uint, ret string, err error) {
+	h := sha256.New()
+	h.Write([]byte(digest))
+	lastID = afterID
+	q := db.Table(tblName).Where("hash <> '' AND id > ?", afterID)
+	if untilID > 0 {
+		q = q.Where("id <= ?", untilID)
+	}
+	var batch []OpLog
+	err = q.Select("id", "hash").Order("id").FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, l := range batch {
+			h.Write([]byte(l.Hash))
+			lastID = l.ID
+			count++
+		}
+		return nil
+	}).Error
+	return lastID, count, hex.EncodeToString(h.Sum(nil)), err
+}
+
+// CreateCheckpoint 为上一个检查点之后的日志创建签名检查点，没有新日志时返回nil
+func CreateCheckpoint(db *gorm.DB, tblName string) (cp *Checkpoint, err error) {
+	if tblName == "" {
+		tblName = OpLog{}.TableName()
+	}
+	key, err := checkpointKey()
+	if err != nil {
+		return
+	}
+	var prev Checkpoint
+	if err = db.Where("tbl = ?", tblName).Order("id desc").Limit(1).Find(&prev).Error; err != nil {
+		return
+	}
+	lastID, count, digest, err := digestSince(db, tblName, prev.LastID, 0, prev.Digest)
+	if err != nil || count == 0 {
+		return
+	}
+	cp = &Checkpoint{Table: tblName, LastID: lastID, Count: count, Digest: digest, CreatedAt: time.Now().Truncate(time.Second).UTC()}
+	cp.Sign = sign.HMACSHA256(key, cp.signData())
+	if err = db.Create(cp).Error; err != nil {
+		logs.Error("create oplog checkpoint failed,", err.Error())
+		return nil, err
+	}
+	return cp, nil
+}
+
+// VerifyCheckpoints 校验所有检查点的签名，并按日志重新计算摘要，返回第一个不一致的检查点（nil 表示全部一致）
+// 包含已归档日志的检查点只校验签名
+func VerifyCheckpoints(db *gorm.DB, tblName string) (bad *Checkpoint, err error) {
+	if tblName == "" {
+		tblName = OpLog{}.TableName()
+	}
+	key, err := checkpointKey()
+	if err != nil {
+		return
+	}
+	var cps []Checkpoint
+	if err = db.Where("tbl = ?", tblName).Order("id").Find(&cps).Error; err != nil {
+		return
+	}
+	var archives []Archive
+	if db.Migrator().HasTable(&Archive{}) {
+		if err = db.Where("tbl = ?", tblName).Find(&archives).Error; err != nil {
+			return
+		}
+	}
+	var afterID uint
+	var digest string
+	for i := range cps {
+		cp := &cps[i]
+		if !sign.VerifyHMACSHA256(key, cp.signData(), cp.Sign) {
+			return cp, nil
+		}
+		archived := false
+		for _, a := range archives {
+			archived = archived || (a.FirstID <= cp.LastID && a.LastID > afterID)
+		}
+		if archived {
+			afterID, digest = cp.LastID, cp.Digest
+			continue
+		}
+		_, count, d, derr := digestSince(db, tblName, afterID, cp.LastID, digest)
+		if derr != nil {
+			return nil, derr
+		}
+		if count != cp.Count || d != cp.Digest {
+			return cp, nil
+		}
+		afterID, digest = cp.LastID, cp.Digest
+	}
+	return nil, nil
+}
+
+// StartCheckpoints 按间隔（OpLog::CheckpointInterval，默认1h）创建检查点，返回停止函数
+func StartCheckpoints(db *gorm.DB, tblName string) (stop func()) {
+	interval := time.Hour
+	if v := beego.AppConfig.String("OpLog::CheckpointInterval"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		} else {
+			logs.Error("invalid OpLog::CheckpointInterval %s, use %s", v, interval)
+		}
+	}
+	if _, err := checkpointKey(); err != nil {
+		logs.Error("oplog checkpoints not started,", err.Error())
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := CreateCheckpoint(db, tblName); err != nil {
+					logs.Error("oplog checkpoint failed,", err.Error())
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
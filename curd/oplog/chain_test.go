@@ -0,0 +1,119 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+)
+
+func Test_HashChain(t *testing.T) {
+	db := testdb.Open(t)
+	if err := db.AutoMigrate(&OpLog{}, &Checkpoint{}, &ChainHead{}); err != nil {
+		t.Fatal(err)
+	}
+	// 其他时区、带纳秒的创建时间按UTC保存到秒
+	cst := time.FixedZone("CST", 8*3600)
+	for i := uint(1); i <= 6; i++ {
+		l := &OpLog{User: "u", Action: OP_ACTION_ALTER, Flow: "f", FlowId: i % 2, Remark: "r", CreatedAt: time.Now().In(cst)}
+		if err := AppendChained(db, l, ChainFlow); err != nil {
+			t.Fatal(err)
+		}
+		if l.CreatedAt.Location() != time.UTC || l.CreatedAt.Nanosecond() != 0 {
+			t.Fatalf("expect utc seconds, got %v", l.CreatedAt)
+		}
+	}
+	if broken, checked, err := VerifyChain(db, "", ChainFlow); err != nil || broken != nil || checked != 6 {
+		t.Fatalf("expect intact chain, got %+v %d %v", broken, checked, err)
+	}
+	// 同一个流程实例的上一条
+	var third OpLog
+	db.First(&third, 3)
+	if third.PrevHash == "" || third.PrevHash == third.Hash {
+		t.Fatalf("unexpected prev hash %+v", third)
+	}
+	// 每条链一个链头，保存最后一条的hash
+	var heads []ChainHead
+	db.Order("chain").Find(&heads)
+	var last OpLog
+	db.Last(&last)
+	if len(heads) != 2 || heads[0].Chain != "f:0" || heads[0].Hash != last.Hash {
+		t.Fatalf("unexpected chain heads %+v", heads)
+	}
+
+	beego.AppConfig.Set("OpLog::CheckpointKey", "checkpoint-key")
+	defer beego.AppConfig.Set("OpLog::CheckpointKey", "")
+	cp, err := CreateCheckpoint(db, "")
+	if err != nil || cp == nil || cp.Count != 6 || cp.LastID != 6 {
+		t.Fatalf("unexpected checkpoint %+v %v", cp, err)
+	}
+	if cp, err = CreateCheckpoint(db, ""); err != nil || cp != nil {
+		t.Fatalf("expect no checkpoint without new logs, got %+v %v", cp, err)
+	}
+	if bad, err := VerifyCheckpoints(db, ""); err != nil || bad != nil {
+		t.Fatalf("expect valid checkpoints, got %+v %v", bad, err)
+	}
+
+	// 修改内容
+	db.Model(&OpLog{}).Where("id = ?", 3).Update("remark", "tampered")
+	if broken, _, _ := VerifyChain(db, "", ChainFlow); broken == nil || broken.ID != 3 || broken.Reason != "hash mismatch" {
+		t.Errorf("expect hash mismatch at 3, got %+v", broken)
+	}
+	// 删除后同一条链的下一条断开
+	db.Delete(&OpLog{}, 3)
+	if broken, _, _ := VerifyChain(db, "", ChainFlow); broken == nil || broken.ID != 5 || broken.Reason != "prev_hash mismatch" {
+		t.Errorf("expect prev_hash mismatch at 5, got %+v", broken)
+	}
+	if bad, _ := VerifyCheckpoints(db, ""); bad == nil || bad.ID != 1 {
+		t.Errorf("expect checkpoint mismatch, got %+v", bad)
+	}
+	// 篡改检查点的摘要
+	db.Model(&Checkpoint{}).Where("id = ?", 1).Update("digest", "x")
+	if bad, _ := VerifyCheckpoints(db, ""); bad == nil {
+		t.Error("expect signature mismatch")
+	}
+}
+
+func Test_VerifyChainArchived(t *testing.T) {
+	db := testdb.Open(t)
+	if err := db.AutoMigrate(&OpLog{}, &ChainHead{}, &Archive{}, &ArchivedLink{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.Local)
+	old := now.AddDate(0, 0, -60)
+	// 同一个流程实例的链：查询和修改交替，按 action 保留会删除链中间的日志
+	for i, action := range []string{OP_ACTION_QUERY, OP_ACTION_ALTER, OP_ACTION_QUERY, OP_ACTION_QUERY, OP_ACTION_ALTER} {
+		l := &OpLog{User: "u", Action: action, Flow: "f", FlowId: 1, Remark: "r", CreatedAt: old.Add(time.Duration(i) * time.Minute)}
+		if err := AppendChained(db, l, ChainFlow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 另一条链全部归档后继续写入
+	if err := AppendChained(db, &OpLog{User: "u", Action: OP_ACTION_QUERY, Flow: "f", FlowId: 2, Remark: "r", CreatedAt: old}, ChainFlow); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseRetention("query=30d;alter=1y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ApplyRetention(db, nil, rules, LocalStore{Dir: t.TempDir()}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err = AppendChained(db, &OpLog{User: "u", Action: OP_ACTION_ALTER, Flow: "f", FlowId: 2, Remark: "r", CreatedAt: now}, ChainFlow); err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	db.Model(&OpLog{}).Order("id").Pluck("id", &ids)
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != 5 {
+		t.Fatalf("unexpected remaining logs %v", ids)
+	}
+	if broken, checked, err := VerifyChain(db, "", ChainFlow); err != nil || broken != nil || checked != 3 {
+		t.Fatalf("expect intact chain after archive, got %+v %d %v", broken, checked, err)
+	}
+	// 没有归档直接删除链开头的日志
+	db.Delete(&OpLog{}, 2)
+	if broken, _, _ := VerifyChain(db, "", ChainFlow); broken == nil || broken.ID != 5 || broken.Reason != "prev_hash mismatch" {
+		t.Errorf("expect prev_hash mismatch at 5, got %+v", broken)
+	}
+}
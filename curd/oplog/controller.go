@@ -0,0 +1,90 @@
+package oplog
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/common"
+	"gorm.io/gorm"
+)
+
+// Controller 操作日志查询和导出，每个路由使用自己的数据库链接和日志模型（beego 处理请求时复制导出的字段）
+type Controller struct {
+	common.BaseController
+	DB    *gorm.DB
+	Model interface{} // 日志模型，决定表名和返回的字段
+}
+
+// RegisterRouter 注册日志查询（GET path）和导出（GET path/export）路由，oplogModel 为空时使用 OpLog
+// 需要的权限控制通过 beego 过滤器实现
+func RegisterRouter(path string, db *gorm.DB, oplogModel interface{}) {
+	if oplogModel == nil {
+		oplogModel = &OpLog{}
+	}
+	beego.Router(path, &Controller{DB: db, Model: oplogModel}, "get:GetAll")
+	beego.Router(strings.TrimRight(path, "/")+"/export", &Controller{DB: db, Model: oplogModel}, "get:Export")
+}
+
+// 请求中的过滤条件：since/until 时间范围，q 全文检索备注
+// 操作者和行为使用 query 参数，例如 user|multi-select:a|b,action|multi-select:add|alter
+func (c *Controller) filter() Filter {
+	return Filter{
+		Since:   c.GetString("since"),
+		Until:   c.GetString("until"),
+		Keyword: c.GetString("q"),
+	}
+}
+
+// GetAll ...
+// @Title 查询操作日志
+// @Param	query	query	string	false	"Filter. e.g. user|multi-select:a|b,created_at|date-range:2022-01-01|2022-01-31"
+// @Param	since	query	string	false	"开始时间"
+// @Param	until	query	string	false	"结束时间"
+// @Param	q	query	string	false	"全文检索备注"
+// @Success 200 {object} common.Page
+// @router / [get]
+func (c *Controller) GetAll() {
+	req, err := ParseSearchRequest(c.Ctx, false)
+	if err != nil {
+		c.JSONResponse(err)
+		return
+	}
+	// 按日志模型的类型返回，包含自定义模型的字段
+	logList := reflect.New(reflect.SliceOf(reflect.TypeOf(c.Model).Elem())).Interface()
+	page, err := Search(c.DB, c.Model, logList, c.filter(), req)
+	if err != nil {
+		c.JSONResponse(err)
+		return
+	}
+	c.JSONResponse(nil, page)
+}
+
+// Export ...
+// @Title 导出操作日志
+// @Param	format	query	string	false	"csv（默认）xlsx"
+// @Success 200 file
+// @router /export [get]
+func (c *Controller) Export() {
+	req, err := ParseSearchRequest(c.Ctx, true)
+	if err != nil {
+		c.JSONResponse(err)
+		return
+	}
+	format := c.GetString("format", ExportCSV)
+	var buf bytes.Buffer
+	if _, err = Export(c.DB, c.Model, &buf, format, c.filter(), req); err != nil {
+		c.JSONResponse(err)
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == ExportXLSX {
+		contentType = common.XLSXContentType
+	}
+	c.Ctx.ResponseWriter.Header().Set("Content-Disposition", "attachment; filename=oplog."+format)
+	c.Ctx.ResponseWriter.Header().Set("Content-Type", contentType)
+	http.ServeContent(c.Ctx.ResponseWriter, c.Ctx.Request, "oplog."+format, time.Now(), bytes.NewReader(buf.Bytes()))
+}
@@ -0,0 +1,59 @@
+package oplog
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+)
+
+type ctrlLog struct {
+	OpLog
+	Extra string `gorm:"size:100" json:"extra"`
+}
+
+func Test_RegisterRouter(t *testing.T) {
+	db1, db2 := testdb.Open(t), testdb.Open(t)
+	if err := db1.AutoMigrate(&OpLog{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db2.AutoMigrate(&ctrlLog{}); err != nil {
+		t.Fatal(err)
+	}
+	db1.Create(&OpLog{User: "alice", Action: OP_ACTION_ADD})
+	db2.Create(&ctrlLog{OpLog: OpLog{User: "bob", Action: OP_ACTION_ALTER}, Extra: "x"})
+	// 第二次注册不影响第一个路由
+	RegisterRouter("/oplog-a", db1, nil)
+	RegisterRouter("/oplog-b", db2, &ctrlLog{})
+
+	get := func(path string) (items []map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		beego.BeeApp.Handlers.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var ret struct {
+			Data struct {
+				Items []map[string]interface{} `json:"items"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &ret); err != nil {
+			t.Fatalf("%s: %s %s", path, err.Error(), rec.Body.String())
+		}
+		return ret.Data.Items
+	}
+	if items := get("/oplog-a"); len(items) != 1 || items[0]["user"] != "alice" {
+		t.Errorf("unexpected logs of a: %v", items)
+	}
+	if items := get("/oplog-b"); len(items) != 1 || items[0]["user"] != "bob" || items[0]["extra"] != "x" {
+		t.Errorf("unexpected logs of b: %v", items)
+	}
+	// AMIS 模式下 q/since/until 不作为查询条件
+	beego.AppConfig.Set("webKind", "AMIS")
+	defer beego.AppConfig.Set("webKind", "BS")
+	if items := get("/oplog-a?q=none&since=2000-01-01&user=alice"); len(items) != 0 {
+		t.Errorf("expect no logs matching q, got %v", items)
+	}
+	if items := get("/oplog-a?since=2000-01-01&until=2100-01-01&user=alice"); len(items) != 1 {
+		t.Errorf("unexpected logs of a in amis mode: %v", items)
+	}
+}
@@ -0,0 +1,140 @@
+package oplog
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"gorm.io/gorm"
+)
+
+// Change 一个字段的变更，字段名为json名称
+type Change struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// ChangesSetter 自定义的日志模型实现该接口后可以记录字段变更（OpLog 已实现）
+type ChangesSetter interface {
+	SetChanges(changes string)
+}
+
+// 默认不比较的字段（每次更新都会变化）
+var defaultIgnoreFields = []string{"updated_at", "UpdatedAt"}
+
+// AuditEnabled 是否开启变更审计（OpLog::Audit），开启后更新操作记录字段级的变更
+func AuditEnabled() bool {
+	return beego.AppConfig.DefaultBool("OpLog::Audit", false)
+}
+
+// Snapshot 对象按json序列化后的字段快照
+func Snapshot(model interface{}) (snapshot map[string]interface{}, err error) {
+	var data []byte
+	if data, err = json.Marshal(model); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &snapshot)
+	return
+}
+
+// Diff 比较两个快照，返回按字段名排序的变更，ignore 为不比较的字段
+func Diff(before, after map[string]interface{}, ignore ...string) (changes []Change) {
+	skip := map[string]bool{}
+	for _, f := range defaultIgnoreFields {
+		skip[f] = true
+	}
+	for _, f := range ignore {
+		skip[f] = true
+	}
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+	for field := range fields {
+		if skip[field] {
+			continue
+		}
+		if old, cur := before[field], after[field]; !reflect.DeepEqual(old, cur) {
+			changes = append(changes, Change{Field: field, Old: old, New: cur})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return
+}
+
+// DiffModels 比较两个对象，出错时记录日志并返回空
+func DiffModels(before, after interface{}, ignore ...string) []Change {
+	b, err := Snapshot(before)
+	if err != nil {
+		logs.Error("snapshot before failed,", err.Error())
+		return nil
+	}
+	a, err := Snapshot(after)
+	if err != nil {
+		logs.Error("snapshot after failed,", err.Error())
+		return nil
+	}
+	return Diff(b, a, ignore...)
+}
+
+// EncodeChanges 变更序列化为JSON，没有变更时为空字符串
+func EncodeChanges(changes []Change) string {
+	if len(changes) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		logs.Error("marshal changes failed,", err.Error())
+		return ""
+	}
+	return string(data)
+}
+
+// DecodeChanges 解析 EncodeChanges 的结果
+func DecodeChanges(s string) (changes []Change, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	err = json.Unmarshal([]byte(s), &changes)
+	return
+}
+
+// TimelineEntry 变更时间线上的一次操作
+type TimelineEntry struct {
+	ID        uint      `json:"id"`
+	User      string    `json:"user"`
+	Action    string    `json:"action"`
+	Remark    string    `json:"remark"`
+	Changes   []Change  `json:"changes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Timeline 记录的字段变更时间线（按时间先后），tblName 为空时使用 OpLog 的表名
+func Timeline(dbInst *gorm.DB, tblName, flow string, flowId uint) (entries []TimelineEntry, err error) {
+	if tblName == "" {
+		tblName = OpLog{}.TableName()
+	}
+	var logList []OpLog
+	if err = dbInst.Table(tblName).Where("flow = ? AND flow_id = ?", flow, flowId).
+		Where("changes IS NOT NULL AND changes <> ''").Order("id").Find(&logList).Error; err != nil {
+		logs.Error("get change timeline failed,", err.Error())
+		return
+	}
+	entries = make([]TimelineEntry, 0, len(logList))
+	for _, l := range logList {
+		entry := TimelineEntry{ID: l.ID, User: l.User, Action: l.Action, Remark: l.Remark, CreatedAt: l.CreatedAt}
+		if entry.Changes, err = DecodeChanges(l.Changes); err != nil {
+			logs.Error("decode changes of oplog[%d] failed, %s", l.ID, err.Error())
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,33 @@
+package oplog
+
+import (
+	"testing"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+)
+
+func Test_Timeline(t *testing.T) {
+	db := testdb.Open(t)
+	if err := db.AutoMigrate(&OpLog{}); err != nil {
+		t.Fatal(err)
+	}
+	type item struct {
+		Name  string            `json:"name"`
+		Count int               `json:"count"`
+		Attrs map[string]string `json:"attrs"`
+	}
+	changes := DiffModels(item{Name: "a", Count: 1, Attrs: map[string]string{"k": "v"}},
+		item{Name: "b", Count: 1, Attrs: map[string]string{"k": "w"}})
+	if len(changes) != 2 || changes[0].Field != "attrs" || changes[1].Field != "name" {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	db.Create(&OpLog{User: "u", Action: "Update", Flow: "f", FlowId: 1, Remark: DEFAULT_REMARK, Changes: EncodeChanges(changes)})
+	db.Create(&OpLog{User: "u", Action: "Next", Flow: "f", FlowId: 1, Remark: "no changes"})
+	entries, err := Timeline(db, "", "f", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || len(entries[0].Changes) != 2 || entries[0].Changes[1].New != "b" {
+		t.Errorf("unexpected timeline %+v", entries)
+	}
+}
@@ -0,0 +1,77 @@
+package oplog
+
+import (
+	"fmt"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/migrate"
+	"gorm.io/gorm"
+)
+
+// 数据库迁移，见 migrate 包
+func init() {
+	migrate.Register(migrate.Model(2022010100, "create op_log", &OpLog{}))
+	migrate.Register(migrate.Migration{
+		Version: 2022010200,
+		Name:    "add op_log changes",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&OpLog{}, "Changes") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&OpLog{}, "Changes")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&OpLog{}, "Changes")
+		},
+	})
+	migrate.Register(migrate.Migration{
+		Version: 2022010201,
+		Name:    "add op_log hash chain",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"PrevHash", "Hash"} {
+				if tx.Migrator().HasColumn(&OpLog{}, field) {
+					continue
+				}
+				if err := tx.Migrator().AddColumn(&OpLog{}, field); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&OpLog{}, "Hash") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&OpLog{}, "Hash")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&OpLog{}, "Hash") {
+				if err := tx.Migrator().DropIndex(&OpLog{}, "Hash"); err != nil {
+					return err
+				}
+			}
+			if err := tx.Migrator().DropColumn(&OpLog{}, "Hash"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&OpLog{}, "PrevHash")
+		},
+	})
+	migrate.Register(migrate.Model(2022010202, "create op_log_checkpoints", &Checkpoint{}))
+	migrate.Register(migrate.Migration{
+		Version: 2022010203,
+		Name:    "add op_log remark fulltext index",
+		// 只有mysql创建（ngram 分词支持中文），其他数据库按词模糊匹配，见 SearchRemark
+		Up: func(tx *gorm.DB) error {
+			if dbgorm.Dialect(tx) != dbgorm.DialectMySQL || tx.Migrator().HasIndex(&OpLog{}, "ft_op_log_remark") {
+				return nil
+			}
+			return tx.Exec(fmt.Sprintf("CREATE FULLTEXT INDEX ft_op_log_remark ON %s (remark) WITH PARSER ngram", OpLog{}.TableName())).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if dbgorm.Dialect(tx) != dbgorm.DialectMySQL || !tx.Migrator().HasIndex(&OpLog{}, "ft_op_log_remark") {
+				return nil
+			}
+			return tx.Migrator().DropIndex(&OpLog{}, "ft_op_log_remark")
+		},
+	})
+	migrate.Register(migrate.Model(2022010204, "create op_log_archives", &Archive{}))
+	migrate.Register(migrate.Model(2022010205, "create op_log_chain_heads", &ChainHead{}))
+	migrate.Register(migrate.Model(2022010206, "create op_log_archived_links", &ArchivedLink{}))
+}
@@ -1,9 +1,6 @@
 package oplog
 
 import (
-	"errors"
-	"fmt"
-	"strings"
 	"time"
 
 	"github.com/astaxie/beego"
@@ -19,6 +16,9 @@ type OpLog struct {
 	FlowId    uint      `gorm:"column:flow_id;index"  json:"flowId"`        // FlowId
 	Flow      string    `gorm:"size:50;column:flow;index"  json:"flow"`     // FlowType
 	Remark    string    `gorm:"type:text;column:remark"  json:"remark"`     // 操作详情
+	Changes   string    `gorm:"type:text;column:changes"  json:"changes"`   // 字段变更（JSON，见 Change）
+	PrevHash  string    `gorm:"size:64;column:prev_hash"  json:"prevHash"`  // 哈希链上一条日志的hash（见 chain.go）
+	Hash      string    `gorm:"size:64;column:hash;index"  json:"hash"`     // 本条日志的hash
 	CreatedAt time.Time `json:"created_at"`                                 // 创建时间
 	UpdatedAt time.Time `json:"updated_at"`                                 // 最后更新时间
 }
@@ -30,6 +30,11 @@ func (OpLog) TableName() string {
 	return "op_log"
 }
 
+// 记录字段变更
+func (l *OpLog) SetChanges(changes string) {
+	l.Changes = changes
+}
+
 // 行为
 const (
 	DEFAULT_REMARK = "NA" // 默认的Remark值
@@ -39,78 +44,42 @@ const (
 	OP_ACTION_ALTER  = "alter"  // 改
 	OP_ACTION_QUERY  = "query"  // 查
 	OP_ACTION_IMPORT = "import" // 导入
+
+	OP_ACTION_REMIND   = "remind"   // 流程步骤到期提醒
+	OP_ACTION_ESCALATE = "escalate" // 流程步骤超时升级
+	OP_ACTION_TIMEOUT  = "timeout"  // 流程步骤超时处理
 )
 
 // 记录操作日志
-// AddOperationLog insert a new operation log into database and returns
-// last inserted Id on success.
+// AddOperationLog 加入该链接的异步写入队列（见 Writer，参数见 WriterOptionsFromConf），
+// 队列满且策略为 drop 时丢弃并记录错误日志；退出前调用 Flush/Close 或者 FlushOnShutdown 避免丢失
 func AddOperationLog(dbInst *gorm.DB, oplogModel interface{}) {
 	if dbInst == nil {
 		panic("oplog db[gorm instance] is nil")
 	}
-	go func() {
-		//tx := dbInst.Begin()
-		err := dbInst.Create(oplogModel).Error
-		if err != nil {
-			logs.Error("oprate log Create error %s", err.Error())
-			//tx.Rollback()
-		}
-		//tx.Commit()
-	}()
+	if err := writerFor(dbInst).Write(oplogModel); err != nil {
+		logs.Error("add operation log failed, %s, log: %+v", err.Error(), oplogModel)
+	}
 }
 
 // 查询操作日志
 // GetAllOpLog retrieves all oplogs matches certain condition. Returns empty list if
 // no records exist
+// 查询条件同 common.BuildQuery，分页、游标和全文检索见 Search
 func GetAllOpLog(dbInst *gorm.DB, opModel interface{}, ret interface{}, querys []*common.QueryConditon, fields []string, sortby []string, order []string,
 	offset int, limit int) (ml interface{}, totalcount int64, err error) {
-	g := dbInst.Model(opModel)
-	for _, query := range querys {
-		switch query.QueryType {
-		case common.MultiSelect: // 多选
-			g = g.Where(fmt.Sprintf("%s in (?)", query.QueryKey), query.QueryValues)
-		case common.NumRange: // 数字范围
-			if len(query.QueryValues) == 2 {
-				g = g.Where(fmt.Sprintf("%s >= ? AND %s <= ?", query.QueryKey, query.QueryKey), query.QueryValues[0], query.QueryValues[1])
-			} else {
-				return nil, 0, fmt.Errorf("query params err %+v", query)
-			}
-		default:
-			// case common.MultiText: // 模糊多值匹配
-			sql := make([]string, len(query.QueryValues))
-			val := make([]interface{}, len(query.QueryValues))
-			for i := range query.QueryValues {
-				sql[i] = "name LIKE ?"
-				val[i] = query.QueryValues[i]
-			}
-			g = g.Where(strings.Join(sql, "or"), val...)
-		}
+	var g *gorm.DB
+	if g, err = common.BuildQuery(dbInst.Model(opModel), querys, nil, nil, nil); err != nil {
+		logs.Error("GetAllOpLog build query error %s", err.Error())
+		return
 	}
-	if err = g.Count(&totalcount).Error; err != nil {
+	if err = g.Session(&gorm.Session{}).Count(&totalcount).Error; err != nil {
 		logs.Error("GetAllOpLog Count error %s\n", err.Error())
 		return
 	}
-	if len(fields) > 0 {
-		g = g.Select(fields)
-	}
-	if len(sortby) != 0 {
-		if len(sortby) == len(order) {
-			// 1) for each sort field, there is an associated order
-			for i := range sortby {
-				g = g.Order(fmt.Sprintf("%s %s", sortby[i], order[i]))
-			}
-
-		} else if len(sortby) != len(order) && len(order) == 1 {
-			for i := range sortby {
-				g = g.Order(fmt.Sprintf("%s %s", sortby[i], order[0]))
-			}
-		} else if len(sortby) != len(order) && len(order) != 1 {
-			return nil, 0, errors.New("error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
-		}
-	} else {
-		if len(order) != 0 {
-			return nil, 0, errors.New("error: unused 'order' fields")
-		}
+	if g, err = common.BuildQuery(g, nil, fields, sortby, order); err != nil {
+		logs.Error("GetAllOpLog build query error %s", err.Error())
+		return
 	}
 	if limit < 0 || limit > 100 {
 		limit = 100
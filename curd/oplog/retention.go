@@ -0,0 +1,202 @@
+package oplog
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"gorm.io/gorm"
+)
+
+// RetentionRule 日志保留规则，Flow/Action 为空表示任意
+// 一条日志按最具体的规则处理：流程+行为 > 流程 > 行为 > 默认
+type RetentionRule struct {
+	Flow   string
+	Action string
+	Keep   time.Duration // 保留时长，0 表示永久保留
+}
+
+func (r RetentionRule) String() string {
+	flow, action := r.Flow, r.Action
+	if flow == "" {
+		flow = "*"
+	}
+	if action == "" {
+		action = "*"
+	}
+	return flow + "/" + action
+}
+
+// 规则的优先级
+func (r RetentionRule) rank() int {
+	rank := 0
+	if r.Flow != "" {
+		rank += 2
+	}
+	if r.Action != "" {
+		rank++
+	}
+	return rank
+}
+
+// 规则匹配的日志
+func (r RetentionRule) scope(db *gorm.DB) *gorm.DB {
+	if r.Flow != "" {
+		db = db.Where("flow = ?", r.Flow)
+	}
+	if r.Action != "" {
+		db = db.Where("action = ?", r.Action)
+	}
+	return db
+}
+
+// ParseDuration 支持 d（天）和 y（365天）单位，其他同 time.ParseDuration
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n := len(s); n > 1 && (s[n-1] == 'd' || s[n-1] == 'y') {
+		v, err := strconv.Atoi(s[:n-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", s)
+		}
+		days := v
+		if s[n-1] == 'y' {
+			days = v * 365
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ParseRetention 解析保留规则，格式 [流程/]行为=时长，多条用;分隔，* 表示任意
+// 例如 query=30d;alter=2y;order/*=1y;*=5y
+func ParseRetention(conf string) (rules []RetentionRule, err error) {
+	for _, item := range strings.Split(conf, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid retention rule %s", item)
+		}
+		var rule RetentionRule
+		scope := strings.SplitN(strings.TrimSpace(kv[0]), "/", 2)
+		if len(scope) == 2 {
+			rule.Flow, rule.Action = scope[0], scope[1]
+		} else {
+			rule.Action = scope[0]
+		}
+		if rule.Flow == "*" {
+			rule.Flow = ""
+		}
+		if rule.Action == "*" {
+			rule.Action = ""
+		}
+		if rule.Keep, err = ParseDuration(kv[1]); err != nil {
+			return nil, err
+		}
+		for _, r := range rules {
+			if r.Flow == rule.Flow && r.Action == rule.Action {
+				return nil, fmt.Errorf("duplicate retention rule %s", rule)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
+// RetentionFromConf 保留规则配置 OpLog::Retention，见 ParseRetention
+func RetentionFromConf() ([]RetentionRule, error) {
+	return ParseRetention(beego.AppConfig.String("OpLog::Retention"))
+}
+
+// ApplyRetention 把超过保留时长的日志归档到 store 后删除，返回生成的归档
+func ApplyRetention(db *gorm.DB, oplogModel interface{}, rules []RetentionRule, store ArchiveStore, now time.Time) (archives []*Archive, err error) {
+	tblName := OpLog{}.TableName()
+	if oplogModel != nil {
+		stmt := &gorm.Statement{DB: db}
+		if err = stmt.Parse(oplogModel); err != nil {
+			return
+		}
+		tblName = stmt.Table
+	}
+	sorted := append([]RetentionRule(nil), rules...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].rank() > sorted[j].rank() })
+	for i, rule := range sorted {
+		if rule.Keep <= 0 {
+			continue
+		}
+		for {
+			q := rule.scope(db.Table(tblName)).Where("created_at < ?", now.Add(-rule.Keep))
+			// 排除由更具体的规则处理的日志
+			for _, r := range sorted[:i] {
+				if r.rank() > rule.rank() {
+					q = q.Not(r.scope(db.Session(&gorm.Session{NewDB: true})))
+				}
+			}
+			var rows []map[string]interface{}
+			if err = q.Order("id").Limit(archiveBatchSize).Find(&rows).Error; err != nil {
+				logs.Error("query expired oplogs of rule %s failed, %s", rule, err.Error())
+				return
+			}
+			if len(rows) == 0 {
+				break
+			}
+			var archive *Archive
+			if archive, err = archiveBatch(db, tblName, rule.String(), store, rows, now); err != nil {
+				return
+			}
+			archives = append(archives, archive)
+			if len(rows) < archiveBatchSize {
+				break
+			}
+		}
+	}
+	return
+}
+
+// StartRetention 按间隔（OpLog::RetentionInterval，默认24h）执行保留规则，返回停止函数
+// 规则见 RetentionFromConf，存储见 StoreFromConf
+func StartRetention(db *gorm.DB, oplogModel interface{}) (stop func()) {
+	rules, err := RetentionFromConf()
+	if err == nil && len(rules) == 0 {
+		err = fmt.Errorf("OpLog::Retention not configured")
+	}
+	var store ArchiveStore
+	if err == nil {
+		store, err = StoreFromConf()
+	}
+	if err != nil {
+		logs.Error("oplog retention not started,", err.Error())
+		return func() {}
+	}
+	interval := 24 * time.Hour
+	if v := beego.AppConfig.String("OpLog::RetentionInterval"); v != "" {
+		if d, perr := ParseDuration(v); perr == nil && d > 0 {
+			interval = d
+		} else {
+			logs.Error("invalid OpLog::RetentionInterval %s, use %s", v, interval)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if archives, err := ApplyRetention(db, oplogModel, rules, store, time.Now()); err != nil {
+					logs.Error("oplog retention failed,", err.Error())
+				} else if len(archives) > 0 {
+					logs.Info("oplog retention archived %d files", len(archives))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,66 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+)
+
+func Test_ParseRetention(t *testing.T) {
+	rules, err := ParseRetention("query=30d; alter=2y;order/*=1y;order/alter=3y;*=720h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 5 || rules[1].Keep != 2*365*24*time.Hour || rules[2].Flow != "order" || rules[2].Action != "" ||
+		rules[4].Flow != "" || rules[4].Action != "" || rules[4].Keep != 720*time.Hour {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+	if _, err = ParseRetention("query=30d;query=1y"); err == nil {
+		t.Error("expect duplicate rule error")
+	}
+	if _, err = ParseRetention("query"); err == nil {
+		t.Error("expect format error")
+	}
+}
+
+func Test_ApplyRetention(t *testing.T) {
+	db := testdb.Open(t)
+	if err := db.AutoMigrate(&OpLog{}, &Archive{}, &ArchivedLink{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.Local)
+	old := now.AddDate(0, 0, -60)
+	db.Create(&OpLog{User: "u", Action: OP_ACTION_QUERY, Flow: "f", Remark: "old query", CreatedAt: old})
+	db.Create(&OpLog{User: "u", Action: OP_ACTION_QUERY, Flow: "f", Remark: "new query", CreatedAt: now.AddDate(0, 0, -1)})
+	db.Create(&OpLog{User: "u", Action: OP_ACTION_ALTER, Flow: "f", Remark: "old alter", CreatedAt: old})
+	db.Create(&OpLog{User: "u", Action: OP_ACTION_QUERY, Flow: "audit", Remark: "kept by flow rule", CreatedAt: old})
+
+	rules, err := ParseRetention("query=30d;alter=1y;audit/*=5y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := LocalStore{Dir: t.TempDir()}
+	archives, err := ApplyRetention(db, nil, rules, store, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 || archives[0].Count != 1 || archives[0].FirstID != 1 || archives[0].Rule != "*/query" {
+		t.Fatalf("unexpected archives %+v", archives)
+	}
+	var remarks []string
+	db.Model(&OpLog{}).Order("id").Pluck("remark", &remarks)
+	if len(remarks) != 3 || remarks[0] != "new query" {
+		t.Fatalf("unexpected remaining logs %v", remarks)
+	}
+
+	count, err := Import(db, store, archives[0], "", nil)
+	if err != nil || count != 1 {
+		t.Fatalf("import archive failed %d %v", count, err)
+	}
+	var imported []OpLog
+	db.Table("op_log_archive").Find(&imported)
+	if len(imported) != 1 || imported[0].ID != 1 || imported[0].Remark != "old query" || !imported[0].CreatedAt.Equal(old) {
+		t.Errorf("unexpected imported logs %+v", imported)
+	}
+}
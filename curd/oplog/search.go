@@ -0,0 +1,163 @@
+package oplog
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/curd/common"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"gorm.io/gorm"
+)
+
+// 导出格式
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+)
+
+// QueryFields 允许查询、排序和返回的字段
+var QueryFields = []string{"id", "user", "action", "flow_id", "flow", "remark", "changes", "created_at"}
+
+// SearchKeys 日志查询自己处理的参数，AMIS 模式下不作为查询条件
+var SearchKeys = []string{"q", "since", "until", "format"}
+
+// ParseSearchRequest 解析日志查询（export 为 true 时按导出）的列表参数
+func ParseSearchRequest(ctx *context.Context, export bool) (req *common.ListRequest, err error) {
+	common.SetReservedKeys(ctx, SearchKeys...)
+	if export {
+		return common.ParseExportRequest(ctx, QueryFields...)
+	}
+	return common.ParseListRequest(ctx, QueryFields...)
+}
+
+// Filter 常用的日志查询条件，为空的条件不生效
+type Filter struct {
+	Users   []string // 操作者
+	Actions []string // 行为
+	Flow    string
+	FlowId  uint
+	Since   string // 开始时间，格式同 date-range 查询（2006-01-02、2006-01-02 15:04:05、RFC3339、unix时间戳）
+	Until   string // 结束时间，只有日期时包含当天
+	Keyword string // 全文检索备注，多个词用空格分隔，需全部匹配
+}
+
+// Conditions 转换为查询条件（不含 Keyword）
+func (f *Filter) Conditions(loc *time.Location) (querys []*common.QueryConditon) {
+	if len(f.Users) > 0 {
+		querys = append(querys, &common.QueryConditon{QueryKey: "user", QueryType: common.MultiSelect, QueryValues: f.Users})
+	}
+	if len(f.Actions) > 0 {
+		querys = append(querys, &common.QueryConditon{QueryKey: "action", QueryType: common.MultiSelect, QueryValues: f.Actions})
+	}
+	if f.Flow != "" {
+		querys = append(querys, &common.QueryConditon{QueryKey: "flow", QueryType: common.Eq, QueryValues: []string{f.Flow}})
+	}
+	if f.FlowId != 0 {
+		querys = append(querys, &common.QueryConditon{QueryKey: "flow_id", QueryType: common.Eq, QueryValues: []string{strconv.FormatUint(uint64(f.FlowId), 10)}})
+	}
+	if f.Since != "" || f.Until != "" {
+		querys = append(querys, &common.QueryConditon{QueryKey: "created_at", QueryType: common.DateRange,
+			QueryValues: []string{f.Since, f.Until}, Location: loc})
+	}
+	return
+}
+
+// FullTextEnabled mysql 下是否使用全文索引（OpLog::FullText，需要执行迁移 2022010203 创建索引）
+func FullTextEnabled() bool {
+	return beego.AppConfig.DefaultBool("OpLog::FullText", false)
+}
+
+// SearchRemark 全文检索备注：mysql 开启 FullText 时使用 MATCH AGAINST（ngram 索引），其他按词模糊匹配
+func SearchRemark(db *gorm.DB, keyword string) *gorm.DB {
+	terms := strings.Fields(keyword)
+	if len(terms) == 0 {
+		return db
+	}
+	if dbgorm.Dialect(db) == dbgorm.DialectMySQL && FullTextEnabled() {
+		quoted := make([]string, len(terms))
+		for i, t := range terms {
+			quoted[i] = `+"` + strings.Replace(t, `"`, "", -1) + `"`
+		}
+		return db.Where("MATCH(remark) AGAINST(? IN BOOLEAN MODE)", strings.Join(quoted, " "))
+	}
+	for _, t := range terms {
+		db = db.Where(dbgorm.Like(db, "remark"), "%"+dbgorm.LikeEscape(t)+"%")
+	}
+	return db
+}
+
+// Search 按请求参数和过滤条件分页查询日志，oplogModel 决定表名，ret 为切片指针
+func Search(db *gorm.DB, oplogModel interface{}, ret interface{}, filter Filter, req *common.ListRequest) (page *common.Page, err error) {
+	req.Query = append(req.Query, filter.Conditions(req.Location)...)
+	if filter.Keyword != "" {
+		db = SearchRemark(db, filter.Keyword)
+		if req.CountMode == common.CountApprox {
+			// 估算的总数不包含检索条件
+			req.CountMode = common.CountExact
+		}
+	}
+	if page, err = common.FindPage(db, oplogModel, ret, req); err != nil {
+		logs.Error("search oplog failed,", err.Error())
+	}
+	return
+}
+
+// 导出的列
+var exportHeader = []string{"id", "created_at", "user", "action", "flow", "flowId", "remark", "changes"}
+
+// 以 = + - @ 等开头的单元格在excel中会作为公式执行，加 ' 前缀按文本处理
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// Export 导出匹配的日志（条数上限见 common.ParseExportRequest），返回导出的条数
+func Export(db *gorm.DB, oplogModel interface{}, w io.Writer, format string, filter Filter, req *common.ListRequest) (count int, err error) {
+	if format != ExportCSV && format != ExportXLSX {
+		return 0, fmt.Errorf("unsupported export format[%s]", format)
+	}
+	var logList []OpLog
+	if _, err = Search(db, oplogModel, &logList, filter, req); err != nil {
+		return
+	}
+	loc := req.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	rows := make([][]string, 0, len(logList)+1)
+	rows = append(rows, exportHeader)
+	for _, l := range logList {
+		rows = append(rows, []string{strconv.FormatUint(uint64(l.ID), 10), l.CreatedAt.In(loc).Format("2006-01-02 15:04:05"),
+			l.User, l.Action, l.Flow, strconv.FormatUint(uint64(l.FlowId), 10), l.Remark, l.Changes})
+	}
+	switch format {
+	case ExportCSV:
+		// 带BOM，excel 打开时按 UTF-8 识别
+		if _, err = io.WriteString(w, "\xEF\xBB\xBF"); err != nil {
+			return
+		}
+		for _, row := range rows[1:] {
+			for i := range row {
+				row[i] = csvCell(row[i])
+			}
+		}
+		cw := csv.NewWriter(w)
+		if err = cw.WriteAll(rows); err != nil {
+			return
+		}
+	case ExportXLSX:
+		if err = common.WriteXLSX(w, "oplog", rows); err != nil {
+			return
+		}
+	}
+	return len(logList), nil
+}
@@ -0,0 +1,77 @@
+package oplog
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daimall/tools/curd/common"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+)
+
+func Test_SearchExport(t *testing.T) {
+	db := testdb.Open(t)
+	if err := db.AutoMigrate(&OpLog{}); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2022, 1, 10, 12, 0, 0, 0, time.Local)
+	db.Create(&OpLog{User: "alice", Action: OP_ACTION_ADD, Flow: "f", FlowId: 1, Remark: "create order 1", CreatedAt: day})
+	db.Create(&OpLog{User: "bob", Action: OP_ACTION_ALTER, Flow: "f", FlowId: 1, Remark: "change order amount", CreatedAt: day.AddDate(0, 0, 1)})
+	db.Create(&OpLog{User: "alice", Action: OP_ACTION_QUERY, Flow: "g", FlowId: 2, Remark: "list orders", CreatedAt: day.AddDate(0, 0, 2)})
+	db.Create(&OpLog{User: "-mallory", Action: OP_ACTION_ADD, Flow: "g", FlowId: 3, Remark: "=HYPERLINK(\"http://x\")", CreatedAt: day.AddDate(0, 0, 3)})
+
+	// 模糊查询使用查询字段
+	var ret []OpLog
+	_, total, err := GetAllOpLog(db, &OpLog{}, &ret, []*common.QueryConditon{
+		{QueryKey: "remark", QueryType: common.MultiText, QueryValues: []string{"amount", "list"}},
+		{QueryKey: "action", QueryType: common.NotIn, QueryValues: []string{OP_ACTION_QUERY}},
+	}, nil, []string{"id"}, []string{"asc"}, 0, 10)
+	if err != nil || total != 1 || len(ret) != 1 || ret[0].User != "bob" {
+		t.Fatalf("unexpected GetAllOpLog result %d %+v %v", total, ret, err)
+	}
+
+	ret = nil
+	req := &common.ListRequest{Limit: 10, Location: time.Local}
+	filter := Filter{Users: []string{"alice", "bob"}, Since: "2022-01-10", Until: "2022-01-11", Keyword: "order"}
+	page, err := Search(db, &OpLog{}, &ret, filter, req)
+	if err != nil || *page.Total != 2 || len(ret) != 2 {
+		t.Fatalf("unexpected search result %+v %v", ret, err)
+	}
+
+	var buf bytes.Buffer
+	req = &common.ListRequest{Limit: 10, Location: time.Local, SortBy: []string{"id"}, Order: []string{"asc"}}
+	if n, err := Export(db, &OpLog{}, &buf, ExportCSV, Filter{Actions: []string{OP_ACTION_ADD, OP_ACTION_QUERY}}, req); err != nil || n != 3 {
+		t.Fatalf("export csv failed %d %v", n, err)
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\xEF\xBB\xBF"))).ReadAll()
+	if err != nil || len(records) != 4 || records[0][0] != "id" || records[2][6] != "list orders" {
+		t.Fatalf("unexpected csv %q %v", records, err)
+	}
+	// 公式开头的单元格按文本导出
+	if records[3][2] != "'-mallory" || records[3][6] != `'=HYPERLINK("http://x")` {
+		t.Errorf("expect escaped formula cells, got %q", records[3])
+	}
+
+	buf.Reset()
+	req = &common.ListRequest{Limit: 10, Location: time.Local}
+	if _, err := Export(db, &OpLog{}, &buf, ExportXLSX, Filter{}, req); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, f := range zr.File {
+		found = found || f.Name == "xl/worksheets/sheet1.xml"
+	}
+	if !found {
+		t.Error("xlsx without worksheet")
+	}
+	if _, err := Export(db, &OpLog{}, &buf, "pdf", Filter{}, req); err == nil {
+		t.Error("expect unsupported format error")
+	}
+}
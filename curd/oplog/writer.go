@@ -0,0 +1,416 @@
+package oplog
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// 队列满时的处理策略
+const (
+	PolicyBlock = "block" // 阻塞等待（可设置 BlockTimeout）
+	PolicyDrop  = "drop"  // 丢弃并返回 ErrQueueFull
+)
+
+var (
+	ErrQueueFull    = errors.New("oplog queue is full")
+	ErrWriterClosed = errors.New("oplog writer is closed")
+)
+
+// WriterOptions 异步写入参数
+type WriterOptions struct {
+	QueueSize     int           // 队列长度，默认1000
+	BatchSize     int           // 批量写入条数，默认100
+	FlushInterval time.Duration // 不满一批时的写入间隔，默认1秒
+	Policy        string        // 队列满时的策略 block drop，默认 block
+	BlockTimeout  time.Duration // block 策略的最长等待时间，0 一直等待，超时按 drop 处理
+	MaxRetries    int           // 暂时性错误（连接断开、死锁等）的重试次数，默认3
+	RetryBackoff  time.Duration // 第一次重试的等待时间，之后翻倍，默认200ms
+}
+
+// WriterOptionsFromConf 从配置读取参数
+// [OpLog]
+// QueueSize = 1000
+// BatchSize = 100
+// FlushInterval = 1s
+// Policy = block
+// BlockTimeout = 100ms
+// MaxRetries = 3
+// RetryBackoff = 200ms
+func WriterOptionsFromConf() WriterOptions {
+	duration := func(key string) time.Duration {
+		v := beego.AppConfig.String("OpLog::" + key)
+		if v == "" {
+			return 0
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			logs.Error("invalid duration OpLog::%s=%s", key, v)
+		}
+		return d
+	}
+	return WriterOptions{
+		QueueSize:     beego.AppConfig.DefaultInt("OpLog::QueueSize", 0),
+		BatchSize:     beego.AppConfig.DefaultInt("OpLog::BatchSize", 0),
+		FlushInterval: duration("FlushInterval"),
+		Policy:        beego.AppConfig.String("OpLog::Policy"),
+		BlockTimeout:  duration("BlockTimeout"),
+		MaxRetries:    beego.AppConfig.DefaultInt("OpLog::MaxRetries", 3),
+		RetryBackoff:  duration("RetryBackoff"),
+	}
+}
+
+func (o *WriterOptions) setDefaults() {
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1000
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+	if o.Policy == "" {
+		o.Policy = PolicyBlock
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 200 * time.Millisecond
+	}
+}
+
+// WriterStats 写入统计
+type WriterStats struct {
+	Queued  int   `json:"queued"`  // 队列中等待写入的条数
+	Written int64 `json:"written"` // 已写入
+	Dropped int64 `json:"dropped"` // 队列满被丢弃
+	Failed  int64 `json:"failed"`  // 写入失败（重试后）
+}
+
+// Writer 有界队列的异步批量写入
+type Writer struct {
+	db     *gorm.DB
+	opts   WriterOptions
+	queue  chan interface{}
+	flushc chan chan struct{}
+	stop   chan struct{} // Close 时关闭，等待中的 Write 返回 ErrWriterClosed
+	done   chan struct{}
+
+	mu      sync.RWMutex
+	closed  bool
+	writing sync.WaitGroup // 进行中的 Write，写入goroutine退出前等待
+
+	written, dropped, failed int64
+}
+
+// NewWriter 创建并启动写入goroutine
+func NewWriter(db *gorm.DB, opts WriterOptions) *Writer {
+	opts.setDefaults()
+	w := &Writer{
+		db:     db,
+		opts:   opts,
+		queue:  make(chan interface{}, opts.QueueSize),
+		flushc: make(chan chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write 加入写入队列，oplogModel 为 OpLog 或者自定义的日志模型指针
+// 等待队列时不持有锁，Close 后等待中的 Write 返回 ErrWriterClosed
+func (w *Writer) Write(oplogModel interface{}) error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrWriterClosed
+	}
+	w.writing.Add(1)
+	w.mu.RUnlock()
+	defer w.writing.Done()
+	select {
+	case w.queue <- oplogModel:
+		return nil
+	default:
+	}
+	if w.opts.Policy == PolicyBlock {
+		var timeout <-chan time.Time
+		if w.opts.BlockTimeout > 0 {
+			timer := time.NewTimer(w.opts.BlockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case w.queue <- oplogModel:
+			return nil
+		case <-w.stop:
+			return ErrWriterClosed
+		case <-timeout:
+		}
+	}
+	atomic.AddInt64(&w.dropped, 1)
+	return ErrQueueFull
+}
+
+// Flush 写入队列中已有的日志，ctx 超时返回 ctx 的错误
+func (w *Writer) Flush(ctx context.Context) error {
+	ch := make(chan struct{})
+	select {
+	case w.flushc <- ch:
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 停止接收新日志，写完队列后退出
+func (w *Writer) Close(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats 写入统计
+func (w *Writer) Stats() WriterStats {
+	return WriterStats{
+		Queued:  len(w.queue),
+		Written: atomic.LoadInt64(&w.written),
+		Dropped: atomic.LoadInt64(&w.dropped),
+		Failed:  atomic.LoadInt64(&w.failed),
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]interface{}, 0, w.opts.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			w.write(batch)
+			batch = batch[:0]
+		}
+	}
+	// 写入队列中已有的日志
+	drain := func() {
+		for n := len(w.queue); n > 0; n-- {
+			if batch = append(batch, <-w.queue); len(batch) >= w.opts.BatchSize {
+				flush()
+			}
+		}
+		flush()
+	}
+	for {
+		select {
+		case m := <-w.queue:
+			if batch = append(batch, m); len(batch) >= w.opts.BatchSize {
+				flush()
+			}
+		case <-w.stop:
+			// 等进行中的 Write 返回后写完队列，队列不关闭，之后的 Write 返回 ErrWriterClosed
+			w.writing.Wait()
+			drain()
+			return
+		case <-ticker.C:
+			flush()
+		case ch := <-w.flushc:
+			// 写完请求时队列中已有的日志
+			drain()
+			close(ch)
+		}
+	}
+}
+
+// 批量写入，同一类型的连续日志一次插入；暂时性错误重试，其他错误逐条写入以免一条错误影响整批
+// 开启哈希链（OpLog::HashChain）时参与链的日志逐条按顺序写入
+func (w *Writer) write(batch []interface{}) {
+	chain := ChainMode()
+	for start := 0; start < len(batch); {
+		t := reflect.TypeOf(batch[start])
+		end := start + 1
+		if _, ok := batch[start].(Chainable); !ok || chain == "" {
+			for end < len(batch) && reflect.TypeOf(batch[end]) == t {
+				end++
+			}
+		}
+		group := batch[start:end]
+		start = end
+		if err := w.insert(group); err == nil {
+			atomic.AddInt64(&w.written, int64(len(group)))
+			continue
+		} else if len(group) == 1 || isTransient(err) {
+			logs.Error("oprate log Create error %s, %d logs lost", err.Error(), len(group))
+			atomic.AddInt64(&w.failed, int64(len(group)))
+			continue
+		}
+		for _, m := range group {
+			if err := w.insert([]interface{}{m}); err != nil {
+				logs.Error("oprate log Create error %s, log lost: %+v", err.Error(), m)
+				atomic.AddInt64(&w.failed, 1)
+				continue
+			}
+			atomic.AddInt64(&w.written, 1)
+		}
+	}
+}
+
+// 插入同一类型的日志，暂时性错误按退避时间重试
+func (w *Writer) insert(group []interface{}) (err error) {
+	var value interface{} = group[0]
+	if len(group) > 1 {
+		slice := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(group[0])), 0, len(group))
+		for _, m := range group {
+			slice = reflect.Append(slice, reflect.ValueOf(m))
+		}
+		value = slice.Interface()
+	}
+	backoff := w.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if chain := ChainMode(); chain != "" && len(group) == 1 {
+			err = AppendChained(w.db, value, chain)
+		} else {
+			err = w.db.Create(value).Error
+		}
+		if err == nil || !isTransient(err) || attempt >= w.opts.MaxRetries {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// 暂时性错误：连接断开、网络错误、锁等待超时、死锁
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case 1040, 1205, 1213: // too many connections, lock wait timeout, deadlock
+			return true
+		}
+	}
+	// sqlite 写锁
+	return strings.Contains(err.Error(), "database is locked")
+}
+
+// 每个数据库链接一个默认写入器，供 AddOperationLog 使用
+// 按底层的 *sql.DB 区分，同一链接的 Session/WithContext 等共用一个写入器
+var (
+	writersMu sync.Mutex
+	writers   = map[interface{}]*Writer{}
+)
+
+func writerFor(db *gorm.DB) *Writer {
+	var key interface{} = db.Statement.ConnPool
+	if sqlDB, err := db.DB(); err == nil {
+		key = sqlDB
+	}
+	writersMu.Lock()
+	defer writersMu.Unlock()
+	w, ok := writers[key]
+	if !ok {
+		// 不带调用方的查询条件和请求context
+		w = NewWriter(db.Session(&gorm.Session{NewDB: true, Context: context.Background()}), WriterOptionsFromConf())
+		writers[key] = w
+	}
+	return w
+}
+
+// Flush 写入所有默认写入器队列中的日志
+func Flush(ctx context.Context) (err error) {
+	writersMu.Lock()
+	ws := make([]*Writer, 0, len(writers))
+	for _, w := range writers {
+		ws = append(ws, w)
+	}
+	writersMu.Unlock()
+	for _, w := range ws {
+		if ferr := w.Flush(ctx); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	return
+}
+
+// Close 关闭所有默认写入器（写完队列），之后的 AddOperationLog 会重新创建写入器
+func Close(ctx context.Context) (err error) {
+	writersMu.Lock()
+	ws := writers
+	writers = map[interface{}]*Writer{}
+	writersMu.Unlock()
+	for _, w := range ws {
+		if cerr := w.Close(ctx); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// FlushOnShutdown 收到退出信号时先写完队列中的日志（最长等待timeout），再按信号的默认行为退出
+// 在 beego.Run() 之前调用：oplog.FlushOnShutdown(5*time.Second, syscall.SIGINT, syscall.SIGTERM)
+func FlushOnShutdown(timeout time.Duration, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		sig := <-c
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := Close(ctx); err != nil {
+			logs.Error("flush oplog on shutdown failed,", err.Error())
+		}
+		cancel()
+		logs.GetBeeLogger().Flush()
+		signal.Reset(sigs...)
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			// 不支持发送该信号的平台（windows）直接退出
+			os.Exit(1)
+		}
+	}()
+}
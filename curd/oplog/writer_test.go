@@ -0,0 +1,115 @@
+package oplog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+	"gorm.io/gorm"
+)
+
+func Test_WriterFlush(t *testing.T) {
+	db := testdb.Open(t)
+	if err := db.AutoMigrate(&OpLog{}); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(db, WriterOptions{BatchSize: 10, FlushInterval: time.Hour})
+	for i := 0; i < 25; i++ {
+		if err := w.Write(&OpLog{User: "u", Action: OP_ACTION_ADD, Flow: "f"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&OpLog{}).Count(&count)
+	if count != 25 {
+		t.Errorf("expect 25 logs after flush, got %d", count)
+	}
+	w.Write(&OpLog{User: "u", Action: OP_ACTION_DELETE})
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(&OpLog{}); err != ErrWriterClosed {
+		t.Errorf("expect ErrWriterClosed, got %v", err)
+	}
+	if stats := w.Stats(); stats.Written != 26 || stats.Failed != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func Test_WriterDrop(t *testing.T) {
+	// 不启动写入goroutine，队列满后直接丢弃
+	w := &Writer{opts: WriterOptions{Policy: PolicyDrop}, queue: make(chan interface{}, 1)}
+	if err := w.Write(&OpLog{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(&OpLog{}); err != ErrQueueFull {
+		t.Errorf("expect ErrQueueFull, got %v", err)
+	}
+	w.opts = WriterOptions{Policy: PolicyBlock, BlockTimeout: 10 * time.Millisecond}
+	if err := w.Write(&OpLog{}); err != ErrQueueFull {
+		t.Errorf("expect ErrQueueFull after block timeout, got %v", err)
+	}
+	if w.Stats().Dropped != 2 {
+		t.Errorf("expect 2 dropped, got %d", w.Stats().Dropped)
+	}
+}
+
+func Test_WriterCloseBlocked(t *testing.T) {
+	// 不启动写入goroutine，队列满时 Write 一直等待
+	w := &Writer{opts: WriterOptions{Policy: PolicyBlock}, queue: make(chan interface{}, 1),
+		stop: make(chan struct{}), done: make(chan struct{})}
+	w.Write(&OpLog{})
+	errc := make(chan error, 1)
+	go func() { errc <- w.Write(&OpLog{}) }()
+	time.Sleep(10 * time.Millisecond)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.Close(canceled); err != context.Canceled || w.closed {
+		t.Errorf("expect canceled before closing, got %v", err)
+	}
+	// 等待中的 Write 不影响 Close 按 ctx 超时返回
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := w.Close(ctx); err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Errorf("expect close timeout, got %v after %v", err, time.Since(start))
+	}
+	select {
+	case err := <-errc:
+		if err != ErrWriterClosed {
+			t.Errorf("expect ErrWriterClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("blocked write not released by close")
+	}
+}
+
+func Test_WriterFor(t *testing.T) {
+	defer Close(context.Background())
+	db1, db2 := testdb.Open(t), testdb.Open(t)
+	if err := db1.AutoMigrate(&OpLog{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := writerFor(db1.WithContext(ctx))
+	// 同一链接的不同 *gorm.DB 使用同一个写入器
+	if writerFor(db1) != w || writerFor(db1.Session(&gorm.Session{})) != w || writerFor(db2) == w {
+		t.Error("expect one writer per connection")
+	}
+	// 写入器不使用第一个调用方的context
+	cancel()
+	AddOperationLog(db1, &OpLog{User: "u", Action: OP_ACTION_ADD})
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db1.Model(&OpLog{}).Count(&count)
+	if count != 1 || w.Stats().Failed != 0 {
+		t.Errorf("expect 1 log written, got %d %+v", count, w.Stats())
+	}
+}
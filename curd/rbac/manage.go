@@ -0,0 +1,104 @@
+package rbac
+
+import (
+	"fmt"
+
+	"github.com/astaxie/beego/logs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 角色和权限管理
+
+// CreateRole 创建角色
+func CreateRole(db *gorm.DB, name, remark string) (role *Role, err error) {
+	role = &Role{Name: name, Remark: remark}
+	if err = db.Create(role).Error; err != nil {
+		logs.Error("create role[%s] failed, %s", name, err.Error())
+	}
+	return
+}
+
+// DeleteRole 删除角色以及角色的权限和用户关联
+func DeleteRole(db *gorm.DB, name string) (err error) {
+	defer InvalidateCache()
+	return db.Transaction(func(tx *gorm.DB) (err error) {
+		var role Role
+		if role, err = getRole(tx, name); err != nil {
+			return
+		}
+		if err = tx.Where("role_id = ?", role.ID).Delete(&RolePermission{}).Error; err != nil {
+			return
+		}
+		if err = tx.Where("role_id = ?", role.ID).Delete(&UserRole{}).Error; err != nil {
+			return
+		}
+		return tx.Delete(&role).Error
+	})
+}
+
+// Grant 给角色授权
+func Grant(db *gorm.DB, roleName string, perms ...string) (err error) {
+	defer InvalidateCache()
+	var role Role
+	if role, err = getRole(db, roleName); err != nil {
+		return
+	}
+	for _, perm := range perms {
+		if err = db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&RolePermission{RoleId: role.ID, Permission: perm}).Error; err != nil {
+			logs.Error("grant %s to role[%s] failed, %s", perm, roleName, err.Error())
+			return
+		}
+	}
+	return
+}
+
+// Revoke 收回角色的权限
+func Revoke(db *gorm.DB, roleName string, perms ...string) (err error) {
+	defer InvalidateCache()
+	var role Role
+	if role, err = getRole(db, roleName); err != nil {
+		return
+	}
+	return db.Where("role_id = ? AND permission in (?)", role.ID, perms).Delete(&RolePermission{}).Error
+}
+
+// AssignRole 给用户分配角色
+func AssignRole(db *gorm.DB, uname string, roleName string) (err error) {
+	defer InvalidateCache()
+	var role Role
+	if role, err = getRole(db, roleName); err != nil {
+		return
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserRole{User: uname, RoleId: role.ID}).Error
+}
+
+// UnassignRole 取消用户的角色
+func UnassignRole(db *gorm.DB, uname string, roleName string) (err error) {
+	defer InvalidateCache()
+	var role Role
+	if role, err = getRole(db, roleName); err != nil {
+		return
+	}
+	return db.Where(&UserRole{User: uname, RoleId: role.ID}).Delete(&UserRole{}).Error
+}
+
+// UsersInRole 获取角色下的所有用户
+func UsersInRole(db *gorm.DB, roleName string) (users []string, err error) {
+	var role Role
+	if role, err = getRole(db, roleName); err != nil {
+		return
+	}
+	err = db.Model(&UserRole{}).Where("role_id = ?", role.ID).Pluck("user", &users).Error
+	return
+}
+
+func getRole(db *gorm.DB, name string) (role Role, err error) {
+	if err = db.Where("name = ?", name).First(&role).Error; err != nil {
+		err = fmt.Errorf("role[%s] not found, %s", name, err.Error())
+		logs.Error(err.Error())
+	}
+	return
+}
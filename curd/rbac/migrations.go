@@ -0,0 +1,20 @@
+package rbac
+
+import (
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/migrate"
+	"gorm.io/gorm"
+)
+
+// 数据库迁移，见 migrate 包
+func init() {
+	migrate.Register(migrate.Migration{
+		Version: 2022010103,
+		Name:    "create rbac tables",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(Models()...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(Models()...)
+		},
+	})
+}
@@ -0,0 +1,161 @@
+package rbac
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm"
+	"gorm.io/gorm"
+)
+
+// 权限字符串格式 service:action，支持通配符，例如 supplier:GetAll、supplier:*、*:Export、*
+// action 为 flowcontroller 中路由的方法（GetAll、GetOne、Create、Update、Delete、DeleteList、
+// Import、Export、GetConfigs、Next、OperationList ...），自定义Action为 :action 参数
+const (
+	PermSeparator = ":"
+	Wildcard      = "*"
+)
+
+// 角色
+type Role struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	Name      string    `gorm:"size:50;column:name;uniqueIndex" json:"name"` // 角色名
+	Remark    string    `gorm:"size:255;column:remark" json:"remark"`        // 备注
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Role) TableName() string {
+	return "rbac_roles"
+}
+
+// 角色拥有的权限
+type RolePermission struct {
+	ID         uint   `gorm:"primary_key" json:"id"`
+	RoleId     uint   `gorm:"column:role_id;uniqueIndex:role_perm" json:"roleId"`
+	Permission string `gorm:"size:100;column:permission;uniqueIndex:role_perm" json:"permission"` // service:action
+}
+
+func (RolePermission) TableName() string {
+	return "rbac_role_permissions"
+}
+
+// 用户拥有的角色
+type UserRole struct {
+	ID     uint   `gorm:"primary_key" json:"id"`
+	User   string `gorm:"size:100;column:user;uniqueIndex:user_role" json:"user"`
+	RoleId uint   `gorm:"column:role_id;uniqueIndex:user_role" json:"roleId"`
+}
+
+func (UserRole) TableName() string {
+	return "rbac_user_roles"
+}
+
+// Models rbac 需要的表
+func Models() []interface{} {
+	return []interface{}{&Role{}, &RolePermission{}, &UserRole{}}
+}
+
+// Enabled 是否开启权限校验（RBAC::Enable）
+func Enabled() bool {
+	return beego.AppConfig.DefaultBool("RBAC::Enable", false)
+}
+
+// Perm 拼接权限字符串
+func Perm(service, action string) string {
+	return service + PermSeparator + action
+}
+
+// 用户权限缓存
+type permCache struct {
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	perms    []string
+	expireAt time.Time
+}
+
+var _cache = &permCache{entries: map[string]cacheEntry{}}
+
+// InvalidateCache 清空权限缓存（修改角色和权限后调用）
+func InvalidateCache() {
+	_cache.mu.Lock()
+	_cache.entries = map[string]cacheEntry{}
+	_cache.mu.Unlock()
+}
+
+// UserPermissions 获取用户的所有权限（缓存时间 RBAC::CacheSeconds，默认60秒）
+func UserPermissions(db *gorm.DB, uname string) (perms []string, err error) {
+	_cache.mu.RLock()
+	entry, ok := _cache.entries[uname]
+	_cache.mu.RUnlock()
+	if ok && time.Now().Before(entry.expireAt) {
+		return entry.perms, nil
+	}
+	if err = db.Model(&RolePermission{}).
+		Joins("JOIN "+UserRole{}.TableName()+" ur ON ur.role_id = "+RolePermission{}.TableName()+".role_id").
+		Where("ur.user = ?", uname).
+		Distinct().Pluck("permission", &perms).Error; err != nil {
+		logs.Error("get permissions of user[%s] failed, %s", uname, err.Error())
+		return
+	}
+	ttl := time.Duration(beego.AppConfig.DefaultInt("RBAC::CacheSeconds", 60)) * time.Second
+	_cache.mu.Lock()
+	_cache.entries[uname] = cacheEntry{perms: perms, expireAt: time.Now().Add(ttl)}
+	_cache.mu.Unlock()
+	return
+}
+
+// IsAdmin 超级管理员（RBAC::Admins 逗号分隔）拥有所有权限
+func IsAdmin(uname string) bool {
+	for _, admin := range strings.Split(beego.AppConfig.String("RBAC::Admins"), ",") {
+		if strings.TrimSpace(admin) == uname && uname != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed 判断用户是否拥有权限
+func Allowed(uname, perm string) (ok bool, err error) {
+	if IsAdmin(uname) {
+		return true, nil
+	}
+	var perms []string
+	var db *gorm.DB
+	if db, err = dbgorm.Get(dbgorm.DefaultName); err != nil {
+		return
+	}
+	if perms, err = UserPermissions(db, uname); err != nil {
+		return
+	}
+	return Match(perms, perm), nil
+}
+
+// Match 判断权限列表中是否有匹配的权限
+func Match(perms []string, perm string) bool {
+	service, action := splitPerm(perm)
+	for _, p := range perms {
+		if p == Wildcard {
+			return true
+		}
+		s, a := splitPerm(p)
+		if (s == Wildcard || s == service) && (a == Wildcard || a == action) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPerm(perm string) (service, action string) {
+	kv := strings.SplitN(perm, PermSeparator, 2)
+	if len(kv) == 1 {
+		return kv[0], Wildcard
+	}
+	return kv[0], kv[1]
+}
@@ -0,0 +1,82 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/astaxie/beego"
+	"github.com/daimall/tools/curd/dbmysql/dbgorm/testdb"
+	"gorm.io/gorm"
+)
+
+func Test_Match(t *testing.T) {
+	cases := []struct {
+		perms []string
+		perm  string
+		ok    bool
+	}{
+		{[]string{"supplier:GetAll"}, "supplier:GetAll", true},
+		{[]string{"supplier:GetAll"}, "supplier:Export", false},
+		{[]string{"supplier:GetAll"}, "customer:GetAll", false},
+		{[]string{"supplier:*"}, "supplier:Export", true},
+		{[]string{"supplier"}, "supplier:Delete", true}, // 没有action表示所有action
+		{[]string{"*:Export"}, "customer:Export", true},
+		{[]string{"*:Export"}, "customer:GetAll", false},
+		{[]string{"*"}, "customer:Delete", true},
+		{[]string{"supplier:GetAll", "customer:*"}, "customer:approve", true},
+		{nil, "supplier:GetAll", false},
+	}
+	for _, c := range cases {
+		if ok := Match(c.perms, c.perm); ok != c.ok {
+			t.Errorf("%v match %s: want %v, got %v", c.perms, c.perm, c.ok, ok)
+		}
+	}
+}
+
+type doc struct {
+	ID      uint
+	Creator string
+}
+
+func Test_RowScope(t *testing.T) {
+	db := testdb.New(t, append(Models(), &doc{})...)
+	for _, creator := range []string{"alice", "bob", "bob"} {
+		db.Create(&doc{Creator: creator})
+	}
+	CreateRole(db, "auditor", "")
+	Grant(db, "auditor", "doc:AllRows")
+	AssignRole(db, "carol", "auditor")
+	InvalidateCache()
+	defer InvalidateCache()
+	beego.AppConfig.Set("RBAC::Admins", "root")
+	defer beego.AppConfig.Set("RBAC::Admins", "")
+	RegisterRowScope("doc", func(uname string, db *gorm.DB) *gorm.DB {
+		if ok, _ := Allowed(uname, "doc:AllRows"); ok {
+			return db
+		}
+		return db.Where("creator = ?", uname)
+	})
+	defer func() {
+		scopeMu.Lock()
+		delete(rowScopes, "doc")
+		scopeMu.Unlock()
+	}()
+	for _, c := range []struct {
+		service, uname string
+		count          int64
+	}{
+		{"doc", "alice", 1},
+		{"doc", "bob", 2},
+		{"doc", "dave", 0},
+		{"doc", "carol", 3}, // 有权限查看全部
+		{"doc", "root", 3},  // 管理员不过滤
+		{"other", "dave", 3},
+	} {
+		var count int64
+		if err := db.Model(&doc{}).Scopes(RowScope(c.service, c.uname)).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != c.count {
+			t.Errorf("%s of %s: want %d, got %d", c.uname, c.service, c.count, count)
+		}
+	}
+}
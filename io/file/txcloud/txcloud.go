@@ -11,6 +11,7 @@ import (
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
+	"github.com/daimall/tools/aes/stream"
 	"github.com/tencentyun/cos-go-sdk-v5"
 )
 
@@ -60,6 +61,13 @@ func UploadFile(f io.Reader, fpath string) (downloadURL string, err error) {
 		},
 	}
 
+	// 配置了加密key时，边读边加密上传，不需要把文件读入内存
+	if key := beego.AppConfig.String("TXCloud::EncryptKey"); key != "" {
+		pr := encryptReader(f, key)
+		defer pr.Close() // 上传失败时结束加密goroutine
+		f = pr
+		opt.ContentType = "application/octet-stream"
+	}
 	_, err = client.Object.Put(context.Background(), fpath, f, opt)
 	if err != nil {
 		return
@@ -68,6 +76,52 @@ func UploadFile(f io.Reader, fpath string) (downloadURL string, err error) {
 	return
 }
 
+// 下载文件，配置了加密key时自动解密后写入w
+func DownloadFile(fpath string, w io.Writer) (err error) {
+	var (
+		client *cos.Client
+		resp   *cos.Response
+	)
+
+	client, err = getTxCloudClient()
+	if err != nil {
+		logs.Error("get txCloud client failed,", err.Error())
+		return
+	}
+	if resp, err = client.Object.Get(context.Background(), fpath, nil); err != nil {
+		logs.Error("get file[%s] failed, %s", fpath, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	var r io.Reader = resp.Body
+	if key := beego.AppConfig.String("TXCloud::EncryptKey"); key != "" {
+		if r, err = stream.NewDecryptingReader(resp.Body, key); err != nil {
+			logs.Error("decrypt file[%s] failed, %s", fpath, err.Error())
+			return
+		}
+	}
+	_, err = io.Copy(w, r)
+	return
+}
+
+// 返回加密后的数据流
+func encryptReader(f io.Reader, key string) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := stream.NewEncryptingWriter(pw, key)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(w, f); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
 // 通过匹配文件后缀获取contentType
 func getContentType(fpath string) (contentType string, err error) {
 	reg, err := regexp.Compile("\\.\\w+$")
@@ -90,6 +144,8 @@ func getContentType(fpath string) (contentType string, err error) {
 		contentType = "application/pdf"
 	case ".xml":
 		contentType = "text/xml"
+	case ".gz":
+		contentType = "application/gzip"
 	}
 	return
 }
@@ -7,7 +7,7 @@ import (
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/httplib"
 	"github.com/astaxie/beego/logs"
-	"github.com/daimall/tools/aes/cbc"
+	"github.com/daimall/tools/aes/secrets"
 	_ "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -232,14 +232,11 @@ func init() {
 		},
 	)
 
-	passwd := beego.AppConfig.String("JIRA::DBPasswd")
-	if pwdEncryptKey := beego.AppConfig.String("JIRA::PwdEncryptKey"); pwdEncryptKey != "" {
-		// 密码是加密形态，需要解密
-		if passwd, err = cbc.New(pwdEncryptKey).Decrypt(passwd); err != nil {
-			logs.Error("Decrypt JiraDB passwd failed, pwdKey: %s, ciphertext: %s, err:%s",
-				pwdEncryptKey, passwd, err.Error())
-			panic(err)
-		}
+	// 密码是加密形态时自动解密
+	var passwd string
+	if passwd, err = secrets.Resolve("JIRA", "DBPasswd"); err != nil {
+		logs.Error("Decrypt JiraDB passwd failed,", err.Error())
+		panic(err)
 	}
 
 	dsn := fmt.Sprintf(jiraDBSourceName, passwd)
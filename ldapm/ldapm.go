@@ -7,7 +7,7 @@ import (
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
-	"github.com/daimall/tools/aes/cbc"
+	"github.com/daimall/tools/aes/secrets"
 	ldap "github.com/go-ldap/ldap/v3"
 )
 
@@ -18,6 +18,8 @@ type ldapAdapter struct {
 	RootDN string //根域
 	User   string //管理员用户名
 	Passwd string //管理员用户密码
+
+	confErr error // LoadBeegoConf 的错误（如密码解密失败），连接时返回
 }
 
 var once sync.Once
@@ -48,6 +50,7 @@ func (la *ldapAdapter) SetRootDN(rootdn string) *ldapAdapter {
 func (la *ldapAdapter) SetAccount(user, passwd string) *ldapAdapter {
 	la.User = user
 	la.Passwd = passwd
+	la.confErr = nil
 	return la
 }
 
@@ -58,14 +61,9 @@ func (la *ldapAdapter) LoadBeegoConf() *ldapAdapter {
 	la.Region = beego.AppConfig.String("LDAP::REGION")
 	la.RootDN = beego.AppConfig.String("LDAP::ROOTDN")
 	la.User = beego.AppConfig.String("LDAP::BIND_USER")
-	la.Passwd = beego.AppConfig.String("LDAP::BIND_PWD")
-	if pwdEncryptKey := beego.AppConfig.String("LDAP::PwdEncryptKey"); pwdEncryptKey != "" {
-		// 密码是加密形态，需要解密
-		var err error
-		if la.Passwd, err = cbc.New(pwdEncryptKey).Decrypt(la.Passwd); err != nil {
-			logs.Error("Decrypt ladp passwd failed, pwdKey: %s, ciphertext: %s, err:%s",
-				pwdEncryptKey, la.Passwd, err.Error())
-		}
+	// 密码是加密形态时自动解密，解密失败时之后的查询和认证都返回错误
+	if la.Passwd, la.confErr = secrets.Resolve("LDAP", "BIND_PWD"); la.confErr != nil {
+		logs.Error("Decrypt ladp passwd failed,", la.confErr.Error())
 	}
 	return la
 }
@@ -73,14 +71,14 @@ func (la *ldapAdapter) LoadBeegoConf() *ldapAdapter {
 func (la *ldapAdapter) GetUsers(name string, f func([]*ldap.Entry) (interface{}, error)) (ret interface{}, err error) {
 	var l *ldap.Conn
 	var sr *ldap.SearchResult
-	if l, sr, err = la.ldapUserSearch("(&(objectcategory=person)(CN=*" + name + "*))"); err != nil {
+	if l, sr, err = la.ldapUserSearch("(&(objectcategory=person)(CN=*" + ldap.EscapeFilter(name) + "*))"); err != nil {
 		return nil, fmt.Errorf("GetUsers(CN)>ldapUserSearch failed, %s", err.Error())
 	}
 	defer l.Close()
 	if len(sr.Entries) == 0 {
 		var l2 *ldap.Conn
 		var sr2 *ldap.SearchResult
-		if l2, sr2, err = la.ldapUserSearch("(&(objectcategory=person)(sAMAccountName=*" + name + "*))"); err != nil {
+		if l2, sr2, err = la.ldapUserSearch("(&(objectcategory=person)(sAMAccountName=*" + ldap.EscapeFilter(name) + "*))"); err != nil {
 			return nil, fmt.Errorf("GetUsers(sAMAccountName)>ldapUserSearch failed, %s", err.Error())
 		}
 		defer l2.Close()
@@ -92,7 +90,7 @@ func (la *ldapAdapter) GetUsers(name string, f func([]*ldap.Entry) (interface{},
 func (la *ldapAdapter) GetUserInfo(account string, f func(*ldap.Entry) (interface{}, error)) (ret interface{}, err error) {
 	var l *ldap.Conn
 	var sr *ldap.SearchResult
-	if l, sr, err = la.ldapUserSearch("(sAMAccountName=" + account + ")"); err != nil {
+	if l, sr, err = la.ldapUserSearch("(sAMAccountName=" + ldap.EscapeFilter(account) + ")"); err != nil {
 		if len(sr.Entries) == 0 {
 			return nil, errors.New("no user found by account: " + account)
 		}
@@ -113,11 +111,12 @@ func (la *ldapAdapter) ValidateUser(username, userpwd string, f func(*ldap.Entry
 //ladpAuth ....
 //ladp 认证
 func (la *ldapAdapter) ladpAuth(username, userpwd string) (ret *ldap.Entry, err error) {
-	l, sr, err := la.ldapUserSearch("(sAMAccountName=" + username + ")")
-	defer l.Close()
+	// 用户名来自请求（Basic Auth），转义后再拼接过滤条件
+	l, sr, err := la.ldapUserSearch("(sAMAccountName=" + ldap.EscapeFilter(username) + ")")
 	if err != nil {
 		return nil, fmt.Errorf("ldapUserSearch failed, %s", err.Error())
 	}
+	defer l.Close()
 	if err != nil || len(sr.Entries) < 1 {
 		err = errors.New("user:" + username + " does not exist")
 		return nil, err
@@ -130,9 +129,8 @@ func (la *ldapAdapter) ladpAuth(username, userpwd string) (ret *ldap.Entry, err
 }
 
 func (la *ldapAdapter) ldapUserSearch(filter string) (l *ldap.Conn, sr *ldap.SearchResult, err error) {
-	if err != nil {
-		logs.Error("ldap port is not int: ", la.Port)
-		return nil, nil, err
+	if la.confErr != nil {
+		return nil, nil, fmt.Errorf("ldap config invalid, %s", la.confErr.Error())
 	}
 	l, err = ldap.Dial("tcp", fmt.Sprintf("%s:%d", la.IP, la.Port))
 	if err != nil {
@@ -5,7 +5,7 @@ import (
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
-	"github.com/daimall/tools/aes/cbc"
+	"github.com/daimall/tools/aes/secrets"
 	"github.com/go-redis/redis"
 )
 
@@ -25,16 +25,10 @@ func initRedisClient() {
 		Addr: beego.AppConfig.String("Redis::URL"),
 	}
 	var err error
-	redisPasswd := beego.AppConfig.String("Redis::Password")
-	redisOptions.Password = redisPasswd
-	// 密码解密
-	if pwdEncryptKey := beego.AppConfig.String("Redis::PwdEncryptKey"); pwdEncryptKey != "" {
-		// 密码是加密形态，需要解密
-		if redisOptions.Password, err = cbc.New(pwdEncryptKey).Decrypt(redisPasswd); err != nil {
-			logs.Error("Decrypt redis passwd failed, pwdKey: %s, ciphertext: %s, err:%s",
-				pwdEncryptKey, redisPasswd, err.Error())
-			os.Exit(-101)
-		}
+	// 密码是加密形态时自动解密
+	if redisOptions.Password, err = secrets.Resolve("Redis", "Password"); err != nil {
+		logs.Error("Decrypt redis passwd failed,", err.Error())
+		os.Exit(-101)
 	}
 	if redisOptions.DB, err = beego.AppConfig.Int("Redis::DB"); err != nil {
 		logs.Error("get redis db failed,", err.Error())
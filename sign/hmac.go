@@ -0,0 +1,20 @@
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HMACSHA256 使用 key 对 data 做 HMAC-SHA256 签名，返回十六进制字符串
+// 用于服务端自己签发、自己校验的数据（例如操作日志的检查点）；和调用方约定的请求参数签名使用 Sign
+func HMACSHA256(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyHMACSHA256 校验 HMACSHA256 的签名，按常量时间比较
+func VerifyHMACSHA256(key, data []byte, sign string) bool {
+	return hmac.Equal([]byte(HMACSHA256(key, data)), []byte(sign))
+}